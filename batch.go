@@ -17,6 +17,14 @@ type WriteBatch struct {
 	mu            *sync.Mutex
 	db            *DB
 	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	conditions    []*batchCondition          // 提交时需要满足的前置条件
+}
+
+// 提交时检查的前置条件，检查的是提交之前数据库中的数据
+type batchCondition struct {
+	key        []byte
+	value      []byte // key 当前的 value 必须等于该值
+	mustAbsent bool   // key 必须不存在
 }
 
 // NewWriteBatch 初始化 WriteBatch
@@ -69,7 +77,47 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	return nil
 }
 
+// CompareAndSwap 批量写数据，提交时 key 当前的 value 必须等于 oldValue
+func (wb *WriteBatch) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wb.conditions = append(wb.conditions, &batchCondition{key: key, value: oldValue})
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: newValue}
+	return nil
+}
+
+// PutIfAbsent 批量写数据，提交时 key 必须不存在
+func (wb *WriteBatch) PutIfAbsent(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wb.conditions = append(wb.conditions, &batchCondition{key: key, mustAbsent: true})
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// DeleteIfEquals 删除数据，提交时 key 当前的 value 必须等于 value
+func (wb *WriteBatch) DeleteIfEquals(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wb.conditions = append(wb.conditions, &batchCondition{key: key, value: value})
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
 // Commit 提交事务，将暂存的数据写到数据文件，并更新内存索引
+// 如果有前置条件不满足，则不写入任何数据并返回对应的错误
 func (wb *WriteBatch) Commit() error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
@@ -83,15 +131,44 @@ func (wb *WriteBatch) Commit() error {
 
 	// 加锁保证事务提交串行化
 	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	defer wb.db.unlockAndNotify()
+
+	// 检查前置条件
+	for _, cond := range wb.conditions {
+		var err error
+		if cond.mustAbsent {
+			err = wb.db.checkAbsent(cond.key)
+		} else {
+			err = wb.db.checkValue(cond.key, cond.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := wb.db.commitRecords(wb.pendingWrites, wb.options.SyncWrites); err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.conditions = nil
+
+	return nil
+}
 
+// 使用新的事务序列号将一批数据写到数据文件，并更新内存索引。该方法必须持有互斥锁
+func (db *DB) commitRecords(records map[string]*data.LogRecord, syncWrites bool) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	// 开始写数据到数据文件当中
 	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
+	for _, record := range records {
+		logRecordPos, err := db.appendLogRecord(&data.LogRecord{
 			Key:   logRecordKeyWithSeq(record.Key, seqNo),
 			Value: record.Value,
 			Type:  record.Type,
@@ -107,31 +184,36 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+	finishedPos, err := db.appendLogRecord(finishedRecord)
+	if err != nil {
 		return err
 	}
+	// 标识事务完成的记录本身是无效数据
+	db.addReclaimSize(finishedPos)
 
 	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+	if syncWrites && db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
 			return err
 		}
 	}
 
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
+	for _, record := range records {
 		pos := positions[string(record.Key)]
 		if record.Type == data.LogRecordNormal {
-			wb.db.index.Put(record.Key, pos)
+			if err := db.putIndex(record.Key, pos); err != nil {
+				return err
+			}
+			db.addWatchEvent(WatchPut, record.Key, record.Value, 0)
 		}
 		if record.Type == data.LogRecordDeleted {
-			wb.db.index.Delete(record.Key)
+			if err := db.deleteIndex(record.Key, pos); err != nil {
+				return err
+			}
+			db.addWatchEvent(WatchDelete, record.Key, nil, 0)
 		}
 	}
-
-	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
 	return nil
 }
 
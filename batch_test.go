@@ -108,3 +108,55 @@ func TestDB_WriteBatch2(t *testing.T) {
 //	//err = wb.Commit()
 //	//assert.Nil(t, err)
 //}
+
+func TestDB_WriteBatch_Conditions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-conditions")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+
+	// 前置条件在提交时检查，有一个不满足则全部不写入
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	err = wb.PutIfAbsent(utils.GetTestKey(2), []byte("c"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("other"))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Equal(t, ErrKeyAlreadyExists, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	wb2 := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb2.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	err = wb2.DeleteIfEquals(utils.GetTestKey(2), []byte("other"))
+	assert.Nil(t, err)
+	err = wb2.PutIfAbsent(utils.GetTestKey(3), []byte("c"))
+	assert.Nil(t, err)
+	err = wb2.Commit()
+	assert.Nil(t, err)
+
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	// value 不一致
+	wb3 := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb3.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = wb3.Commit()
+	assert.Equal(t, ErrValueMismatch, err)
+}
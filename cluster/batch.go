@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"encoding/binary"
+	"sync"
+)
+
+// 命令中的操作类型
+const (
+	operationPut byte = iota + 1
+	operationDelete
+)
+
+// 命令中的一个操作，一条日志的命令包含一个或多个操作，应用时原子写入
+type operation struct {
+	typ   byte
+	key   []byte
+	value []byte
+}
+
+// WriteBatch 批量写数据，提交时作为一条日志复制到所有节点，保证原子性
+type WriteBatch struct {
+	mu         sync.Mutex
+	node       *Node
+	operations []*operation
+}
+
+// NewWriteBatch 初始化 WriteBatch，只能在 leader 上提交
+func (n *Node) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{node: n}
+}
+
+// Put 批量写数据
+func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.operations = append(wb.operations, &operation{typ: operationPut, key: key, value: value})
+	return nil
+}
+
+// Delete 批量删除数据
+func (wb *WriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.operations = append(wb.operations, &operation{typ: operationDelete, key: key})
+	return nil
+}
+
+// Commit 提交事务，所有的数据应用到 leader 的数据库之后返回
+func (wb *WriteBatch) Commit() error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if len(wb.operations) == 0 {
+		return nil
+	}
+	if err := wb.node.propose(encodeCommand(wb.operations)); err != nil {
+		return err
+	}
+	wb.operations = nil
+	return nil
+}
+
+// 将命令应用到状态机数据库
+func applyCommand(db *bitcask.DB, command []byte, syncWrites bool) error {
+	// leader 当选时追加的空日志
+	if len(command) == 0 {
+		return nil
+	}
+	operations, err := decodeCommand(command)
+	if err != nil {
+		return err
+	}
+
+	if len(operations) == 1 {
+		return applyOperation(db, operations[0])
+	}
+	wb := db.NewWriteBatch(bitcask.WriteBatchOptions{MaxBatchNum: uint(len(operations)), SyncWrites: syncWrites})
+	for _, op := range operations {
+		if err := applyOperation(wb, op); err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// DB 和 bitcask.WriteBatch 都可以写入
+type writer interface {
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+}
+
+func applyOperation(w writer, op *operation) error {
+	if op.typ == operationDelete {
+		return w.Delete(op.key)
+	}
+	return w.Put(op.key, op.value)
+}
+
+// 编码命令：操作数量 + 每个操作的类型、key 和 value，长度使用变长编码
+func encodeCommand(operations []*operation) []byte {
+	size := binary.MaxVarintLen64
+	for _, op := range operations {
+		size += 1 + 2*binary.MaxVarintLen64 + len(op.key) + len(op.value)
+	}
+	buf := make([]byte, size)
+	index := binary.PutUvarint(buf, uint64(len(operations)))
+	for _, op := range operations {
+		buf[index] = op.typ
+		index++
+		index += binary.PutUvarint(buf[index:], uint64(len(op.key)))
+		index += copy(buf[index:], op.key)
+		index += binary.PutUvarint(buf[index:], uint64(len(op.value)))
+		index += copy(buf[index:], op.value)
+	}
+	return buf[:index]
+}
+
+func decodeCommand(buf []byte) ([]*operation, error) {
+	count, index := binary.Uvarint(buf)
+	if index <= 0 {
+		return nil, ErrInvalidCommand
+	}
+	var operations []*operation
+	for i := uint64(0); i < count; i++ {
+		if index >= len(buf) {
+			return nil, ErrInvalidCommand
+		}
+		op := &operation{typ: buf[index]}
+		index++
+		var err error
+		if op.key, index, err = decodeBytes(buf, index); err != nil {
+			return nil, err
+		}
+		if op.value, index, err = decodeBytes(buf, index); err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+	return operations, nil
+}
+
+// 读取变长编码的长度和对应的数据，返回下一个字段的位置
+func decodeBytes(buf []byte, index int) ([]byte, int, error) {
+	size, n := binary.Uvarint(buf[index:])
+	if n <= 0 || uint64(len(buf)-index-n) < size {
+		return nil, 0, ErrInvalidCommand
+	}
+	index += n
+	return buf[index : index+int(size)], index + int(size), nil
+}
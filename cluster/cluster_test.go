@@ -0,0 +1,391 @@
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCluster struct {
+	t       *testing.T
+	dir     string
+	network *InmemNetwork
+	ids     []string
+	nodes   map[string]*Node
+	options Options
+}
+
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cluster")
+	c := &testCluster{t: t, dir: dir, network: NewInmemNetwork(), nodes: make(map[string]*Node)}
+	for i := 0; i < size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("node-%d", i))
+	}
+	c.options = DefaultOptions
+	c.options.Peers = c.ids
+	c.options.ElectionTimeout = 150 * time.Millisecond
+	c.options.HeartbeatInterval = 30 * time.Millisecond
+	c.options.SnapshotThreshold = snapshotThreshold
+	c.options.RequestTimeout = time.Second
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	return c
+}
+
+func (c *testCluster) start(id string) {
+	opts := c.options
+	opts.ID = id
+	opts.DirPath = filepath.Join(c.dir, id)
+	opts.Transport = c.network.Transport(id)
+	node, err := Open(opts)
+	assert.Nil(c.t, err)
+	c.nodes[id] = node
+}
+
+func (c *testCluster) stop(id string) {
+	assert.Nil(c.t, c.nodes[id].Close())
+	delete(c.nodes, id)
+}
+
+func (c *testCluster) close() {
+	for id := range c.nodes {
+		c.stop(id)
+	}
+	_ = os.RemoveAll(c.dir)
+}
+
+// 等待产生 leader，exclude 中的节点不参与
+func (c *testCluster) leader(exclude ...string) *Node {
+	var leader *Node
+	waitFor(c.t, func() bool {
+		for id, node := range c.nodes {
+			excluded := false
+			for _, e := range exclude {
+				excluded = excluded || e == id
+			}
+			if !excluded && nodeStat(node).Role == Leader {
+				leader = node
+				return true
+			}
+		}
+		return false
+	})
+	return leader
+}
+
+// 等待所有的节点应用到相同的日志
+func (c *testCluster) waitApplied(index uint64) {
+	waitFor(c.t, func() bool {
+		for _, node := range c.nodes {
+			if nodeStat(node).AppliedIndex < index {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// 直接读取节点的数据库，不经过 leader
+func localGet(node *Node, key []byte) ([]byte, error) {
+	node.dbMu.RLock()
+	defer node.dbMu.RUnlock()
+	return node.db.Get(key)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not satisfied before timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func nodeStat(node *Node) NodeStat {
+	stat, _ := node.Stat()
+	return stat
+}
+
+func TestCluster_Replication(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.close()
+
+	leader := c.leader()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, leader.Delete(utils.GetTestKey(0)))
+	wb := leader.NewWriteBatch()
+	assert.Nil(t, wb.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, bitcask.ErrKeyIsEmpty, leader.Put(nil, []byte("v")))
+
+	val, err := leader.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	_, err = leader.Get(utils.GetTestKey(1))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	// 所有的节点应用相同的数据
+	c.waitApplied(nodeStat(leader).CommitIndex)
+	for _, node := range c.nodes {
+		val, err := localGet(node, utils.GetTestKey(99))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(99), val)
+		_, err = localGet(node, utils.GetTestKey(0))
+		assert.Equal(t, bitcask.ErrKeyNotFound, err)
+		val, err = localGet(node, []byte("k1"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+	}
+
+	// 只能在 leader 上读写
+	for _, node := range c.nodes {
+		if node == leader {
+			continue
+		}
+		assert.Equal(t, nodeStat(leader).ID, node.Leader())
+		assert.Equal(t, ErrNotLeader, node.Put([]byte("k2"), []byte("v2")))
+		_, err := node.Get([]byte("k1"))
+		assert.Equal(t, ErrNotLeader, err)
+	}
+}
+
+func TestCluster_LeaderFailover(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.close()
+
+	oldLeader := c.leader()
+	assert.Nil(t, oldLeader.Put([]byte("k1"), []byte("v1")))
+
+	// leader 和其他节点断开之后重新选举
+	oldID := nodeStat(oldLeader).ID
+	c.network.Disconnect(oldID)
+	leader := c.leader(oldID)
+	assert.True(t, nodeStat(leader).Term > nodeStat(oldLeader).Term)
+	assert.Nil(t, leader.Put([]byte("k2"), []byte("v2")))
+	val, err := leader.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 旧的 leader 无法确认自己的身份，读写都不会成功
+	_, err = oldLeader.Get([]byte("k2"))
+	assert.NotNil(t, err)
+	assert.NotNil(t, oldLeader.Put([]byte("k3"), []byte("v3")))
+
+	// 恢复连接之后旧的 leader 转换为 follower，没有提交的日志被覆盖
+	c.network.Connect(oldID)
+	assert.Nil(t, leader.Put([]byte("k4"), []byte("v4")))
+	c.waitApplied(nodeStat(leader).CommitIndex)
+	assert.Equal(t, Follower, nodeStat(oldLeader).Role)
+	val, err = localGet(oldLeader, []byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = localGet(oldLeader, []byte("k3"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
+
+func TestCluster_Snapshot(t *testing.T) {
+	c := newTestCluster(t, 3, 20)
+	defer c.close()
+
+	leader := c.leader()
+	var lagging string
+	for id, node := range c.nodes {
+		if node != leader {
+			lagging = id
+			break
+		}
+	}
+
+	// 落后的节点需要的日志已经被截断，通过快照同步
+	c.network.Disconnect(lagging)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i%10), utils.GetTestKey(i)))
+	}
+	stat := nodeStat(leader)
+	assert.True(t, stat.SnapshotIndex > 0)
+	assert.True(t, stat.LastIndex-stat.SnapshotIndex < 20)
+
+	c.network.Connect(lagging)
+	c.waitApplied(stat.CommitIndex)
+	assert.True(t, nodeStat(c.nodes[lagging]).SnapshotIndex > 0)
+	for i := 0; i < 10; i++ {
+		val, err := localGet(c.nodes[lagging], utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(90+i), val)
+	}
+
+	// 重启之后从快照和日志恢复数据
+	c.stop(lagging)
+	leader = c.leader()
+	assert.Nil(t, leader.Put([]byte("k1"), []byte("v1")))
+	c.start(lagging)
+	c.waitApplied(nodeStat(leader).CommitIndex)
+	val, err := localGet(c.nodes[lagging], []byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	val, err = localGet(c.nodes[lagging], utils.GetTestKey(9))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), val)
+}
+
+func TestCluster_Restart(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.close()
+
+	leader := c.leader()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	// 所有的节点重启之后重新选举，已经提交的数据不会丢失
+	for _, id := range c.ids {
+		c.stop(id)
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	leader = c.leader()
+	for i := 0; i < 10; i++ {
+		val, err := leader.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestCluster_SingleNode(t *testing.T) {
+	c := newTestCluster(t, 1, 5)
+	defer c.close()
+
+	leader := c.leader()
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	val, err := leader.Get(utils.GetTestKey(19))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(19), val)
+	assert.True(t, nodeStat(leader).SnapshotIndex > 0)
+}
+
+func TestTCPTransport(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cluster-tcp")
+	defer os.RemoveAll(dir)
+
+	var transports []*TCPTransport
+	var peers []string
+	for i := 0; i < 3; i++ {
+		transport, err := NewTCPTransport("127.0.0.1:0")
+		assert.Nil(t, err)
+		transports = append(transports, transport)
+		peers = append(peers, transport.Addr())
+	}
+
+	var nodes []*Node
+	for i, transport := range transports {
+		opts := DefaultOptions
+		opts.ID = peers[i]
+		opts.Peers = peers
+		opts.DirPath = filepath.Join(dir, fmt.Sprintf("node-%d", i))
+		opts.Transport = transport
+		node, err := Open(opts)
+		assert.Nil(t, err)
+		nodes = append(nodes, node)
+	}
+	defer func() {
+		for _, node := range nodes {
+			assert.Nil(t, node.Close())
+		}
+	}()
+
+	var leader *Node
+	waitFor(t, func() bool {
+		for _, node := range nodes {
+			if nodeStat(node).Role == Leader {
+				leader = node
+				return true
+			}
+		}
+		return false
+	})
+	assert.Nil(t, leader.Put([]byte("k1"), []byte("v1")))
+	val, err := leader.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	waitFor(t, func() bool {
+		for _, node := range nodes {
+			if nodeStat(node).AppliedIndex < nodeStat(leader).CommitIndex {
+				return false
+			}
+		}
+		return true
+	})
+	for _, node := range nodes {
+		val, err := localGet(node, []byte("k1"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+	}
+}
+
+func TestEncodeCommand(t *testing.T) {
+	operations := []*operation{
+		{typ: operationPut, key: []byte("k1"), value: []byte("v1")},
+		{typ: operationDelete, key: []byte("k2")},
+	}
+	decoded, err := decodeCommand(encodeCommand(operations))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(decoded))
+	assert.Equal(t, operationPut, decoded[0].typ)
+	assert.Equal(t, []byte("k1"), decoded[0].key)
+	assert.Equal(t, []byte("v1"), decoded[0].value)
+	assert.Equal(t, operationDelete, decoded[1].typ)
+	assert.Equal(t, []byte("k2"), decoded[1].key)
+
+	_, err = decodeCommand([]byte{2, operationPut, 10})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
+
+// 恢复快照失败之后停止应用日志，读写请求和 Close 都返回失败的错误
+func TestCluster_RestoreSnapshotFailed(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.close()
+
+	leader := c.leader()
+	assert.Nil(t, leader.Put([]byte("k1"), []byte("v1")))
+
+	// 快照文件不存在，恢复数据库时失败
+	stat := nodeStat(leader)
+	leader.mu.Lock()
+	leader.pendingSnapshot = &snapshotMeta{index: stat.AppliedIndex + 1, term: stat.Term, path: filepath.Join(c.dir, "missing-snapshot")}
+	leader.cond.Broadcast()
+	leader.mu.Unlock()
+
+	var applyErr error
+	waitFor(t, func() bool {
+		_, applyErr = leader.Stat()
+		return applyErr != nil
+	})
+	assert.True(t, os.IsNotExist(applyErr))
+	assert.Equal(t, applyErr, leader.Put([]byte("k2"), []byte("v2")))
+	_, err := leader.Get([]byte("k1"))
+	assert.Equal(t, applyErr, err)
+	wb := leader.NewWriteBatch()
+	assert.Nil(t, wb.Put([]byte("k3"), []byte("v3")))
+	assert.Equal(t, applyErr, wb.Commit())
+
+	// 其他节点不受影响
+	id := nodeStat(leader).ID
+	assert.Equal(t, applyErr, leader.Close())
+	delete(c.nodes, id)
+	leader = c.leader()
+	assert.Nil(t, leader.Put([]byte("k2"), []byte("v2")))
+}
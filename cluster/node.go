@@ -0,0 +1,585 @@
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dataDirName = "data"
+	raftDirName = "raft"
+)
+
+// Role 节点在 Raft 中的角色
+type Role = byte
+
+const (
+	Follower Role = iota + 1
+	Candidate
+	Leader
+)
+
+// NodeStat 节点的状态
+type NodeStat struct {
+	ID            string
+	Role          Role
+	Term          uint64
+	Leader        string // 当前已知的 leader，为空表示未知
+	LastIndex     uint64 // 最新的日志下标
+	CommitIndex   uint64 // 已经提交的日志下标
+	AppliedIndex  uint64 // 已经应用到数据库的日志下标
+	SnapshotIndex uint64 // 最新的快照包含的日志下标
+}
+
+// Node 使用 Raft 复制的 bitcask 节点
+// Put、Delete 和 WriteBatch 作为日志复制到集群中的所有节点，提交之后按照顺序应用到每个节点的数据库
+// 写入和读取都由 leader 处理，其他节点返回 ErrNotLeader，可以通过 Leader 获取 leader 的 id
+// 启动时从最新的快照恢复数据库，再重新应用快照之后的日志
+type Node struct {
+	options   Options
+	mu        *sync.Mutex
+	cond      *sync.Cond // 提交、应用、心跳确认和角色变化时通知等待的协程
+	dbMu      *sync.RWMutex
+	db        *bitcask.DB // 状态机数据库，只有应用日志的协程会写入和替换
+	storage   *storage
+	transport Transport
+
+	role             Role
+	currentTerm      uint64
+	votedFor         string
+	leaderID         string
+	log              []*LogEntry // log[0] 为快照中的最后一条日志，只记录下标和任期
+	commitIndex      uint64
+	lastApplied      uint64
+	electionDeadline time.Time
+
+	// leader 的状态
+	nextIndex    map[string]uint64
+	matchIndex   map[string]uint64
+	ackSeq       map[string]uint64 // 每个节点确认过的最新的心跳序号
+	heartbeatSeq uint64            // 读请求确认 leader 身份时递增
+	triggers     map[string]chan struct{}
+
+	snapshot        *snapshotMeta     // 最新的快照
+	receiver        *snapshotReceiver // 正在接收的快照
+	pendingSnapshot *snapshotMeta     // 已经接收完成、等待恢复到数据库的快照
+
+	proposals map[uint64]*proposal // 等待应用的写入，key 为日志下标
+	applyErr  error                // 恢复快照失败的错误，之后不再应用日志，读写请求都返回该错误
+	closed    bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// 等待应用的写入
+type proposal struct {
+	term uint64
+	done bool
+	err  error
+}
+
+// Open 打开集群中的一个节点
+func Open(options Options) (*Node, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	st, err := openStorage(filepath.Join(options.DirPath, raftDirName))
+	if err != nil {
+		return nil, err
+	}
+	mu := new(sync.Mutex)
+	n := &Node{
+		options:    options,
+		mu:         mu,
+		cond:       sync.NewCond(mu),
+		dbMu:       new(sync.RWMutex),
+		storage:    st,
+		transport:  options.Transport,
+		role:       Follower,
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		ackSeq:     make(map[string]uint64),
+		triggers:   make(map[string]chan struct{}),
+		proposals:  make(map[uint64]*proposal),
+		done:       make(chan struct{}),
+	}
+
+	if err := n.load(); err != nil {
+		_ = n.closeStorage()
+		return nil, err
+	}
+
+	for _, peer := range n.otherPeers() {
+		n.triggers[peer] = make(chan struct{}, 1)
+	}
+	n.resetElectionTimer()
+	if err := n.transport.Serve(&nodeHandler{n: n}); err != nil {
+		_ = n.closeStorage()
+		return nil, err
+	}
+
+	n.wg.Add(2)
+	go n.run()
+	go n.applyLoop()
+	for _, peer := range n.otherPeers() {
+		n.wg.Add(1)
+		go n.replicate(peer)
+	}
+	return n, nil
+}
+
+// 加载任期、投票、快照和日志，并从快照恢复数据库
+func (n *Node) load() error {
+	var err error
+	if n.currentTerm, n.votedFor, err = n.storage.loadState(); err != nil {
+		return err
+	}
+	if n.snapshot, err = loadLatestSnapshot(n.options.DirPath); err != nil {
+		return err
+	}
+
+	n.log = []*LogEntry{{}}
+	if n.snapshot != nil {
+		n.log[0] = &LogEntry{Index: n.snapshot.index, Term: n.snapshot.term}
+	}
+	entries, err := n.storage.loadEntries()
+	if err != nil {
+		return err
+	}
+	var stale []*LogEntry
+	for i, entry := range entries {
+		if entry.Index <= n.log[0].Index {
+			// 快照中的最后一条日志的任期不一致，说明之后的日志和快照冲突
+			if entry.Index == n.log[0].Index && entry.Term != n.log[0].Term {
+				stale = entries[i:]
+				break
+			}
+			continue
+		}
+		// 日志必须是连续的
+		if entry.Index != n.lastIndex()+1 {
+			stale = entries[i:]
+			break
+		}
+		n.log = append(n.log, entry)
+	}
+	if len(entries) > 0 && entries[0].Index <= n.log[0].Index {
+		if err := n.storage.deleteEntries(entries[0].Index, n.log[0].Index); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		if err := n.storage.deleteEntries(stale[0].Index, stale[len(stale)-1].Index); err != nil {
+			return err
+		}
+	}
+
+	n.commitIndex, n.lastApplied = n.log[0].Index, n.log[0].Index
+	path := ""
+	if n.snapshot != nil {
+		path = n.snapshot.path
+	}
+	return n.restoreDB(path)
+}
+
+func checkOptions(options Options) error {
+	if options.ID == "" {
+		return errors.New("the node id is empty")
+	}
+	if options.DirPath == "" {
+		return errors.New("the node directory is empty")
+	}
+	if options.Transport == nil {
+		return errors.New("the transport is nil")
+	}
+	found := false
+	for _, peer := range options.Peers {
+		if peer == options.ID {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("the peers must contain the node itself")
+	}
+	if options.ElectionTimeout <= 0 || options.HeartbeatInterval <= 0 || options.HeartbeatInterval >= options.ElectionTimeout {
+		return errors.New("the heartbeat interval must be positive and less than the election timeout")
+	}
+	return nil
+}
+
+// Put 写入数据，数据提交并应用到 leader 的数据库之后返回
+func (n *Node) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	return n.propose(encodeCommand([]*operation{{typ: operationPut, key: key, value: value}}))
+}
+
+// Delete 删除数据，数据提交并应用到 leader 的数据库之后返回
+func (n *Node) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	return n.propose(encodeCommand([]*operation{{typ: operationDelete, key: key}}))
+}
+
+// Get 线性一致地读取数据，只能在 leader 上读取
+// 读取之前先通过心跳确认自己仍然是 leader，并等待读取时已经提交的日志全部应用到数据库
+func (n *Node) Get(key []byte) ([]byte, error) {
+	if err := n.readIndex(); err != nil {
+		return nil, err
+	}
+	n.dbMu.RLock()
+	defer n.dbMu.RUnlock()
+	if n.db == nil {
+		return nil, ErrNodeClosed
+	}
+	return n.db.Get(key)
+}
+
+// Leader 返回当前已知的 leader 的 id，为空表示未知
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+// Stat 返回节点的状态，恢复快照失败之后同时返回失败的错误
+func (n *Node) Stat() (NodeStat, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	stat := NodeStat{
+		ID:           n.options.ID,
+		Role:         n.role,
+		Term:         n.currentTerm,
+		Leader:       n.leaderID,
+		LastIndex:    n.lastIndex(),
+		CommitIndex:  n.commitIndex,
+		AppliedIndex: n.lastApplied,
+	}
+	if n.snapshot != nil {
+		stat.SnapshotIndex = n.snapshot.index
+	}
+	return stat, n.applyErr
+}
+
+// Close 关闭节点，同时关闭 Transport 和数据库，之前恢复快照失败时返回失败的错误
+func (n *Node) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.done)
+	n.cond.Broadcast()
+	n.mu.Unlock()
+
+	err := n.transport.Close()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	n.closeReceiver()
+	applyErr := n.applyErr
+	n.mu.Unlock()
+	if closeErr := n.closeStorage(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if applyErr != nil {
+		return applyErr
+	}
+	return err
+}
+
+func (n *Node) closeStorage() error {
+	var err error
+	n.dbMu.Lock()
+	if n.db != nil {
+		err = n.db.Close()
+		n.db = nil
+	}
+	n.dbMu.Unlock()
+	if closeErr := n.storage.close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 追加一条日志，复制到多数节点并应用之后返回
+func (n *Node) propose(command []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	if n.applyErr != nil {
+		return n.applyErr
+	}
+	if n.role != Leader {
+		return ErrNotLeader
+	}
+
+	entry := &LogEntry{Index: n.lastIndex() + 1, Term: n.currentTerm, Command: command}
+	if err := n.appendLocal(entry); err != nil {
+		return err
+	}
+	p := &proposal{term: entry.Term}
+	n.proposals[entry.Index] = p
+	n.triggerReplication()
+	n.advanceCommit()
+
+	if err := n.waitFor(time.Now().Add(n.options.RequestTimeout), func() bool { return p.done }); err != nil {
+		if n.proposals[entry.Index] == p {
+			delete(n.proposals, entry.Index)
+		}
+		return err
+	}
+	return p.err
+}
+
+// 确认自己仍然是 leader，并等待当前已经提交的日志全部应用
+func (n *Node) readIndex() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	if n.applyErr != nil {
+		return n.applyErr
+	}
+	if n.role != Leader {
+		return ErrNotLeader
+	}
+	term := n.currentTerm
+	deadline := time.Now().Add(n.options.RequestTimeout)
+	lost := func() bool {
+		return n.role != Leader || n.currentTerm != term
+	}
+
+	// 新的 leader 提交当前任期的日志之后，才能确定 commitIndex 包含了之前所有已经提交的日志
+	err := n.waitFor(deadline, func() bool {
+		return lost() || n.entryTerm(n.commitIndex) == term
+	})
+	if err != nil {
+		return err
+	}
+	if lost() {
+		return ErrLeadershipLost
+	}
+	readIndex := n.commitIndex
+
+	// 多数节点确认心跳之后，说明在这之前没有产生新的 leader
+	n.heartbeatSeq++
+	seq := n.heartbeatSeq
+	n.triggerReplication()
+	err = n.waitFor(deadline, func() bool {
+		if lost() {
+			return true
+		}
+		acks := 1
+		for _, peer := range n.otherPeers() {
+			if n.ackSeq[peer] >= seq {
+				acks++
+			}
+		}
+		return n.isQuorum(acks)
+	})
+	if err != nil {
+		return err
+	}
+	if lost() {
+		return ErrLeadershipLost
+	}
+
+	return n.waitFor(deadline, func() bool { return n.lastApplied >= readIndex })
+}
+
+// 等待 cond 成立或者超时。该方法必须持有互斥锁
+func (n *Node) waitFor(deadline time.Time, cond func() bool) error {
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		n.mu.Lock()
+		n.cond.Broadcast()
+		n.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	for !cond() {
+		if n.closed {
+			return ErrNodeClosed
+		}
+		if n.applyErr != nil {
+			return n.applyErr
+		}
+		if !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+		n.cond.Wait()
+	}
+	return nil
+}
+
+// 按照顺序将已经提交的日志应用到数据库，满足条件时生成快照
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		for !n.closed && n.pendingSnapshot == nil && n.lastApplied >= n.commitIndex {
+			n.cond.Wait()
+		}
+		if n.closed {
+			n.mu.Unlock()
+			return
+		}
+
+		// 从接收到的快照恢复数据库
+		if snapshot := n.pendingSnapshot; snapshot != nil {
+			n.pendingSnapshot = nil
+			if snapshot.index <= n.lastApplied {
+				n.mu.Unlock()
+				continue
+			}
+			n.mu.Unlock()
+			if err := n.restoreDB(snapshot.path); err != nil {
+				log.Printf("failed to restore db from snapshot at index %d: %v\n", snapshot.index, err)
+				n.stopApply(err)
+				return
+			}
+			n.mu.Lock()
+			n.lastApplied = snapshot.index
+			// 快照中的日志无法确定是否是等待中的写入
+			for index, p := range n.proposals {
+				if index <= snapshot.index {
+					p.done, p.err = true, ErrLeadershipLost
+					delete(n.proposals, index)
+				}
+			}
+			n.cond.Broadcast()
+			n.mu.Unlock()
+			continue
+		}
+
+		base := n.log[0].Index
+		entries := make([]*LogEntry, n.commitIndex-n.lastApplied)
+		copy(entries, n.log[n.lastApplied+1-base:n.commitIndex+1-base])
+		n.mu.Unlock()
+
+		results := make([]error, len(entries))
+		for i, entry := range entries {
+			results[i] = applyCommand(n.db, entry.Command, n.options.DBOptions.SyncWrites)
+		}
+
+		n.mu.Lock()
+		n.lastApplied = entries[len(entries)-1].Index
+		for i, entry := range entries {
+			p, ok := n.proposals[entry.Index]
+			if !ok {
+				continue
+			}
+			p.done, p.err = true, results[i]
+			// 相同下标的日志被新的 leader 覆盖
+			if p.term != entry.Term {
+				p.err = ErrLeadershipLost
+			}
+			delete(n.proposals, entry.Index)
+		}
+		n.cond.Broadcast()
+
+		var snapshotIndex, snapshotTerm uint64
+		if n.options.SnapshotThreshold > 0 && n.pendingSnapshot == nil &&
+			n.lastApplied >= n.log[0].Index+n.options.SnapshotThreshold {
+			snapshotIndex, snapshotTerm = n.lastApplied, n.entryTerm(n.lastApplied)
+		}
+		n.mu.Unlock()
+
+		if snapshotIndex > 0 {
+			if err := n.takeSnapshot(snapshotIndex, snapshotTerm); err != nil {
+				log.Printf("failed to take snapshot at index %d: %v\n", snapshotIndex, err)
+			}
+		}
+	}
+}
+
+// 记录恢复快照失败的错误，等待中的写入都返回该错误，数据库已经关闭，之后不再应用日志
+func (n *Node) stopApply(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.applyErr = err
+	for index, p := range n.proposals {
+		p.done, p.err = true, err
+		delete(n.proposals, index)
+	}
+	n.cond.Broadcast()
+}
+
+// 使用快照替换数据库，path 为空时清空数据库
+func (n *Node) restoreDB(path string) error {
+	n.dbMu.Lock()
+	defer n.dbMu.Unlock()
+	if n.db != nil {
+		if err := n.db.Close(); err != nil {
+			return err
+		}
+		n.db = nil
+	}
+
+	dataDir := filepath.Join(n.options.DirPath, dataDirName)
+	if err := os.RemoveAll(dataDir); err != nil {
+		return err
+	}
+	if path != "" {
+		if err := extractSnapshotArchive(path, dataDir); err != nil {
+			return err
+		}
+	}
+
+	dbOptions := n.options.DBOptions
+	dbOptions.DirPath = dataDir
+	db, err := bitcask.Open(dbOptions)
+	if err != nil {
+		return err
+	}
+	n.db = db
+	return nil
+}
+
+func (n *Node) resetElectionTimer() {
+	timeout := n.options.ElectionTimeout + time.Duration(rand.Int63n(int64(n.options.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) otherPeers() []string {
+	peers := make([]string, 0, len(n.options.Peers)-1)
+	for _, peer := range n.options.Peers {
+		if peer != n.options.ID {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (n *Node) isQuorum(count int) bool {
+	return count*2 > len(n.options.Peers)
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// 获取下标对应的日志的任期，下标必须在 log[0] 和最新的日志之间
+func (n *Node) entryTerm(index uint64) uint64 {
+	return n.log[index-n.log[0].Index].Term
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotLeader       = errors.New("the node is not the leader")
+	ErrLeadershipLost  = errors.New("leadership lost before the request was applied")
+	ErrTimeout         = errors.New("the request timed out")
+	ErrNodeClosed      = errors.New("the node has been closed")
+	ErrUnreachable     = errors.New("the peer is unreachable")
+	ErrInvalidSnapshot = errors.New("the snapshot archive is invalid")
+	ErrInvalidCommand  = errors.New("the log entry command is invalid")
+)
+
+// Options 集群节点配置项
+type Options struct {
+	// 节点 id，同时也是节点在 Transport 中的地址
+	ID string
+
+	// 集群中所有节点的 id，包括自己
+	Peers []string
+
+	// 节点的数据目录，状态机数据库、Raft 日志和快照都保存在该目录下
+	DirPath string
+
+	// 状态机数据库的配置项，其中的 DirPath 会被忽略
+	DBOptions bitcask.Options
+
+	// 节点之间通信的方式
+	Transport Transport
+
+	// 选举超时时间，实际的超时时间在 ElectionTimeout 和 2*ElectionTimeout 之间随机选择
+	ElectionTimeout time.Duration
+
+	// leader 发送心跳的间隔，需要小于 ElectionTimeout
+	HeartbeatInterval time.Duration
+
+	// 快照之后应用的日志达到多少条时生成新的快照并截断日志，为 0 表示不生成快照
+	SnapshotThreshold uint64
+
+	// 写入和读取等待的最长时间
+	RequestTimeout time.Duration
+}
+
+var DefaultOptions = Options{
+	DBOptions:         bitcask.DefaultOptions,
+	ElectionTimeout:   300 * time.Millisecond,
+	HeartbeatInterval: 50 * time.Millisecond,
+	SnapshotThreshold: 10000,
+	RequestTimeout:    3 * time.Second,
+}
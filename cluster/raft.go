@@ -0,0 +1,391 @@
+package cluster
+
+import (
+	"log"
+	"time"
+)
+
+// 每次复制日志时最多发送的日志条数
+const maxAppendEntries = 256
+
+// 定期检查选举是否超时
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.options.ElectionTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if !n.closed && n.role != Leader && time.Now().After(n.electionDeadline) {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// 增加任期并向其他节点请求投票。该方法必须持有互斥锁
+func (n *Node) startElection() {
+	n.role = Candidate
+	n.currentTerm++
+	n.votedFor = n.options.ID
+	n.leaderID = ""
+	n.cond.Broadcast()
+	n.resetElectionTimer()
+	if err := n.persistState(); err != nil {
+		log.Printf("failed to persist raft state: %v\n", err)
+		return
+	}
+
+	term := n.currentTerm
+	args := &RequestVoteArgs{
+		Term:         term,
+		CandidateID:  n.options.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	votes := 1
+	if n.isQuorum(votes) {
+		n.becomeLeader()
+		return
+	}
+
+	for _, peer := range n.otherPeers() {
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+			reply := &RequestVoteReply{}
+			if err := n.transport.RequestVote(peer, args, reply); err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.currentTerm {
+				n.stepDown(reply.Term)
+				return
+			}
+			if n.role != Candidate || n.currentTerm != term || !reply.VoteGranted {
+				return
+			}
+			votes++
+			if n.isQuorum(votes) {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// 当选为 leader，追加一条当前任期的空日志。该方法必须持有互斥锁
+// 空日志提交之后，之前任期的日志也都已经提交，leader 才可以处理读请求
+func (n *Node) becomeLeader() {
+	n.role = Leader
+	n.leaderID = n.options.ID
+	for _, peer := range n.otherPeers() {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	if err := n.appendLocal(&LogEntry{Index: n.lastIndex() + 1, Term: n.currentTerm}); err != nil {
+		log.Printf("failed to append log entry: %v\n", err)
+	}
+	n.cond.Broadcast()
+	n.triggerReplication()
+	n.advanceCommit()
+}
+
+// 转换为 follower，任期更新时清空投票。该方法必须持有互斥锁
+func (n *Node) stepDown(term uint64) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.leaderID = ""
+		if err := n.persistState(); err != nil {
+			log.Printf("failed to persist raft state: %v\n", err)
+		}
+	}
+	if n.role == Leader {
+		n.resetElectionTimer()
+	}
+	n.role = Follower
+	n.cond.Broadcast()
+}
+
+func (n *Node) persistState() error {
+	return n.storage.saveState(n.currentTerm, n.votedFor)
+}
+
+// 持久化之后追加到内存中的日志。该方法必须持有互斥锁
+func (n *Node) appendLocal(entries ...*LogEntry) error {
+	if err := n.storage.appendEntries(entries); err != nil {
+		return err
+	}
+	n.log = append(n.log, entries...)
+	return nil
+}
+
+// 通知所有的复制协程立即发送日志。该方法必须持有互斥锁
+func (n *Node) triggerReplication() {
+	for _, peer := range n.otherPeers() {
+		n.trigger(peer)
+	}
+}
+
+func (n *Node) trigger(peer string) {
+	select {
+	case n.triggers[peer] <- struct{}{}:
+	default:
+	}
+}
+
+// 向一个节点复制日志的协程，有新的日志时立即发送，否则定期发送心跳
+func (n *Node) replicate(peer string) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.options.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-n.triggers[peer]:
+		case <-ticker.C:
+		}
+		n.sendAppendEntries(peer)
+	}
+}
+
+func (n *Node) sendAppendEntries(peer string) {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return
+	}
+	term, seq := n.currentTerm, n.heartbeatSeq
+	next := n.nextIndex[peer]
+	base := n.log[0].Index
+	// 需要发送的日志已经被快照截断
+	if next <= base {
+		snapshot := n.snapshot
+		n.mu.Unlock()
+		n.sendSnapshot(peer, term, seq, snapshot)
+		return
+	}
+
+	last := n.lastIndex()
+	if last-next+1 > maxAppendEntries {
+		last = next + maxAppendEntries - 1
+	}
+	args := &AppendEntriesArgs{
+		Term:         term,
+		LeaderID:     n.options.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.entryTerm(next - 1),
+		Entries:      append([]*LogEntry(nil), n.log[next-base:last+1-base]...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	reply := &AppendEntriesReply{}
+	if err := n.transport.AppendEntries(peer, args, reply); err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.handleReply(peer, term, seq, reply.Term) {
+		return
+	}
+	if reply.Success {
+		n.updateMatchIndex(peer, args.PrevLogIndex+uint64(len(args.Entries)))
+		return
+	}
+
+	// 回退到冲突的位置重新发送
+	next = reply.ConflictIndex
+	if next > args.PrevLogIndex {
+		next = args.PrevLogIndex
+	}
+	if next < 1 {
+		next = 1
+	}
+	n.nextIndex[peer] = next
+	n.trigger(peer)
+}
+
+// 处理响应中的任期，返回是否仍然是该任期的 leader。该方法必须持有互斥锁
+// 同一个任期的节点响应说明该节点认可 leader 的身份，记录确认过的心跳序号
+func (n *Node) handleReply(peer string, term uint64, seq uint64, replyTerm uint64) bool {
+	if replyTerm > n.currentTerm {
+		n.stepDown(replyTerm)
+		return false
+	}
+	if n.role != Leader || n.currentTerm != term {
+		return false
+	}
+	if seq > n.ackSeq[peer] {
+		n.ackSeq[peer] = seq
+		n.cond.Broadcast()
+	}
+	return true
+}
+
+// 节点已经复制到 match 之前的日志。该方法必须持有互斥锁
+func (n *Node) updateMatchIndex(peer string, match uint64) {
+	if match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+	}
+	if match+1 > n.nextIndex[peer] {
+		n.nextIndex[peer] = match + 1
+	}
+	n.advanceCommit()
+	if n.matchIndex[peer] < n.lastIndex() {
+		n.trigger(peer)
+	}
+}
+
+// 当前任期的日志复制到多数节点之后提交。该方法必须持有互斥锁
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.entryTerm(index) != n.currentTerm {
+			return
+		}
+		count := 1
+		for _, peer := range n.otherPeers() {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if n.isQuorum(count) {
+			n.commitIndex = index
+			n.cond.Broadcast()
+			return
+		}
+	}
+}
+
+// 将 Node 的请求处理方法暴露给 Transport
+type nodeHandler struct {
+	n *Node
+}
+
+func (h *nodeHandler) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	return h.n.handleRequestVote(args, reply)
+}
+
+func (h *nodeHandler) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return h.n.handleAppendEntries(args, reply)
+}
+
+func (h *nodeHandler) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return h.n.handleInstallSnapshot(args, reply)
+}
+
+func (n *Node) handleRequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	if args.Term > n.currentTerm {
+		n.stepDown(args.Term)
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+
+	// 只投票给日志至少和自己一样新的节点
+	upToDate := args.LastLogTerm > n.lastTerm() ||
+		(args.LastLogTerm == n.lastTerm() && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		n.votedFor = args.CandidateID
+		if err := n.persistState(); err != nil {
+			return err
+		}
+		reply.VoteGranted = true
+		n.resetElectionTimer()
+	}
+	return nil
+}
+
+func (n *Node) handleAppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+	n.acceptLeader(args.Term, args.LeaderID)
+	reply.Term = n.currentTerm
+
+	base := n.log[0].Index
+	if args.PrevLogIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return nil
+	}
+	entries := args.Entries
+	if args.PrevLogIndex < base {
+		// 快照中已经包含的日志不需要再追加
+		skip := base - args.PrevLogIndex
+		if uint64(len(entries)) <= skip {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+	} else if n.entryTerm(args.PrevLogIndex) != args.PrevLogTerm {
+		// 跳过冲突的任期中的所有日志
+		conflictTerm := n.entryTerm(args.PrevLogIndex)
+		index := args.PrevLogIndex
+		for index > base+1 && n.entryTerm(index-1) == conflictTerm {
+			index--
+		}
+		reply.ConflictIndex = index
+		return nil
+	}
+
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndex() {
+			if n.entryTerm(entry.Index) == entry.Term {
+				continue
+			}
+			// 删除冲突的日志以及之后所有的日志
+			if err := n.storage.deleteEntries(entry.Index, n.lastIndex()); err != nil {
+				return err
+			}
+			n.log = n.log[:entry.Index-base]
+		}
+		if err := n.appendLocal(entries[i:]...); err != nil {
+			return err
+		}
+		break
+	}
+
+	if args.LeaderCommit > n.commitIndex {
+		lastNew := args.PrevLogIndex + uint64(len(args.Entries))
+		commit := args.LeaderCommit
+		if lastNew < commit {
+			commit = lastNew
+		}
+		if commit > n.commitIndex {
+			n.commitIndex = commit
+			n.cond.Broadcast()
+		}
+	}
+	reply.Success = true
+	return nil
+}
+
+// 收到当前任期的 leader 的请求。该方法必须持有互斥锁
+func (n *Node) acceptLeader(term uint64, leaderID string) {
+	if term > n.currentTerm || n.role != Follower {
+		n.stepDown(term)
+	}
+	n.leaderID = leaderID
+	n.resetElectionTimer()
+}
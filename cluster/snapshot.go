@@ -0,0 +1,328 @@
+package cluster
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	snapshotFileFormat = "snapshot-%d-%d.tar"
+	snapshotChunkSize  = 1024 * 1024
+	snapshotBackupDir  = "snapshot-backup"
+)
+
+// 快照文件，文件名中包含快照中最后一条日志的下标和任期
+// 快照是数据库 Backup 之后打包的 tar 文件，生成快照之后截断快照中包含的日志
+type snapshotMeta struct {
+	index uint64
+	term  uint64
+	path  string
+}
+
+// 正在接收的快照
+type snapshotReceiver struct {
+	index  uint64
+	term   uint64
+	offset int64
+	file   *os.File
+}
+
+// 查找最新的快照，同时删除旧的快照和没有完成的临时文件
+func loadLatestSnapshot(dirPath string) (*snapshotMeta, error) {
+	if err := os.RemoveAll(filepath.Join(dirPath, snapshotBackupDir)); err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*snapshotMeta
+	var latest *snapshotMeta
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "snapshot-") || entry.IsDir() {
+			continue
+		}
+		meta := &snapshotMeta{path: filepath.Join(dirPath, name)}
+		_, err := fmt.Sscanf(name, snapshotFileFormat, &meta.index, &meta.term)
+		if err != nil || name != fmt.Sprintf(snapshotFileFormat, meta.index, meta.term) {
+			// 没有完成的快照
+			if err := os.Remove(meta.path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		snapshots = append(snapshots, meta)
+		if latest == nil || meta.index > latest.index {
+			latest = meta
+		}
+	}
+
+	for _, meta := range snapshots {
+		if meta != latest {
+			if err := os.Remove(meta.path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return latest, nil
+}
+
+// 数据库应用到 index 时生成快照，并截断快照中包含的日志
+// 只在应用日志的协程中调用，生成快照时数据库不会写入
+func (n *Node) takeSnapshot(index uint64, term uint64) error {
+	backupDir := filepath.Join(n.options.DirPath, snapshotBackupDir)
+	if err := os.RemoveAll(backupDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(backupDir)
+	if err := n.db.Backup(backupDir); err != nil {
+		return err
+	}
+	path := filepath.Join(n.options.DirPath, fmt.Sprintf(snapshotFileFormat, index, term))
+	if err := writeSnapshotArchive(backupDir, path); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// 这期间已经安装了更新的快照
+	if n.closed || index <= n.log[0].Index {
+		return os.Remove(path)
+	}
+	return n.compactLog(&snapshotMeta{index: index, term: term, path: path})
+}
+
+// 使用新的快照替换旧的快照，并删除快照中包含的日志。该方法必须持有互斥锁
+func (n *Node) compactLog(snapshot *snapshotMeta) error {
+	base := n.log[0].Index
+	last := n.lastIndex()
+	compacted := []*LogEntry{{Index: snapshot.index, Term: snapshot.term}}
+	if snapshot.index < last && n.entryTerm(snapshot.index) == snapshot.term {
+		// 保留快照之后的日志
+		compacted = append(compacted, n.log[snapshot.index+1-base:]...)
+		last = snapshot.index
+	}
+	n.log = compacted
+
+	old := n.snapshot
+	n.snapshot = snapshot
+	if old != nil && old.path != snapshot.path {
+		if err := os.Remove(old.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return n.storage.deleteEntries(base+1, last)
+}
+
+// 分块发送快照，发送完成之后从快照之后的日志开始复制
+func (n *Node) sendSnapshot(peer string, term uint64, seq uint64, snapshot *snapshotMeta) {
+	file, err := os.Open(snapshot.path)
+	if err != nil {
+		// 快照已经被更新的快照替换，下一次发送新的快照
+		return
+	}
+	defer file.Close()
+
+	buf := make([]byte, snapshotChunkSize)
+	var offset int64
+	for {
+		size, err := io.ReadFull(file, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return
+		}
+		args := &InstallSnapshotArgs{
+			Term:              term,
+			LeaderID:          n.options.ID,
+			LastIncludedIndex: snapshot.index,
+			LastIncludedTerm:  snapshot.term,
+			Offset:            offset,
+			Data:              append([]byte(nil), buf[:size]...),
+			Done:              size < len(buf),
+		}
+		reply := &InstallSnapshotReply{}
+		if err := n.transport.InstallSnapshot(peer, args, reply); err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		if !n.handleReply(peer, term, seq, reply.Term) {
+			n.mu.Unlock()
+			return
+		}
+		if args.Done {
+			n.updateMatchIndex(peer, snapshot.index)
+			n.mu.Unlock()
+			return
+		}
+		n.mu.Unlock()
+		offset += int64(size)
+	}
+}
+
+func (n *Node) handleInstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	reply.Term = n.currentTerm
+	if args.Term < n.currentTerm {
+		return nil
+	}
+	n.acceptLeader(args.Term, args.LeaderID)
+	reply.Term = n.currentTerm
+
+	// 已经包含快照中的所有日志
+	if args.LastIncludedIndex <= n.log[0].Index {
+		return nil
+	}
+
+	if args.Offset == 0 {
+		n.closeReceiver()
+		name := fmt.Sprintf(snapshotFileFormat, args.LastIncludedIndex, args.LastIncludedTerm) + ".tmp"
+		file, err := os.Create(filepath.Join(n.options.DirPath, name))
+		if err != nil {
+			return err
+		}
+		n.receiver = &snapshotReceiver{index: args.LastIncludedIndex, term: args.LastIncludedTerm, file: file}
+	}
+	r := n.receiver
+	// 不是连续的数据块，leader 之后会重新发送完整的快照
+	if r == nil || r.index != args.LastIncludedIndex || r.term != args.LastIncludedTerm || r.offset != args.Offset {
+		return nil
+	}
+	if _, err := r.file.Write(args.Data); err != nil {
+		n.closeReceiver()
+		return err
+	}
+	r.offset += int64(len(args.Data))
+	if !args.Done {
+		return nil
+	}
+
+	if err := r.file.Sync(); err != nil {
+		n.closeReceiver()
+		return err
+	}
+	n.receiver = nil
+	tmpPath := r.file.Name()
+	if err := r.file.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	path := strings.TrimSuffix(tmpPath, ".tmp")
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	snapshot := &snapshotMeta{index: args.LastIncludedIndex, term: args.LastIncludedTerm, path: path}
+	if err := n.compactLog(snapshot); err != nil {
+		return err
+	}
+	if snapshot.index > n.commitIndex {
+		n.commitIndex = snapshot.index
+	}
+	n.pendingSnapshot = snapshot
+	n.cond.Broadcast()
+	return nil
+}
+
+// 关闭正在接收的快照并删除临时文件。该方法必须持有互斥锁
+func (n *Node) closeReceiver() {
+	if n.receiver == nil {
+		return
+	}
+	_ = n.receiver.file.Close()
+	_ = os.Remove(n.receiver.file.Name())
+	n.receiver = nil
+}
+
+// 将 Backup 的目录打包为 tar 文件，先写到临时文件再重命名
+func writeSnapshotArchive(srcDir string, path string) error {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path + ".tmp")
+	if err := writeArchive(file, srcDir); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func writeArchive(w io.Writer, srcDir string) error {
+	dirEntries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(srcDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: entry.Name(), Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 将快照解压到数据库目录
+func extractSnapshotArchive(path string, destDir string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return ErrInvalidSnapshot
+		}
+		name := header.Name
+		if header.Typeflag != tar.TypeReg || name != filepath.Base(name) || name == "." || name == ".." {
+			return ErrInvalidSnapshot
+		}
+		dest, err := os.Create(filepath.Join(destDir, name))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dest, tr); err != nil {
+			_ = dest.Close()
+			return err
+		}
+		if err := dest.Close(); err != nil {
+			return err
+		}
+	}
+}
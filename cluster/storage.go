@@ -0,0 +1,133 @@
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"encoding/binary"
+	"time"
+)
+
+var (
+	stateKey     = []byte("state")
+	logKeyPrefix = []byte("l")
+)
+
+// 使用一个单独的 bitcask 实例持久化 Raft 的任期、投票和日志，所有的写入都会持久化到磁盘
+// 日志的 key 为前缀和大端序的下标，遍历时按照下标的顺序返回
+type storage struct {
+	db *bitcask.DB
+}
+
+func openStorage(dirPath string) (*storage, error) {
+	options := bitcask.DefaultOptions
+	options.DirPath = dirPath
+	options.DataFileSize = 64 * 1024 * 1024
+	options.SyncWrites = true
+	// 截断的日志需要通过 merge 清理
+	options.AutoMergeInterval = time.Minute
+	options.AutoMergeMinReclaimSize = options.DataFileSize
+	db, err := bitcask.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	return &storage{db: db}, nil
+}
+
+// 保存当前任期和投票给的节点
+func (s *storage) saveState(term uint64, votedFor string) error {
+	buf := make([]byte, binary.MaxVarintLen64+len(votedFor))
+	index := binary.PutUvarint(buf, term)
+	index += copy(buf[index:], votedFor)
+	return s.db.Put(stateKey, buf[:index])
+}
+
+func (s *storage) loadState() (uint64, string, error) {
+	buf, err := s.db.Get(stateKey)
+	if err == bitcask.ErrKeyNotFound {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	term, n := binary.Uvarint(buf)
+	return term, string(buf[n:]), nil
+}
+
+// 追加日志，下标相同的日志会被覆盖
+func (s *storage) appendEntries(entries []*LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	opts := bitcask.DefaultWriteBatchOptions
+	opts.MaxBatchNum = uint(len(entries))
+	wb := s.db.NewWriteBatch(opts)
+	for _, entry := range entries {
+		if err := wb.Put(encodeLogKey(entry.Index), encodeLogEntry(entry)); err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// 删除下标在 [from, to] 之间的日志
+func (s *storage) deleteEntries(from uint64, to uint64) error {
+	if from > to {
+		return nil
+	}
+	opts := bitcask.DefaultWriteBatchOptions
+	opts.MaxBatchNum = uint(to - from + 1)
+	wb := s.db.NewWriteBatch(opts)
+	for index := from; index <= to; index++ {
+		if err := wb.Delete(encodeLogKey(index)); err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// 按照下标的顺序加载所有的日志
+func (s *storage) loadEntries() ([]*LogEntry, error) {
+	iterOpts := bitcask.DefaultIteratorOptions
+	iterOpts.Prefix = logKeyPrefix
+	iter := s.db.NewIterator(iterOpts)
+	defer iter.Close()
+
+	var entries []*LogEntry
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		if err != nil {
+			return nil, err
+		}
+		entry := decodeLogEntry(value)
+		entry.Index = binary.BigEndian.Uint64(iter.Key()[len(logKeyPrefix):])
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *storage) close() error {
+	return s.db.Close()
+}
+
+func encodeLogKey(index uint64) []byte {
+	key := make([]byte, len(logKeyPrefix)+8)
+	copy(key, logKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(logKeyPrefix):], index)
+	return key
+}
+
+// 日志的 value 为任期和命令
+func encodeLogEntry(entry *LogEntry) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+len(entry.Command))
+	index := binary.PutUvarint(buf, entry.Term)
+	index += copy(buf[index:], entry.Command)
+	return buf[:index]
+}
+
+func decodeLogEntry(buf []byte) *LogEntry {
+	term, n := binary.Uvarint(buf)
+	entry := &LogEntry{Term: term}
+	if len(buf) > n {
+		entry.Command = buf[n:]
+	}
+	return entry
+}
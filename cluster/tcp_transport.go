@@ -0,0 +1,187 @@
+package cluster
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+const tcpCallTimeout = 2 * time.Second
+
+// TCPTransport 基于 net/rpc 的 Transport，节点的 id 为 TCP 地址
+type TCPTransport struct {
+	mu       sync.Mutex
+	listener net.Listener
+	server   *rpc.Server
+	conns    map[net.Conn]struct{}
+	clients  map[string]*rpc.Client
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewTCPTransport 监听 TCP 地址，地址的端口为 0 时可以通过 Addr 获取实际监听的地址
+func NewTCPTransport(addr string) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPTransport{
+		listener: listener,
+		server:   rpc.NewServer(),
+		conns:    make(map[net.Conn]struct{}),
+		clients:  make(map[string]*rpc.Client),
+	}, nil
+}
+
+// Addr 返回监听的地址
+func (t *TCPTransport) Addr() string {
+	return t.listener.Addr().String()
+}
+
+// Serve 注册 handler 并在后台接收连接
+func (t *TCPTransport) Serve(handler Handler) error {
+	if err := t.server.RegisterName("Raft", &tcpService{handler: handler}); err != nil {
+		return err
+	}
+	t.wg.Add(1)
+	go t.accept()
+	return nil
+}
+
+func (t *TCPTransport) accept() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		t.conns[conn] = struct{}{}
+		t.wg.Add(1)
+		t.mu.Unlock()
+
+		go func() {
+			defer t.wg.Done()
+			t.server.ServeConn(conn)
+			t.mu.Lock()
+			delete(t.conns, conn)
+			t.mu.Unlock()
+		}()
+	}
+}
+
+func (t *TCPTransport) RequestVote(target string, args *RequestVoteArgs, reply *RequestVoteReply) error {
+	return t.call(target, "Raft.RequestVote", args, reply)
+}
+
+func (t *TCPTransport) AppendEntries(target string, args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return t.call(target, "Raft.AppendEntries", args, reply)
+}
+
+func (t *TCPTransport) InstallSnapshot(target string, args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return t.call(target, "Raft.InstallSnapshot", args, reply)
+}
+
+// 调用目标节点的方法，连接出错时关闭连接，下一次调用重新连接
+func (t *TCPTransport) call(target string, method string, args interface{}, reply interface{}) error {
+	client, err := t.client(target)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(tcpCallTimeout):
+		err = ErrTimeout
+	}
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		t.mu.Lock()
+		if t.clients[target] == client {
+			delete(t.clients, target)
+		}
+		t.mu.Unlock()
+		_ = client.Close()
+	}
+	return err
+}
+
+func (t *TCPTransport) client(target string) (*rpc.Client, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrNodeClosed
+	}
+	if client, ok := t.clients[target]; ok {
+		t.mu.Unlock()
+		return client, nil
+	}
+	t.mu.Unlock()
+
+	conn, err := net.DialTimeout("tcp", target, tcpCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+	client := rpc.NewClient(conn)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		_ = client.Close()
+		return nil, ErrNodeClosed
+	}
+	// 并发调用时可能已经建立了连接
+	if existing, ok := t.clients[target]; ok {
+		_ = client.Close()
+		return existing, nil
+	}
+	t.clients[target] = client
+	return client, nil
+}
+
+// Close 关闭监听和所有的连接
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	err := t.listener.Close()
+	for conn := range t.conns {
+		_ = conn.Close()
+	}
+	for _, client := range t.clients {
+		_ = client.Close()
+	}
+	t.clients = nil
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	return err
+}
+
+// 注册到 net/rpc 的服务
+type tcpService struct {
+	handler Handler
+}
+
+func (s *tcpService) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	return s.handler.RequestVote(args, reply)
+}
+
+func (s *tcpService) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return s.handler.AppendEntries(args, reply)
+}
+
+func (s *tcpService) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return s.handler.InstallSnapshot(args, reply)
+}
@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"sync"
+)
+
+// LogEntry Raft 日志中的一条数据，Command 为空表示 leader 当选时追加的空日志
+// 日志写入之后不会再修改，Transport 可以直接传递指针
+type LogEntry struct {
+	Index   uint64
+	Term    uint64
+	Command []byte
+}
+
+// RequestVoteArgs 投票请求
+type RequestVoteArgs struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// RequestVoteReply 投票响应
+type RequestVoteReply struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesArgs 复制日志的请求，Entries 为空时是心跳
+type AppendEntriesArgs struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []*LogEntry
+	LeaderCommit uint64
+}
+
+// AppendEntriesReply 复制日志的响应，失败时 ConflictIndex 为 leader 下一次发送的位置
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+}
+
+// InstallSnapshotArgs 分块发送快照的请求
+type InstallSnapshotArgs struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Offset            int64
+	Data              []byte
+	Done              bool
+}
+
+// InstallSnapshotReply 发送快照的响应
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+// Handler 处理其他节点发来的请求，由 Node 实现
+type Handler interface {
+	RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error
+	AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error
+	InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error
+}
+
+// Transport 节点之间通信的抽象接口，target 为目标节点的 id
+type Transport interface {
+	// Serve 开始将收到的请求交给 handler 处理，不会阻塞
+	Serve(handler Handler) error
+
+	RequestVote(target string, args *RequestVoteArgs, reply *RequestVoteReply) error
+	AppendEntries(target string, args *AppendEntriesArgs, reply *AppendEntriesReply) error
+	InstallSnapshot(target string, args *InstallSnapshotArgs, reply *InstallSnapshotReply) error
+
+	// Close 停止处理请求并释放资源
+	Close() error
+}
+
+// InmemNetwork 进程内的网络，用于在同一个进程中运行整个集群
+// 可以断开某个节点来模拟网络分区
+type InmemNetwork struct {
+	mu           sync.RWMutex
+	handlers     map[string]Handler
+	disconnected map[string]bool
+}
+
+// NewInmemNetwork 初始化进程内的网络
+func NewInmemNetwork() *InmemNetwork {
+	return &InmemNetwork{
+		handlers:     make(map[string]Handler),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Transport 返回 id 对应的节点使用的 Transport
+func (nw *InmemNetwork) Transport(id string) Transport {
+	return &inmemTransport{network: nw, id: id}
+}
+
+// Disconnect 断开节点，发给该节点和该节点发出的请求都会返回 ErrUnreachable
+func (nw *InmemNetwork) Disconnect(id string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.disconnected[id] = true
+}
+
+// Connect 恢复断开的节点
+func (nw *InmemNetwork) Connect(id string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	delete(nw.disconnected, id)
+}
+
+// 获取处理请求的 handler
+func (nw *InmemNetwork) handler(from string, target string) (Handler, error) {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+	if nw.disconnected[from] || nw.disconnected[target] {
+		return nil, ErrUnreachable
+	}
+	handler, ok := nw.handlers[target]
+	if !ok {
+		return nil, ErrUnreachable
+	}
+	return handler, nil
+}
+
+type inmemTransport struct {
+	network *InmemNetwork
+	id      string
+}
+
+func (t *inmemTransport) Serve(handler Handler) error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.network.handlers[t.id] = handler
+	return nil
+}
+
+func (t *inmemTransport) RequestVote(target string, args *RequestVoteArgs, reply *RequestVoteReply) error {
+	handler, err := t.network.handler(t.id, target)
+	if err != nil {
+		return err
+	}
+	return handler.RequestVote(args, reply)
+}
+
+func (t *inmemTransport) AppendEntries(target string, args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	handler, err := t.network.handler(t.id, target)
+	if err != nil {
+		return err
+	}
+	return handler.AppendEntries(args, reply)
+}
+
+func (t *inmemTransport) InstallSnapshot(target string, args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	handler, err := t.network.handler(t.id, target)
+	if err != nil {
+		return err
+	}
+	return handler.InstallSnapshot(args, reply)
+}
+
+func (t *inmemTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	delete(t.network.handlers, t.id)
+	return nil
+}
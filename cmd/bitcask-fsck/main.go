@@ -0,0 +1,91 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 离线检查数据目录，没有发现问题或者只有启动时可以自动修复的问题时退出码为 0，
+// 发现无法自动修复的问题时为 1，无法完成检查时为 2
+func main() {
+	dir := flag.String("dir", "", "data directory of the database")
+	keys := flag.String("keys", "", "encryption keys of the database, formatted as id:hex-key and separated by commas")
+	jsonOutput := flag.Bool("json", false, "print the report as json")
+	flag.Parse()
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "the data directory is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	var options bitcask.VerifyOptions
+	if *keys != "" {
+		keyProvider, err := parseKeys(*keys)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid keys: %v\n", err)
+			os.Exit(2)
+		}
+		options.KeyProvider = keyProvider
+	}
+
+	report, err := bitcask.VerifyWithOptions(*dir, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify %s: %v\n", *dir, err)
+		os.Exit(2)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode report: %v\n", err)
+			os.Exit(2)
+		}
+	} else {
+		printReport(report)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *bitcask.VerifyReport) {
+	for _, file := range report.Files {
+		fmt.Printf("data file %09d: version %d, %d bytes, %d records\n", file.FileId, file.Version, file.Size, file.Records)
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d data files, %d problems found in %s\n", len(report.Files), len(report.Problems), report.DirPath)
+}
+
+// 解析 id:hex-key 格式的密钥，检查时只需要根据 id 查找密钥，第一个密钥作为当前的密钥
+func parseKeys(s string) (*data.StaticKeyProvider, error) {
+	keyProvider := &data.StaticKeyProvider{Keys: make(map[uint32][]byte)}
+	for i, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not formatted as id:hex-key", item)
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		key, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			keyProvider.Current = uint32(id)
+		}
+		keyProvider.Keys[uint32(id)] = key
+	}
+	return keyProvider, nil
+}
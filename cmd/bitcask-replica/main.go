@@ -0,0 +1,93 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/resp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "", "data directory of the database")
+	role := flag.String("role", "primary", "replication role, primary or follower")
+	addr := flag.String("addr", "localhost:7380", "replication address to listen on (primary) or to connect to (follower)")
+	respAddr := flag.String("resp", "localhost:6380", "address of the resp server")
+	flag.Parse()
+
+	options := bitcask.DefaultOptions
+	if *dir != "" {
+		options.DirPath = *dir
+	} else {
+		options.DirPath, _ = os.MkdirTemp("", "bitcask-go-replica")
+	}
+
+	switch *role {
+	case "primary":
+		runPrimary(options, *addr, *respAddr)
+	case "follower":
+		runFollower(options, *addr, *respAddr)
+	default:
+		panic(fmt.Sprintf("unknown role: %s", *role))
+	}
+}
+
+// primary 同时提供 RESP 服务和主从复制服务
+func runPrimary(options bitcask.Options, addr string, respAddr string) {
+	db, err := bitcask.Open(options)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open db: %v", err))
+	}
+	defer db.Close()
+
+	primary := bitcask.NewPrimary(db)
+	defer primary.Close()
+	go func() {
+		log.Printf("replication server start at %s, data directory %s", addr, options.DirPath)
+		if err := primary.ListenAndServe(addr); err != nil {
+			log.Printf("replication server exited: %v", err)
+		}
+	}()
+
+	serveResp(db, respAddr)
+}
+
+// follower 从 primary 同步数据，通过 RESP 服务提供只读访问
+func runFollower(options bitcask.Options, primaryAddr string, respAddr string) {
+	follower, err := bitcask.OpenFollower(options, primaryAddr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open follower: %v", err))
+	}
+	defer follower.Close()
+
+	go func() {
+		for range time.Tick(10 * time.Second) {
+			stat := follower.Stat()
+			log.Printf("replication from %s: connected %v, pos %d:%d, lag %d bytes",
+				primaryAddr, stat.Connected, stat.Pos.Fid, stat.Pos.Offset, stat.LagBytes)
+		}
+	}()
+
+	log.Printf("follower of %s, data directory %s", primaryAddr, options.DirPath)
+	serveResp(follower.DB(), respAddr)
+}
+
+// 启动 RESP 服务，收到退出信号之后返回
+func serveResp(db *bitcask.DB, addr string) {
+	server := resp.NewServer(db)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		_ = server.Close()
+	}()
+
+	log.Printf("resp server start at %s", addr)
+	if err := server.ListenAndServe(addr); err != nil {
+		log.Printf("resp server exited: %v", err)
+	}
+}
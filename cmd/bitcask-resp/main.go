@@ -0,0 +1,45 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/resp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	dir := flag.String("dir", "", "data directory of the database")
+	addr := flag.String("addr", "localhost:6380", "address to listen on")
+	flag.Parse()
+
+	// 初始化 DB 实例
+	options := bitcask.DefaultOptions
+	if *dir != "" {
+		options.DirPath = *dir
+	} else {
+		options.DirPath, _ = os.MkdirTemp("", "bitcask-go-resp")
+	}
+	db, err := bitcask.Open(options)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open db: %v", err))
+	}
+	defer db.Close()
+
+	server := resp.NewServer(db)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		_ = server.Close()
+	}()
+
+	// 启动 RESP 服务
+	log.Printf("resp server start at %s, data directory %s", *addr, options.DirPath)
+	if err := server.ListenAndServe(*addr); err != nil {
+		log.Printf("resp server exited: %v", err)
+	}
+}
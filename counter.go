@@ -0,0 +1,81 @@
+package bitcask_go
+
+import (
+	"math"
+	"strconv"
+)
+
+// 计数器的 value 以十进制字符串存储，和 Redis 的 INCRBY/INCRBYFLOAT 相同，可以直接使用 Get 读取
+// 整数计数器的取值范围是 int64，浮点数计数器使用 strconv.FormatFloat(v, 'f', -1, 64) 格式化
+
+// IncrBy 将 key 对应的整数加上 delta 并返回新的值，key 不存在时视为 0
+// 读取和写入在写锁内完成，与其他写操作之间是原子的，原有的过期时间保持不变
+func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+
+	value, expire, err := db.getCounter(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if value != nil {
+		if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, ErrValueNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrIncrOverflow
+	}
+	n += delta
+
+	if err := db.put(key, []byte(strconv.FormatInt(n, 10)), expire); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// IncrByFloat 将 key 对应的浮点数加上 delta 并返回新的值，key 不存在时视为 0
+func (db *DB) IncrByFloat(key []byte, delta float64) (float64, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+
+	value, expire, err := db.getCounter(key)
+	if err != nil {
+		return 0, err
+	}
+	var f float64
+	if value != nil {
+		if f, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return 0, ErrValueNotFloat
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrIncrOverflow
+	}
+
+	if err := db.put(key, []byte(strconv.FormatFloat(f, 'f', -1, 64)), expire); err != nil {
+		return 0, err
+	}
+	return f, nil
+}
+
+// 读取计数器当前的 value 和过期时间，key 不存在时 value 为 nil。该方法必须持有互斥锁
+func (db *DB) getCounter(key []byte) ([]byte, int64, error) {
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return nil, 0, nil
+	}
+	value, err := db.getValueByPosition(pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, pos.Expire, nil
+}
@@ -0,0 +1,103 @@
+package bitcask_go
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_IncrBy(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-incr")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// key 不存在时视为 0
+	n, err := db.IncrBy([]byte("counter"), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), n)
+	n, err = db.IncrBy([]byte("counter"), -15)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-5), n)
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-5"), val)
+
+	// 并发递增
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.IncrBy([]byte("concurrent"), 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get([]byte("concurrent"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1000"), val)
+
+	// 不是整数
+	err = db.Put([]byte("text"), []byte("abc"))
+	assert.Nil(t, err)
+	_, err = db.IncrBy([]byte("text"), 1)
+	assert.Equal(t, ErrValueNotInteger, err)
+
+	// 溢出
+	_, err = db.IncrBy([]byte("max"), math.MaxInt64)
+	assert.Nil(t, err)
+	_, err = db.IncrBy([]byte("max"), 1)
+	assert.Equal(t, ErrIncrOverflow, err)
+
+	// 保留原有的过期时间
+	err = db.PutWithTTL([]byte("ttl"), []byte("1"), 20*time.Millisecond)
+	assert.Nil(t, err)
+	n, err = db.IncrBy([]byte("ttl"), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	time.Sleep(30 * time.Millisecond)
+	_, err = db.Get([]byte("ttl"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_IncrByFloat(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-incr-float")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	f, err := db.IncrByFloat([]byte("float"), 1.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, f)
+	f, err = db.IncrByFloat([]byte("float"), -0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.25, f)
+	val, err := db.Get([]byte("float"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1.25"), val)
+
+	// 整数计数器也可以按浮点数递增
+	_, err = db.IncrBy([]byte("int"), 3)
+	assert.Nil(t, err)
+	f, err = db.IncrByFloat([]byte("int"), 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.5, f)
+
+	err = db.Put([]byte("text"), []byte("abc"))
+	assert.Nil(t, err)
+	_, err = db.IncrByFloat([]byte("text"), 1)
+	assert.Equal(t, ErrValueNotFloat, err)
+
+	_, err = db.IncrByFloat([]byte("inf"), math.Inf(1))
+	assert.Equal(t, ErrIncrOverflow, err)
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrKeyNotFound = errors.New("encryption key not found in the key provider")
+	ErrMissingKey  = errors.New("the log record is encrypted but no key provider is configured")
+	ErrWrongKey    = errors.New("failed to decrypt log record, the encryption key may be wrong")
+)
+
+// 加密之后的数据：密钥 id（4 字节）+ nonce + 密文，密文的末尾是 GCM 的认证标签
+const keyIdSize = 4
+
+// KeyProvider 提供加密数据使用的密钥，密钥长度为 16、24 或 32 字节，分别对应 AES-128、AES-192 和 AES-256
+type KeyProvider interface {
+	// CurrentKeyId 写入新数据时使用的密钥 id
+	CurrentKeyId() uint32
+	// Key 根据 id 返回密钥，merge 之前写入的数据可能使用旧的密钥加密，因此需要保留旧的密钥直到 merge 完成
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider 保存在内存中的一组密钥
+type StaticKeyProvider struct {
+	Current uint32            // 当前使用的密钥 id
+	Keys    map[uint32][]byte // 所有可以使用的密钥
+}
+
+func (p *StaticKeyProvider) CurrentKeyId() uint32 {
+	return p.Current
+}
+
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.Keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// Cipher 使用 AES-GCM 加密 LogRecord 的 key 和 value，并发安全
+type Cipher struct {
+	provider  KeyProvider
+	currentId uint32
+	mu        sync.RWMutex
+	aeads     map[uint32]cipher.AEAD // 已经初始化的密钥
+}
+
+// NewCipher 创建 Cipher，并检查当前的密钥是否可用
+func NewCipher(provider KeyProvider) (*Cipher, error) {
+	c := &Cipher{
+		provider:  provider,
+		currentId: provider.CurrentKeyId(),
+		aeads:     make(map[uint32]cipher.AEAD),
+	}
+	if _, err := c.getAEAD(c.currentId); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// KeyId 写入新数据时使用的密钥 id
+func (c *Cipher) KeyId() uint32 {
+	return c.currentId
+}
+
+func (c *Cipher) getAEAD(id uint32) (cipher.AEAD, error) {
+	c.mu.RLock()
+	aead, ok := c.aeads[id]
+	c.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := c.provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.aeads[id] = aead
+	c.mu.Unlock()
+	return aead, nil
+}
+
+// 加密之后的长度
+func (c *Cipher) sealedSize(plaintextSize int) int {
+	aead, _ := c.getAEAD(c.currentId)
+	return keyIdSize + aead.NonceSize() + plaintextSize + aead.Overhead()
+}
+
+// 使用当前的密钥加密，aad 是需要一起认证但是不加密的数据
+func (c *Cipher) seal(plaintext []byte, aad []byte) []byte {
+	aead, _ := c.getAEAD(c.currentId)
+	sealed := make([]byte, keyIdSize+aead.NonceSize(), c.sealedSize(len(plaintext)))
+	binary.LittleEndian.PutUint32(sealed, c.currentId)
+	nonce := sealed[keyIdSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(sealed, nonce, plaintext, aad)
+}
+
+// 根据密文中记录的密钥 id 解密
+func (c *Cipher) open(sealed []byte, aad []byte) ([]byte, error) {
+	if len(sealed) < keyIdSize {
+		return nil, ErrWrongKey
+	}
+	aead, err := c.getAEAD(binary.LittleEndian.Uint32(sealed))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < keyIdSize+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrWrongKey
+	}
+	nonce := sealed[keyIdSize : keyIdSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[keyIdSize+aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plaintext, nil
+}
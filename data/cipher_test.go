@@ -0,0 +1,116 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"testing"
+)
+
+func newTestKeyProvider(current uint32) *StaticKeyProvider {
+	return &StaticKeyProvider{
+		Current: current,
+		Keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte("a"), 16),
+			2: bytes.Repeat([]byte("b"), 32),
+		},
+	}
+}
+
+func TestDataFile_ReadEncryptedLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cipher")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	cipher1, err := NewCipher(newTestKeyProvider(1))
+	assert.Nil(t, err)
+	cipher2, err := NewCipher(newTestKeyProvider(2))
+	assert.Nil(t, err)
+	flateCodec, _ := GetCodec(CodecFlate)
+	records := []*LogRecord{
+		{Key: []byte("k1"), Value: []byte("bitcask-go")},
+		{Key: []byte("k2"), Value: bytes.Repeat([]byte("kv"), 100), Expire: 1700000000000000000},
+		{Key: []byte("k3"), Type: LogRecordDeleted},
+	}
+	codecs := []Codec{nil, flateCodec, nil}
+	ciphers := []*Cipher{cipher1, cipher2, cipher2}
+
+	offset := dataFile.HeaderSize()
+	for i, rec := range records {
+		res, size := EncodeLogRecordWith(rec, codecs[i], ciphers[i])
+		assert.Equal(t, int64(len(res)), size)
+		assert.False(t, bytes.Contains(res, rec.Key))
+		assert.Nil(t, dataFile.Write(res))
+
+		keyId, encrypted, err := dataFile.ReadKeyId(offset)
+		assert.Nil(t, err)
+		assert.True(t, encrypted)
+		assert.Equal(t, ciphers[i].KeyId(), keyId)
+
+		// 旧的密钥加密的数据使用 KeyProvider 中的旧密钥解密
+		dataFile.Cipher = cipher2
+		readRec, readSize, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, rec.Key, readRec.Key)
+		assert.Equal(t, rec.Type, readRec.Type)
+		assert.Equal(t, rec.Expire, readRec.Expire)
+		assert.True(t, bytes.Equal(rec.Value, readRec.Value))
+		assert.Equal(t, size, readSize)
+		offset += size
+	}
+	_, _, err = dataFile.ReadKeyId(offset)
+	assert.NotNil(t, err)
+
+	// 没有配置密钥
+	dataFile.Cipher = nil
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Equal(t, ErrMissingKey, err)
+
+	// 相同 id 的密钥不正确
+	wrongCipher, err := NewCipher(&StaticKeyProvider{Current: 1, Keys: map[uint32][]byte{1: bytes.Repeat([]byte("c"), 16)}})
+	assert.Nil(t, err)
+	dataFile.Cipher = wrongCipher
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Equal(t, ErrWrongKey, err)
+
+	// KeyProvider 中没有对应 id 的密钥
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize + int64(len(encodeEncrypted(records[0], cipher1))))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func encodeEncrypted(rec *LogRecord, cipher *Cipher) []byte {
+	res, _ := EncodeLogRecordWith(rec, nil, cipher)
+	return res
+}
+
+func TestDataFile_ReadTamperedEncryptedLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cipher")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	cipher, err := NewCipher(newTestKeyProvider(1))
+	assert.Nil(t, err)
+	dataFile.Cipher = cipher
+
+	// 修改 header 中的类型并重新计算 crc，认证失败
+	res := encodeEncrypted(&LogRecord{Key: []byte("k"), Value: []byte("v")}, cipher)
+	res[4] = res[4]&^logRecordTypeMask | LogRecordDeleted
+	binary.LittleEndian.PutUint32(res[:4], crc32.ChecksumIEEE(res[4:]))
+	assert.Nil(t, dataFile.Write(res))
+
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Equal(t, ErrWrongKey, err)
+}
+
+func TestNewCipher(t *testing.T) {
+	_, err := NewCipher(newTestKeyProvider(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = NewCipher(&StaticKeyProvider{Current: 1, Keys: map[uint32][]byte{1: []byte("short")}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	ErrUnknownCodec = errors.New("unknown compression codec, the codec may not be registered")
+	ErrInvalidCodec = errors.New("invalid codec id, must between 1 and 15")
+)
+
+// 内置的压缩方式，id 写在 type 字节的标志位中
+const (
+	CodecNone  byte = iota // 不压缩
+	CodecFlate             // compress/flate
+	CodecGzip              // compress/gzip
+
+	maxCodecId byte = 15
+)
+
+// Codec 压缩 value 的编解码器，通过 RegisterCodec 注册之后可以在 Options 中使用
+type Codec interface {
+	// ID 编解码器的 id，取值 1 到 15，写入数据文件之后不能再修改
+	ID() byte
+	// Compress 压缩数据，返回新的字节数组
+	Compress(src []byte) []byte
+	// Decompress 解压数据，返回新的字节数组
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{
+		CodecFlate: &flateCodec{},
+		CodecGzip:  &gzipCodec{},
+	}
+)
+
+// RegisterCodec 注册自定义的编解码器，id 相同时覆盖已有的编解码器
+func RegisterCodec(codec Codec) error {
+	if codec.ID() == CodecNone || codec.ID() > maxCodecId {
+		return ErrInvalidCodec
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ID()] = codec
+	return nil
+}
+
+// GetCodec 根据 id 获取编解码器，CodecNone 返回 nil
+func GetCodec(id byte) (Codec, error) {
+	if id == CodecNone {
+		return nil, nil
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+// 复用压缩和解压使用的对象，避免每次都分配较大的内部缓冲区
+type flateCodec struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *flateCodec) ID() byte {
+	return CodecFlate
+}
+
+func (c *flateCodec) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	_, _ = w.Write(src)
+	_ = w.Close()
+	c.writers.Put(w)
+	return buf.Bytes()
+}
+
+func (c *flateCodec) Decompress(src []byte) ([]byte, error) {
+	r, ok := c.readers.Get().(io.ReadCloser)
+	if ok {
+		if err := r.(flate.Resetter).Reset(bytes.NewReader(src), nil); err != nil {
+			return nil, err
+		}
+	} else {
+		r = flate.NewReader(bytes.NewReader(src))
+	}
+	defer c.readers.Put(r)
+	return io.ReadAll(r)
+}
+
+type gzipCodec struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *gzipCodec) ID() byte {
+	return CodecGzip
+}
+
+func (c *gzipCodec) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	_, _ = w.Write(src)
+	_ = w.Close()
+	c.writers.Put(w)
+	return buf.Bytes()
+}
+
+func (c *gzipCodec) Decompress(src []byte) ([]byte, error) {
+	var err error
+	r, ok := c.readers.Get().(*gzip.Reader)
+	if ok {
+		err = r.Reset(bytes.NewReader(src))
+	} else {
+		r, err = gzip.NewReader(bytes.NewReader(src))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer c.readers.Put(r)
+	return io.ReadAll(r)
+}
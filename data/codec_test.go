@@ -0,0 +1,131 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"testing"
+)
+
+func TestCodec_Compress(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","tags":["kv","go"]}`), 100)
+	for _, id := range []byte{CodecFlate, CodecGzip} {
+		codec, err := GetCodec(id)
+		assert.Nil(t, err)
+		assert.Equal(t, id, codec.ID())
+
+		compressed := codec.Compress(value)
+		assert.Less(t, len(compressed), len(value))
+		decompressed, err := codec.Decompress(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+
+		// 复用对象之后结果一致
+		decompressed, err = codec.Decompress(codec.Compress(value))
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+	}
+
+	codec, err := GetCodec(CodecNone)
+	assert.Nil(t, err)
+	assert.Nil(t, codec)
+	_, err = GetCodec(14)
+	assert.Equal(t, ErrUnknownCodec, err)
+}
+
+// 将 value 反转的编解码器，用于测试自定义的编解码器
+type reverseCodec struct{}
+
+func (c *reverseCodec) ID() byte {
+	return 13
+}
+
+func (c *reverseCodec) Compress(src []byte) []byte {
+	dst := make([]byte, len(src)-1)
+	for i := range dst {
+		dst[i] = src[len(src)-1-i]
+	}
+	return dst
+}
+
+func (c *reverseCodec) Decompress(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src)+1)
+	for i := range src {
+		dst[i] = src[len(src)-1-i]
+	}
+	dst[len(src)] = 'x'
+	return dst, nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	assert.Nil(t, RegisterCodec(&reverseCodec{}))
+	codec, err := GetCodec(13)
+	assert.Nil(t, err)
+	assert.Equal(t, &reverseCodec{}, codec)
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("xabc")}
+	res, _ := EncodeLogRecordWith(rec, codec, nil)
+	header, size := decodeLogRecordHeader(res)
+	assert.Equal(t, byte(13), header.codec)
+	assert.Equal(t, []byte("cba"), res[size+int64(len(rec.Key)):])
+}
+
+func TestDataFile_ReadCompressedLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-codec")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	flateCodec, _ := GetCodec(CodecFlate)
+	gzipCodec, _ := GetCodec(CodecGzip)
+	records := []*LogRecord{
+		{Key: []byte("k1"), Value: bytes.Repeat([]byte("bitcask"), 100)},
+		{Key: []byte("k2"), Value: bytes.Repeat([]byte("kv"), 100), Expire: 1700000000000000000},
+		{Key: []byte("k3"), Value: []byte("short")},
+		{Key: []byte("k4"), Value: bytes.Repeat([]byte("go"), 100)},
+	}
+	codecs := []Codec{flateCodec, gzipCodec, gzipCodec, nil}
+
+	offset := dataFile.HeaderSize()
+	for i, rec := range records {
+		res, size := EncodeLogRecordWith(rec, codecs[i], nil)
+		assert.Nil(t, dataFile.Write(res))
+
+		// 压缩之后没有变小的数据不压缩
+		header, _ := decodeLogRecordHeader(res)
+		if codecs[i] != nil && i != 2 {
+			assert.Equal(t, codecs[i].ID(), header.codec)
+			assert.Less(t, size, int64(len(rec.Value)))
+		} else {
+			assert.Equal(t, CodecNone, header.codec)
+		}
+
+		readRec, readSize, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, rec, readRec)
+		assert.Equal(t, size, readSize)
+		offset += size
+	}
+}
+
+func TestDataFile_ReadUnknownCodec(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-codec")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	// 使用没有注册的编解码器的 id，并重新计算 crc
+	codec, _ := GetCodec(CodecFlate)
+	res, _ := EncodeLogRecordWith(&LogRecord{Key: []byte("k"), Value: bytes.Repeat([]byte("v"), 100)}, codec, nil)
+	res[4] = res[4]&^logRecordCodecMask | 12<<logRecordCodecShift
+	binary.LittleEndian.PutUint32(res[:4], crc32.ChecksumIEEE(res[4:]))
+	assert.Nil(t, dataFile.Write(res))
+
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Equal(t, ErrUnknownCodec, err)
+}
@@ -2,10 +2,12 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"os"
 	"path/filepath"
 )
 
@@ -24,13 +26,32 @@ type DataFile struct {
 	FileId    uint32        // 文件id
 	WriteOff  int64         // 文件写到了哪个位置
 	IoManager fio.IOManager // io 读写管理器
+	Cipher    *Cipher       // 解密数据使用的 Cipher，为 nil 时无法读取加密的数据
+	Header    *FileHeader   // 文件头，没有文件头的旧版本数据文件为 nil
 }
 
 
 // OpenDataFile 打开新的数据文件
+// 新建的数据文件写入文件头，已经存在的数据文件校验文件头，没有文件头的旧版本数据文件校验第一条 LogRecord
 func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := GetDataFileName(dirPath, fileId)
-	return newDataFile(fileName, fileId, ioType)
+	_, err := os.Stat(fileName)
+	isNew := os.IsNotExist(err)
+
+	dataFile, err := newDataFile(fileName, fileId, ioType)
+	if err != nil {
+		return nil, err
+	}
+	if isNew {
+		err = dataFile.writeHeader()
+	} else {
+		err = dataFile.loadHeader()
+	}
+	if err != nil {
+		_ = dataFile.Close()
+		return nil, err
+	}
+	return dataFile, nil
 }
 
 // OpenHintFile 打开 Hint 索引文件
@@ -67,17 +88,72 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 		IoManager: ioManager,
 	}, nil
 }
+// HeaderSize 文件头的长度，也就是第一条 LogRecord 的位置，旧版本的数据文件为 0
+func (df *DataFile) HeaderSize() int64 {
+	if df.Header == nil {
+		return 0
+	}
+	return FileHeaderSize
+}
+
+func (df *DataFile) writeHeader() error {
+	header := newFileHeader(df.FileId)
+	if err := df.Write(encodeFileHeader(header)); err != nil {
+		return err
+	}
+	df.Header = header
+	return nil
+}
+
+// 读取并校验文件头，没有文件头时按照旧版本的数据文件处理
+func (df *DataFile) loadHeader() error {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	headerBytes := int64(FileHeaderSize)
+	if fileSize < headerBytes {
+		headerBytes = fileSize
+	}
+	buf, err := df.readNBytes(headerBytes, 0)
+	if err != nil {
+		return err
+	}
+
+	if hasFileMagic(buf) {
+		header, err := decodeFileHeader(buf)
+		if err != nil {
+			return err
+		}
+		// 文件名和文件头中的 id 不一致
+		if header.FileId != df.FileId {
+			return ErrInvalidFileHeader
+		}
+		df.Header = header
+		return nil
+	}
+
+	// 旧版本的数据文件从开头就是 LogRecord，第一条完整的数据需要通过 crc 校验
+	if _, _, err := df.ReadLogRecord(0); err == ErrInvalidCRC {
+		return ErrInvalidFileHeader
+	}
+	return nil
+}
+
 // WriteHintRecord 写入索引信息到 hint 文件中
 func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	record := &LogRecord{
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
 	}
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, _ := EncodeLogRecordWith(record, nil, df.Cipher)
 	return df.Write(encRecord)
 }
 
 // ReadLogRecord 根据 offset 从数据文件中读取 LogRecord
+// 读取到文件末尾或者最后一条数据没有完整写入时返回 io.EOF
+// crc 校验失败时同时返回数据的长度，可以用来判断损坏的数据是否在文件的末尾
+// crc 校验通过但是无法解密或者解压时同样返回数据的长度，可以跳过这条数据继续读取
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
@@ -109,9 +185,14 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 去除对应的key和value的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	// 数据没有完整写入
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
+	}
 
 	logRecord := &LogRecord{
-		Type: header.recordType,
+		Type:   header.recordType,
+		Expire: header.expire,
 	}
 	// 开始读取用户实际存储的key和value
 	if keySize > 0 || valueSize > 0 {
@@ -125,12 +206,74 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 校验crc
 	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
 	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		return nil, recordSize, ErrInvalidCRC
+	}
+	// 解密 key 和 value，header 中的其余部分作为附加数据参与认证
+	if header.encrypted {
+		if err := decryptLogRecord(logRecord, df.Cipher, headerBuf[crc32.Size:headerSize]); err != nil {
+			return nil, recordSize, err
+		}
+	}
+	// 解压 value，crc 是对压缩之后的数据计算的
+	if header.codec != CodecNone {
+		codec, err := GetCodec(header.codec)
+		if err != nil {
+			return nil, recordSize, err
+		}
+		if logRecord.Value, err = codec.Decompress(logRecord.Value); err != nil {
+			return nil, recordSize, err
+		}
 	}
 	return logRecord, recordSize, nil
 
 }
 
+// ReadKeyId 读取 offset 处的 LogRecord 加密使用的密钥 id，没有加密时返回 false
+func (df *DataFile) ReadKeyId(offset int64) (uint32, bool, error) {
+	header, headerSize, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return 0, false, err
+	}
+	if !header.encrypted {
+		return 0, false, nil
+	}
+	keyIdBuf, err := df.readNBytes(keyIdSize, offset+headerSize)
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.LittleEndian.Uint32(keyIdBuf), true, nil
+}
+
+// ReadCodecId 读取 offset 处的 LogRecord 压缩 value 使用的编解码器 id，没有压缩时返回 CodecNone
+func (df *DataFile) ReadCodecId(offset int64) (byte, error) {
+	header, _, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return CodecNone, err
+	}
+	return header.codec, nil
+}
+
+// 只读取 offset 处的 LogRecord 的 header，读取到文件末尾时返回 io.EOF
+func (df *DataFile) readLogRecordHeader(offset int64) (*logRecordHeader, int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if offset+maxLogRecordHeaderSize > fileSize {
+		headerBytes = fileSize - offset
+	}
+	headerBuf, err := df.readNBytes(headerBytes, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+	if header == nil || (header.crc == 0 && header.keySize == 0 && header.valueSize == 0) {
+		return nil, 0, io.EOF
+	}
+	return header, headerSize, nil
+}
+
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
@@ -8,21 +8,25 @@ import (
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile1, err := OpenDataFile(dir, 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO)
+	dataFile2, err := OpenDataFile(dir, 111, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO)
+	dataFile3, err := OpenDataFile(dir, 111, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -37,7 +41,9 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 123, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 123, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -49,7 +55,9 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 456, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 456, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -61,7 +69,9 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 6666, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 6666, fio.StandardFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -74,7 +84,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res1)
 	assert.Nil(t, err)
 
-	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	readRec1, readSize1, err := dataFile.ReadLogRecord(FileHeaderSize)
 	assert.Nil(t, err)
 	assert.Equal(t, rec1, readRec1)
 	assert.Equal(t, size1, readSize1)
@@ -89,7 +99,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res2)
 	assert.Nil(t, err)
 
-	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(FileHeaderSize + size1)
 	assert.Nil(t, err)
 	assert.Equal(t, rec2, readRec2)
 	assert.Equal(t, size2, readSize2)
@@ -104,8 +114,77 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res3)
 	assert.Nil(t, err)
 
-	readRec3, readSize3, err := dataFile.ReadLogRecord(size1 + size2)
+	readRec3, readSize3, err := dataFile.ReadLogRecord(FileHeaderSize + size1 + size2)
 	assert.Nil(t, err)
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestOpenDataFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+
+	// 新建的数据文件写入文件头
+	dataFile, err := OpenDataFile(dir, 7, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFileVersion, dataFile.Header.Version)
+	assert.Equal(t, uint32(7), dataFile.Header.FileId)
+	assert.True(t, dataFile.Header.CreateTime > 0)
+	assert.Equal(t, int64(FileHeaderSize), dataFile.WriteOff)
+	assert.Nil(t, dataFile.Close())
+
+	dataFile2, err := OpenDataFile(dir, 7, fio.MemoryMap)
+	assert.Nil(t, err)
+	assert.Equal(t, dataFile.Header, dataFile2.Header)
+	assert.Nil(t, dataFile2.Close())
+
+	// 文件名和文件头中的 id 不一致
+	assert.Nil(t, os.Rename(GetDataFileName(dir, 7), GetDataFileName(dir, 8)))
+	_, err = OpenDataFile(dir, 8, fio.StandardFIO)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+
+	// 不能识别的版本
+	header := encodeFileHeader(&FileHeader{Version: CurrentFileVersion + 1, FileId: 9})
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 9), header, 0644))
+	_, err = OpenDataFile(dir, 9, fio.StandardFIO)
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+
+	// 文件头损坏
+	header = encodeFileHeader(newFileHeader(10))
+	header[12]++
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 10), header, 0644))
+	_, err = OpenDataFile(dir, 10, fio.StandardFIO)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+}
+
+func TestOpenDataFile_Legacy(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+
+	// 没有文件头的旧版本数据文件
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go")}
+	res, size := EncodeLogRecord(rec)
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 1), res, 0644))
+	dataFile, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.Nil(t, dataFile.Header)
+	assert.Equal(t, int64(0), dataFile.HeaderSize())
+	readRec, readSize, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, size, readSize)
+
+	// 空的旧版本数据文件
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 2), nil, 0644))
+	dataFile2, err := OpenDataFile(dir, 2, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile2.Close()
+	assert.Nil(t, dataFile2.Header)
+
+	// 既没有文件头也不是 LogRecord
+	res[len(res)-1]++
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 3), res, 0644))
+	_, err = OpenDataFile(dir, 3, fio.StandardFIO)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+}
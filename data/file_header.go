@@ -0,0 +1,80 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+var (
+	ErrUnsupportedFileVersion = errors.New("unsupported data file version, the file may be written by a newer version")
+	ErrInvalidFileHeader      = errors.New("invalid data file header, the file may be corrupted or not a data file")
+)
+
+// 数据文件的格式版本
+const (
+	FileVersionLegacy  uint16 = iota // 没有文件头的旧版本数据文件，LogRecord 从文件的开头开始写入
+	FileVersion1                     // 带有文件头的数据文件
+	CurrentFileVersion = FileVersion1
+)
+
+// 文件头的长度，LogRecord 从文件头之后开始写入
+//
+//	+----------+----------+----------+----------+-------------+----------+
+//	|  magic   |  version | reserved |  file id | create time |    crc   |
+//	+----------+----------+----------+----------+-------------+----------+
+//	   4字节       2字节       2字节      4字节        8字节        4字节
+const FileHeaderSize = 24
+
+var fileMagic = []byte("BCSK")
+
+// FileHeader 数据文件的文件头
+type FileHeader struct {
+	Version    uint16 // 格式版本
+	FileId     uint32 // 文件 id，和文件名中的 id 一致
+	CreateTime int64  // 创建时间，UnixNano 时间戳
+}
+
+func newFileHeader(fileId uint32) *FileHeader {
+	return &FileHeader{
+		Version:    CurrentFileVersion,
+		FileId:     fileId,
+		CreateTime: time.Now().UnixNano(),
+	}
+}
+
+func encodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, FileHeaderSize)
+	copy(buf, fileMagic)
+	binary.LittleEndian.PutUint16(buf[4:], header.Version)
+	binary.LittleEndian.PutUint32(buf[8:], header.FileId)
+	binary.LittleEndian.PutUint64(buf[12:], uint64(header.CreateTime))
+	binary.LittleEndian.PutUint32(buf[20:], crc32.ChecksumIEEE(buf[:20]))
+	return buf
+}
+
+// 是否以文件头的 magic number 开始
+func hasFileMagic(buf []byte) bool {
+	return len(buf) >= len(fileMagic) && bytes.Equal(buf[:len(fileMagic)], fileMagic)
+}
+
+// 解码并校验文件头，版本号只校验是否可以识别
+func decodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < FileHeaderSize || !hasFileMagic(buf) {
+		return nil, ErrInvalidFileHeader
+	}
+	if crc32.ChecksumIEEE(buf[:20]) != binary.LittleEndian.Uint32(buf[20:]) {
+		return nil, ErrInvalidFileHeader
+	}
+	header := &FileHeader{
+		Version:    binary.LittleEndian.Uint16(buf[4:]),
+		FileId:     binary.LittleEndian.Uint32(buf[8:]),
+		CreateTime: int64(binary.LittleEndian.Uint64(buf[12:])),
+	}
+	if header.Version == FileVersionLegacy || header.Version > CurrentFileVersion {
+		return nil, ErrUnsupportedFileVersion
+	}
+	return header, nil
+}
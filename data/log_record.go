@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"time"
 )
 type LogRecordType = byte
 
@@ -12,56 +13,116 @@ const (
 	LogRecordTxnFinished
 )
 
-// crc type keySize valueSize
-//  4 + 1  +   5    +    5   = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// type 字节的低位存储 LogRecordType，高位用作标志位
+const (
+	logRecordTypeMask    byte = 0x03
+	logRecordEncryptFlag byte = 1 << 2 // key 和 value 已经加密
+	logRecordCodecShift       = 3
+	logRecordCodecMask  byte = 0x0f << logRecordCodecShift // value 的压缩方式，0 表示没有压缩
+	logRecordExpireFlag byte = 1 << 7                      // 带有过期时间
+)
+
+// crc type keySize valueSize expire
+//  4 + 1  +   5    +    5   +  10  = 25
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 5
 
 // Logecord 写入到数据文件的记录。日志：数据文件中的数据是追加写入的，类似日志格式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType // tombstone墓碑值，删除数据时需要使用
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType // tombstone墓碑值，删除数据时需要使用
+	Expire int64         // 过期时间，UnixNano 时间戳，0 表示永不过期
 }
 
 // LogRecord 的头部信息
 type logRecordHeader struct {
 	crc        uint32        // crc 校验值
 	recordType LogRecordType // 标识 LogRecord 的类型
+	codec      byte          // value 的压缩方式
+	encrypted  bool          // key 和 value 是否加密
 	keySize    uint32        // key 的长度
 	valueSize  uint32        // value 的长度
+	expire     int64         // 过期时间
 }
 
 // 数据的内存索引，主要描述数据在磁盘上的位置
 type LogRecordPos struct {
 	Fid    uint32 // 文件id，表示数据存储到到了哪个文件当中
 	Offset int64  // 偏移量，表示数据村书到了文件当中的位置
+	Expire int64  // 过期时间，0 表示永不过期
+	Size   uint32 // 标识数据在磁盘上的大小
+}
+
+// IsExpired 判断位置索引对应的数据是否已经过期
+func (pos *LogRecordPos) IsExpired() bool {
+	return pos.Expire > 0 && pos.Expire <= time.Now().UnixNano()
 }
 // EncodeLogRecord 对 LogRecord 进行编码，返回字节数组及长度
 //
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//	+-------------+-------------+-------------+--------------+--------------+-------------+--------------+
+//	| crc 校验值  |  type 类型   |    key size |   value size |    expire    |      key    |      value   |
+//	+-------------+-------------+-------------+--------------+--------------+-------------+--------------+
+//	    4字节          1字节        变长（最大5）   变长（最大5）  变长（最大10）     变长           变长
+//
+// expire 只有在设置了过期时间时才会写入，并在 type 字节中打上标志位，因此旧的数据文件仍然可以正常读取
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWith(logRecord, nil, nil)
+}
+
+// EncodeLogRecordWith 使用 codec 压缩 value、使用 cipher 加密之后编码，codec 的 id 记录在 type 字节的标志位中
+// codec 为空或者压缩之后没有变小时不压缩，value size 为压缩之后的长度
+// cipher 不为空时 key 和压缩之后的 value 一起加密，作为 value 写入，key size 为 0，header 作为附加数据参与认证
+func EncodeLogRecordWith(logRecord *LogRecord, codec Codec, cipher *Cipher) ([]byte, int64) {
 	// 初始化一个 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	// 第五个字节存储 Type
 	header[4] = logRecord.Type
+	value := logRecord.Value
+	if codec != nil && len(value) > 0 {
+		if compressed := codec.Compress(value); len(compressed) < len(value) {
+			value = compressed
+			header[4] |= codec.ID() << logRecordCodecShift
+		}
+	}
+	key := logRecord.Key
+	var plaintext []byte
+	if cipher != nil {
+		header[4] |= logRecordEncryptFlag
+		plaintext = make([]byte, binary.MaxVarintLen32+len(key)+len(value))
+		n := binary.PutUvarint(plaintext, uint64(len(key)))
+		n += copy(plaintext[n:], key)
+		n += copy(plaintext[n:], value)
+		plaintext = plaintext[:n]
+		key, value = nil, nil
+	}
+	valueSize := len(value)
+	if cipher != nil {
+		valueSize = cipher.sealedSize(len(plaintext))
+	}
+
 	var index = 5
 	// 5 字节之后，存储的是 key 和 value 的长度信息
 	// 使用变长类型，节省空间
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	index += binary.PutVarint(header[index:], int64(len(key)))
+	index += binary.PutVarint(header[index:], int64(valueSize))
+	// 设置了过期时间则写入 expire
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordExpireFlag
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+	if cipher != nil {
+		value = cipher.seal(plaintext, header[4:index])
+	}
 
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+	var size = index + len(key) + len(value)
 	encBytes := make([]byte, size)
 
 	// 将 header 部分的内容拷贝过来
 	copy(encBytes[:index], header[:index])
 	// 将 key 和 value 数据拷贝到字节数组中
-	copy(encBytes[index:], logRecord.Key)
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(encBytes[index:], key)
+	copy(encBytes[index+len(key):], value)
 
 	// 对整个 LogRecord 的数据进行 crc 校验
 	crc := crc32.ChecksumIEEE(encBytes[4:])
@@ -78,23 +139,59 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] & logRecordTypeMask,
+		codec:      (buf[4] & logRecordCodecMask) >> logRecordCodecShift,
+		encrypted:  buf[4]&logRecordEncryptFlag != 0,
 	}
 
 	var index = 5
-	// 取出实际的 key size
+	// 取出实际的 key size，header 没有完整写入时返回 nil
 	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.keySize = uint32(keySize)
 	index += n
 
 	// 取出实际的 value size
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// 取出过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.expire = expire
+		index += n
+	}
+
 	return header, int64(index)
 }
 
+// 解密 value 中的 key 和 value，aad 是 crc 之后的 header
+func decryptLogRecord(logRecord *LogRecord, cipher *Cipher, aad []byte) error {
+	if cipher == nil {
+		return ErrMissingKey
+	}
+	plaintext, err := cipher.open(logRecord.Value, aad)
+	if err != nil {
+		return err
+	}
+	keySize, n := binary.Uvarint(plaintext)
+	if n <= 0 || uint64(len(plaintext)-n) < keySize {
+		return ErrWrongKey
+	}
+	logRecord.Key = plaintext[n : n+int(keySize)]
+	logRecord.Value = plaintext[n+int(keySize):]
+	return nil
+}
+
 func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 	if lr == nil {
 		return 0
@@ -115,10 +212,12 @@ type TransactionRecord struct {
 
 // EncodeLogRecordPos 对位置信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], pos.Expire)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
 	return buf[:index]
 }
 
@@ -127,6 +226,11 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
 	fileId, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
-	return &LogRecordPos{Fid: uint32(fileId), Offset: offset}
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	// 旧版本的编码中没有过期时间和大小，此时解码得到 0
+	expire, n := binary.Varint(buf[index:])
+	index += n
+	size, _ := binary.Varint(buf[index:])
+	return &LogRecordPos{Fid: uint32(fileId), Offset: offset, Expire: expire, Size: uint32(size)}
 }
\ No newline at end of file
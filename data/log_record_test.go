@@ -35,6 +35,23 @@ func TestEncodeLogRecord(t *testing.T) {
 	res3, n3 := EncodeLogRecord(rec3)
 	assert.NotNil(t, res3)
 	assert.Greater(t, n3, int64(5))
+
+	// 带有过期时间的情况
+	rec4 := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res4, n4 := EncodeLogRecord(rec4)
+	assert.NotNil(t, res4)
+	assert.Greater(t, n4, n1)
+	h4, size4 := decodeLogRecordHeader(res4)
+	assert.NotNil(t, h4)
+	assert.Equal(t, n4-int64(len(rec4.Key)+len(rec4.Value)), size4)
+	assert.Equal(t, LogRecordNormal, h4.recordType)
+	assert.Equal(t, rec4.Expire, h4.expire)
+	assert.Equal(t, h4.crc, getLogRecordCRC(rec4, res4[crc32.Size:size4]))
 }
 
 func TestDecodeLogRecordHeader(t *testing.T) {
@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bitcask-go/fio"
+	"bytes"
 	"fmt"
 	"github.com/gofrs/flock"
 	"bitcask-go/data"
@@ -9,17 +10,20 @@ import (
 	"path/filepath"
 	"errors"
 	"io"
+	"log"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"bitcask-go/utils"
 )
 
 const (
-	seqNoKey     = "seq.no"
-	fileLockName = "flock"
+	seqNoKey           = "seq.no"
+	fileLockName       = "flock"
+	tornTailFileSuffix = ".torn" // 保存被截断的数据的文件
 )
 // DB: bitcask 存储引擎实例
 type DB struct {
@@ -37,6 +41,28 @@ type DB struct {
 	isInitial       bool                 // 是否是第一次初始化此数据目录
 	fileLock        *flock.Flock         // 文件锁保证多进程之间的互斥
 	bytesWrite      uint                 // 累计写了多少个字节
+	reclaimSize     int64                // 表示有多少数据是无效的
+	fileReclaimSize map[uint32]int64     // 每个数据文件中有多少数据是无效的
+	autoMergeStop   chan struct{}        // 通知自动 merge 的协程退出
+	autoMergeDone   chan struct{}        // 自动 merge 的协程已经退出
+	fileRefs        map[*data.DataFile]int  // 每个数据文件被多少个快照引用
+	retiredFiles    map[*data.DataFile]bool // 已经被 merge 替换，但是仍然被快照引用的数据文件
+	watchers        map[*Watcher]struct{}   // 数据变更的订阅者
+	watchEvents     []*WatchEvent           // 持有互斥锁期间产生的变更事件，释放锁时投递
+	watchMu         *sync.Mutex             // 保证事件按照写入的顺序投递
+	logReaders      map[*LogReader]struct{} // 正在读取数据文件的 LogReader
+	lastMergeFileId uint32                  // 最近一次 merge 时没有参与 merge 的最小文件 id，用于判断 LogReader 的位置是否失效
+	readOnly        bool                    // 作为 follower 时只能通过主从复制写入数据
+	codec           data.Codec              // 压缩 value 使用的编解码器，为空表示不压缩
+	cipher          *data.Cipher            // 加密数据使用的 Cipher，为空表示不加密
+}
+
+// Stat 存储引擎统计信息
+type Stat struct {
+	KeyNum          uint  // key 的总数量
+	DataFileNum     uint  // 数据文件的数量
+	ReclaimableSize int64 // 可以进行 merge 回收的数据量，字节为单位
+	DiskSize        int64 // 数据目录所占磁盘空间大小
 }
 
 // Open 打开bitcask存储引擎实例
@@ -45,6 +71,16 @@ func Open(options Options) (*DB, error) {
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
+	codec, err := data.GetCodec(options.Compression)
+	if err != nil {
+		return nil, err
+	}
+	var cipher *data.Cipher
+	if options.KeyProvider != nil {
+		if cipher, err = data.NewCipher(options.KeyProvider); err != nil {
+			return nil, err
+		}
+	}
 	var isInitial bool
 	// 判断数据目录是否存在，如果不存在的话，则创建这个目录
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
@@ -76,54 +112,115 @@ func Open(options Options) (*DB, error) {
 		options:	options,
 		mu:			&sync.RWMutex{},
 		olderFiles:	make(map[uint32]*data.DataFile),
+		fileReclaimSize: make(map[uint32]int64),
+		fileRefs:        make(map[*data.DataFile]int),
+		retiredFiles:    make(map[*data.DataFile]bool),
+		watchers:        make(map[*Watcher]struct{}),
+		watchMu:         &sync.Mutex{},
+		logReaders:      make(map[*LogReader]struct{}),
 		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
 		isInitial:  isInitial,
 		fileLock:   fileLock,
+		codec:      codec,
+		cipher:     cipher,
 	}
 
+	// 加载数据文件和索引，失败时释放已经打开的文件和文件锁，修改配置之后可以重新打开
+	if err := db.load(); err != nil {
+		_ = db.index.Close()
+		if db.activeFile != nil {
+			_ = db.activeFile.Close()
+		}
+		for _, dataFile := range db.olderFiles {
+			_ = dataFile.Close()
+		}
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
+	// 启动自动 merge 的后台协程
+	if options.AutoMergeInterval > 0 {
+		db.autoMergeStop = make(chan struct{})
+		db.autoMergeDone = make(chan struct{})
+		go db.autoMerge()
+	}
+
+	return db, nil
+}
+
+// 加载数据文件，并从 hint 文件和数据文件中构建索引
+func (db *DB) load() error {
 	// 加载 merge 数据目录
 	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+		return err
+	}
+	if err := db.loadLastMergeFileId(); err != nil {
+		return err
 	}
 
 	// 从磁盘加载数据文件到内存
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
 	}
 	// B+树索引不需要从数据文件中加载索引
-	if options.IndexType != BPlusTree {
+	if db.options.IndexType != BPlusTree {
 		// 从 hint 索引文件中加载索引
 		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+			return err
 		}
 
 		// 遍历文件中所有记录，并更新到内存索引中
 		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+			return err
 		}
 
 		// 重置 IO 类型为标准文件 IO
 		if db.options.MMapAtStartup {
 			if err := db.resetIoType(); err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
 	// 取出当前事务序列号
-	if options.IndexType == BPlusTree {
+	if db.options.IndexType == BPlusTree {
 		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+			return err
 		}
+		// 索引不需要从数据文件中加载，只需要截断活跃文件末尾没有完整写入的数据
 		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
+			offset := db.activeFile.HeaderSize()
+			for {
+				_, size, err := readLogRecordForRecovery(db.activeFile, offset, true)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				offset += size
 			}
-			db.activeFile.WriteOff = size
+			if err := db.truncateTornTail(db.activeFile.FileId, offset); err != nil {
+				return err
+			}
+			db.activeFile.WriteOff = offset
 		}
 	}
 
-	return db, nil
+	// 活跃文件是旧版本的数据文件或者使用旧的密钥加密时，之后的数据写到新的文件中
+	// 保证同一个数据文件只使用一种格式和一个密钥，旧的文件在 merge 时重写
+	if db.activeFile != nil {
+		rewrite, err := db.needRewrite(db.activeFile)
+		if err != nil {
+			return err
+		}
+		if rewrite {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+			if err := db.setActiveDataFile(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // Delete 根据 key 删除对应的数据
@@ -131,6 +228,16 @@ func (db *DB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+	return db.delete(key)
+}
+
+// 写入墓碑值并删除索引。该方法必须持有互斥锁
+func (db *DB) delete(key []byte) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
 	if pos := db.index.Get(key); pos == nil {
 		return nil
 	}
@@ -140,40 +247,104 @@ func (db *DB) Delete(key []byte) error {
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
-	_, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	// 删除
-	ok := db.index.Delete(key)
-	if !ok {
-		return ErrIndexUpdateFailed
+	if err := db.deleteIndex(key, pos); err != nil {
+		return err
 	}
+	db.addWatchEvent(WatchDelete, key, nil, 0)
 	return nil
 }
 
 // Put 写入KV数据
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.putWithLock(key, value, 0)
+}
+
+// PutWithTTL 写入KV数据并设置存活时间，过期之后的 key 视为不存在
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.putWithLock(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// Expire 为已经存在的 key 设置新的存活时间，key 不存在时返回 ErrKeyNotFound
+func (db *DB) Expire(key []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+
+	value, err := db.get(key)
+	if err != nil {
+		return err
+	}
+	return db.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// TTL 返回 key 剩余的存活时间，没有设置过期时间时返回 0，key 不存在时返回 ErrKeyNotFound
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pos := db.index.Get(key)
+	if pos == nil {
+		return 0, ErrKeyNotFound
+	}
+	if pos.Expire == 0 {
+		return 0, nil
+	}
+	ttl := time.Duration(pos.Expire - time.Now().UnixNano())
+	if ttl <= 0 {
+		return 0, ErrKeyNotFound
+	}
+	return ttl, nil
+}
+
+func (db *DB) putWithLock(key []byte, value []byte, expire int64) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+	return db.put(key, value, expire)
+}
+
+// 写入数据并更新索引。该方法必须持有互斥锁
+func (db *DB) put(key []byte, value []byte, expire int64) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
 
 	// 追加写到当前活跃数据文件中
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	// 更新内存索引
-	if ok := db.index.Put(key, pos); !ok {
-		return ErrIndexUpdateFailed
+	if err := db.putIndex(key, pos); err != nil {
+		return err
 	}
+	db.addWatchEvent(WatchPut, key, value, expire)
 	return nil
 }
 
@@ -185,10 +356,14 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	return db.get(key)
+}
 
-	// 从内存中获取key的索引信息，如果内存中无所引则key不存在
+// 根据 key 读取数据。该方法必须持有读锁或者互斥锁
+func (db *DB) get(key []byte) ([]byte, error) {
+	// 从内存中获取key的索引信息，如果内存中无所引或者已经过期则key不存在
 	logRecordPos := db.index.Get(key)
-	if logRecordPos == nil {
+	if logRecordPos == nil || logRecordPos.IsExpired() {
 		return nil, ErrKeyNotFound
 	}
 
@@ -196,6 +371,70 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
+// CompareAndSwap 当 key 当前的 value 等于 oldValue 时写入 newValue
+// key 不存在时返回 ErrKeyNotFound，value 不一致时返回 ErrValueMismatch
+func (db *DB) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+
+	if err := db.checkValue(key, oldValue); err != nil {
+		return err
+	}
+	return db.put(key, newValue, 0)
+}
+
+// PutIfAbsent 当 key 不存在时写入数据，key 已经存在时返回 ErrKeyAlreadyExists
+func (db *DB) PutIfAbsent(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+
+	if err := db.checkAbsent(key); err != nil {
+		return err
+	}
+	return db.put(key, value, 0)
+}
+
+// DeleteIfEquals 当 key 当前的 value 等于 value 时删除
+// key 不存在时返回 ErrKeyNotFound，value 不一致时返回 ErrValueMismatch
+func (db *DB) DeleteIfEquals(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.mu.Lock()
+	defer db.unlockAndNotify()
+
+	if err := db.checkValue(key, value); err != nil {
+		return err
+	}
+	return db.delete(key)
+}
+
+// 检查 key 当前的 value 是否等于 expected。该方法必须持有互斥锁
+func (db *DB) checkValue(key []byte, expected []byte) error {
+	value, err := db.get(key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, expected) {
+		return ErrValueMismatch
+	}
+	return nil
+}
+
+// 检查 key 是否不存在，已经过期的 key 视为不存在。该方法必须持有互斥锁
+func (db *DB) checkAbsent(key []byte) error {
+	if pos := db.index.Get(key); pos != nil && !pos.IsExpired() {
+		return ErrKeyAlreadyExists
+	}
+	return nil
+}
+
 // Close 关闭数据库
 func (db *DB) Close() error {
 	defer func() {
@@ -203,6 +442,10 @@ func (db *DB) Close() error {
 			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
 		}
 	}()
+	// 等待自动 merge 的协程退出
+	db.stopAutoMerge()
+	// 关闭所有的订阅
+	db.closeWatchers()
 	if db.activeFile == nil {
 		return nil
 	}
@@ -223,7 +466,7 @@ func (db *DB) Close() error {
 		Key:   []byte(seqNoKey),
 		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
+	encRecord, _ := data.EncodeLogRecordWith(record, nil, db.cipher)
 	if err := seqNoFile.Write(encRecord); err != nil {
 		return err
 	}
@@ -241,9 +484,38 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+	// 关闭仍然被快照引用的数据文件
+	for file := range db.retiredFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+		delete(db.retiredFiles, file)
+	}
 	return nil
 }
 
+// Stat 返回数据库的相关统计信息，获取数据目录的大小失败时返回错误
+func (db *DB) Stat() (*Stat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var dataFiles = uint(len(db.olderFiles))
+	if db.activeFile != nil {
+		dataFiles += 1
+	}
+
+	dirSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Stat{
+		KeyNum:          uint(db.index.Size()),
+		DataFileNum:     dataFiles,
+		ReclaimableSize: db.reclaimSize,
+		DiskSize:        dirSize,
+	}, nil
+}
+
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
 	if db.activeFile == nil {
@@ -259,11 +531,13 @@ func (db *DB) Sync() error {
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys := make([][]byte, 0, db.index.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		// 跳过已经过期的 key
+		if iterator.Value().IsExpired() {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -276,6 +550,9 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired() {
+			continue
+		}
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -296,6 +573,11 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	} else {
 		dataFile = db.olderFiles[logRecordPos.Fid]
 	}
+	return readValue(dataFile, logRecordPos)
+}
+
+// 从数据文件中读取位置索引对应的 value
+func readValue(dataFile *data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
 	// 数据文件为空
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -314,10 +596,36 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	return logRecord.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// 更新内存索引，被覆盖的旧数据计入可回收的空间。该方法必须持有互斥锁
+func (db *DB) putIndex(key []byte, pos *data.LogRecordPos) error {
+	oldPos := db.index.Get(key)
+	if ok := db.index.Put(key, pos); !ok {
+		return ErrIndexUpdateFailed
+	}
+	if oldPos != nil {
+		db.addReclaimSize(oldPos)
+	}
+	return nil
+}
+
+// 删除内存索引，被删除的数据以及墓碑值本身都计入可回收的空间。该方法必须持有互斥锁
+func (db *DB) deleteIndex(key []byte, pos *data.LogRecordPos) error {
+	db.addReclaimSize(pos)
+	oldPos := db.index.Get(key)
+	if oldPos == nil {
+		return nil
+	}
+	if ok := db.index.Delete(key); !ok {
+		return ErrIndexUpdateFailed
+	}
+	db.addReclaimSize(oldPos)
+	return nil
+}
+
+// 将对应位置的数据标记为无效，统计到所在的数据文件中。该方法必须持有互斥锁
+func (db *DB) addReclaimSize(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	db.fileReclaimSize[pos.Fid] += int64(pos.Size)
 }
 
 // 追加写数据到活跃文件中，删除也用的这个
@@ -330,7 +638,7 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	encRecord, size := data.EncodeLogRecordWith(logRecord, db.codec, db.cipher)
 
 	// 如果写入的数据已经到达活跃文件的阈值，则关闭活跃文件并打开新的文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
@@ -373,6 +681,8 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	pos := &data.LogRecordPos{
 		Fid:    db.activeFile.FileId,
 		Offset: writeOff,
+		Expire: logRecord.Expire,
+		Size:   uint32(size),
 	}
 	return pos, nil
 }
@@ -390,7 +700,9 @@ func (db *DB) setActiveDataFile() error {
 	if err != nil {
 		return err
 	}
+	dataFile.Cipher = db.cipher
 	db.activeFile = dataFile
+	db.addFileToLogReaders(dataFile)
 	return nil
 }
 
@@ -426,9 +738,13 @@ func (db *DB) loadDataFiles() error {
 			ioType = fio.MemoryMap
 		}
 		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		if err == data.ErrInvalidFileHeader && i == len(fileIds)-1 {
+			dataFile, err = db.recoverTornHeader(uint32(fid))
+		}
 		if err != nil {
 			return err
 		}
+		dataFile.Cipher = db.cipher
 		if i == len(fileIds)-1 { // 最后一个，id是最大的，说明是当前活跃文件
 			db.activeFile = dataFile
 		} else { // 说明是旧的数据文件
@@ -458,21 +774,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var ok bool
-		if typ == data.LogRecordDeleted {
-			ok = db.index.Delete(key)
-		} else {
-			ok = db.index.Put(key, pos)
-		}
-		if !ok {
-			panic("failed to update index at startup")
-		}
-	}
-
-	// 暂存事务数据
-	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
+	replayer := newLogReplayer(db)
 
 	// 遍历所有的文件id，处理文件中的记录
 	for i, fid := range db.filesIds {
@@ -488,9 +790,10 @@ func (db *DB) loadIndexFromDataFiles() error {
 			dataFile = db.olderFiles[fileId]
 		}
 
-		var offset int64 = 0
+		isActiveFile := i == len(db.filesIds)-1
+		offset := dataFile.HeaderSize()
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			logRecord, size, err := readLogRecordForRecovery(dataFile, offset, isActiveFile)
 			if err != nil {
 				if err == io.EOF {
 					break
@@ -499,49 +802,228 @@ func (db *DB) loadIndexFromDataFiles() error {
 			}
 
 			// 构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset}
-
-			// 解析 key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				// 事务完成，对应的 seq no 的数据可以更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
+			logRecordPos := &data.LogRecordPos{
+				Fid:    fileId,
+				Offset: offset,
+				Expire: logRecord.Expire,
+				Size:   uint32(size),
 			}
-
-			// 更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
+			if err := replayer.replay(logRecord, logRecordPos); err != nil {
+				return err
 			}
 
 			// 递增 offset，下一次从新的位置开始读取
 			offset += size
 		}
 
-		// 如果是当前活跃文件，更新这个文件的 WriteOff
-		if i == len(db.filesIds)-1 {
+		// 如果是当前活跃文件，截断末尾没有完整写入的数据，并更新这个文件的 WriteOff
+		if isActiveFile {
+			if err := db.truncateTornTail(fileId, offset); err != nil {
+				return err
+			}
 			db.activeFile.WriteOff = offset
 		}
 	}
 
+	// 没有提交完成的事务数据都是无效的
+	replayer.discardPending()
+
 	// 更新事务序列号
-	db.seqNo = currentSeqNo
+	db.seqNo = replayer.seqNo
 	return nil
 }
 
+// 读取数据文件中的一条数据，活跃文件中最后一条数据损坏时，说明写入时进程崩溃，同样返回 io.EOF，之后截断
+// 损坏的数据之后还有其他数据时，说明数据文件损坏，无法恢复
+// 数据的长度超出文件末尾时同样返回 io.EOF，如果之后还有完整的数据，说明是中间数据的长度损坏，返回 ErrDataFileCorrupted
+func readLogRecordForRecovery(dataFile *data.DataFile, offset int64, isActiveFile bool) (*data.LogRecord, int64, error) {
+	logRecord, size, err := dataFile.ReadLogRecord(offset)
+	if err != io.EOF && err != data.ErrInvalidCRC {
+		return logRecord, size, err
+	}
+	fileSize, sizeErr := dataFile.IoManager.Size()
+	if sizeErr != nil {
+		return nil, 0, sizeErr
+	}
+	if err == data.ErrInvalidCRC {
+		if isActiveFile && offset+size >= fileSize {
+			return nil, 0, io.EOF
+		}
+		return logRecord, size, err
+	}
+
+	followed, scanErr := logRecordsFollow(dataFile, offset, fileSize)
+	if scanErr != nil {
+		return nil, 0, scanErr
+	}
+	if followed {
+		return nil, 0, ErrDataFileCorrupted
+	}
+	return nil, 0, io.EOF
+}
+
+// 判断 offset 处无法读取的数据之后是否还有完整的数据
+// 从 offset 之后逐字节查找可以通过 crc 校验的数据，并且从这个位置开始的数据可以连续读取到文件末尾
+// 没有完整写入的数据中可能包含 value 中的数据，要求读取到文件末尾可以避免把这部分数据当作完整的数据
+func logRecordsFollow(dataFile *data.DataFile, offset int64, fileSize int64) (bool, error) {
+	for start := offset + 1; start < fileSize; start++ {
+		pos := start
+		for pos < fileSize {
+			_, size, err := dataFile.ReadLogRecord(pos)
+			if err == io.EOF || err == data.ErrInvalidCRC {
+				break
+			}
+			// 其他错误时 size 为 0 表示读取失败，否则 crc 校验已经通过
+			if err != nil && size == 0 {
+				return false, err
+			}
+			pos += size
+		}
+		if pos == fileSize {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 截断活跃文件中 offset 之后没有完整写入的数据，否则之后写入的数据会追加在这部分数据之后
+func (db *DB) truncateTornTail(fileId uint32, offset int64) error {
+	fileName := data.GetDataFileName(db.options.DirPath, fileId)
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	if offset >= stat.Size() {
+		return nil
+	}
+
+	tail := TornTail{FileId: fileId, Offset: offset, Size: stat.Size() - offset}
+	if db.options.QuarantineTornTail {
+		tail.QuarantinePath = filepath.Join(db.options.DirPath, fmt.Sprintf("%09d-%d%s", fileId, offset, tornTailFileSuffix))
+		if err := copyFileRange(fileName, tail.QuarantinePath, offset, tail.Size); err != nil {
+			return err
+		}
+	}
+	if err := os.Truncate(fileName, offset); err != nil {
+		return err
+	}
+
+	if db.options.OnTornTail != nil {
+		db.options.OnTornTail(tail)
+	} else {
+		log.Printf("truncated %d bytes of torn write at offset %d of data file %d\n", tail.Size, tail.Offset, tail.FileId)
+	}
+	return nil
+}
+
+// 最后一个数据文件的文件头没有完整写入，说明创建文件时进程崩溃，文件中还没有数据，截断之后重新创建
+func (db *DB) recoverTornHeader(fileId uint32) (*data.DataFile, error) {
+	fileName := data.GetDataFileName(db.options.DirPath, fileId)
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() >= data.FileHeaderSize {
+		return nil, data.ErrInvalidFileHeader
+	}
+	if err := db.truncateTornTail(fileId, 0); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(fileName); err != nil {
+		return nil, err
+	}
+	return data.OpenDataFile(db.options.DirPath, fileId, fio.StandardFIO)
+}
+
+// 将文件中的一段数据复制到新的文件中
+func copyFileRange(src string, dest string, offset int64, size int64) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf := make([]byte, size)
+	if _, err := file.ReadAt(buf, offset); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, buf, fio.DataFilePerm)
+}
+
+// 按照写入的顺序重放数据文件中的数据，更新内存索引
+// 事务数据暂存起来，读取到事务完成的标识之后才会更新到内存索引中
+type logReplayer struct {
+	db                 *DB
+	transactionRecords map[uint64][]*data.TransactionRecord // 暂存的事务数据
+	seqNo              uint64                               // 读取到的最大的事务序列号
+}
+
+func newLogReplayer(db *DB) *logReplayer {
+	return &logReplayer{
+		db:                 db,
+		transactionRecords: make(map[uint64][]*data.TransactionRecord),
+		seqNo:              nonTransactionSeqNo,
+	}
+}
+
+// 重放一条数据，pos 为数据在数据文件中的位置。该方法必须持有互斥锁，或者在启动时调用
+func (rp *logReplayer) replay(logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+	// 解析 key，拿到事务序列号
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if seqNo == nonTransactionSeqNo {
+		// 非事务操作，直接更新内存索引
+		if err := rp.updateIndex(realKey, logRecord.Type, pos); err != nil {
+			return err
+		}
+	} else {
+		// 事务完成，对应的 seq no 的数据可以更新到内存索引中
+		if logRecord.Type == data.LogRecordTxnFinished {
+			for _, txnRecord := range rp.transactionRecords[seqNo] {
+				if err := rp.updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos); err != nil {
+					return err
+				}
+			}
+			delete(rp.transactionRecords, seqNo)
+			// 标识事务完成的记录本身是无效数据
+			rp.db.addReclaimSize(pos)
+		} else {
+			logRecord.Key = realKey
+			rp.transactionRecords[seqNo] = append(rp.transactionRecords[seqNo], &data.TransactionRecord{
+				Record: logRecord,
+				Pos:    pos,
+			})
+		}
+	}
+
+	// 更新事务序列号
+	if seqNo > rp.seqNo {
+		rp.seqNo = seqNo
+	}
+	return nil
+}
+
+func (rp *logReplayer) updateIndex(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) error {
+	// 已经过期的数据视为被删除
+	if typ == data.LogRecordDeleted || pos.IsExpired() {
+		return rp.db.deleteIndex(key, pos)
+	}
+	return rp.db.putIndex(key, pos)
+}
+
+// 是否有还没有读取到完成标识的事务
+func (rp *logReplayer) hasPending() bool {
+	return len(rp.transactionRecords) > 0
+}
+
+// 丢弃没有提交完成的事务数据，这些数据都是无效的
+func (rp *logReplayer) discardPending() {
+	for seqNo, txnRecords := range rp.transactionRecords {
+		for _, txnRecord := range txnRecords {
+			rp.db.addReclaimSize(txnRecord.Pos)
+		}
+		delete(rp.transactionRecords, seqNo)
+	}
+}
+
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
 		return errors.New("the database directory is empty")
@@ -549,6 +1031,15 @@ func checkOptions(options Options) error {
 	if options.DataFileSize == 0 {
 		return errors.New("the data file size is 0")
 	}
+	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
+		return errors.New("invalid merge ratio, must between 0 and 1")
+	}
+	if options.AutoMergeRatio < 0 || options.AutoMergeRatio > 1 {
+		return errors.New("invalid auto merge ratio, must between 0 and 1")
+	}
+	if options.KeyProvider != nil && options.IndexType == BPlusTree {
+		return errors.New("encryption is not supported by the B+ tree index, keys are stored in plain text")
+	}
 	return nil
 }
 
@@ -562,7 +1053,12 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
+	seqNoFile.Cipher = db.cipher
 	record, _, err := seqNoFile.ReadLogRecord(0)
+	_ = seqNoFile.Close()
+	if err != nil {
+		return err
+	}
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
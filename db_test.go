@@ -1,10 +1,18 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"encoding/binary"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // 测试完成之后销毁 DB 数据目录
@@ -17,6 +25,7 @@ func destroyDB(db *DB) {
 		if err != nil {
 			panic(err)
 		}
+		_ = os.RemoveAll(db.getMergePath())
 	}
 }
 
@@ -337,3 +346,629 @@ func TestDB_Sync(t *testing.T) {
 	err = db.Sync()
 	assert.Nil(t, err)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.ttl 不合法
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), 0)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	// 2.未过期时可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(10), time.Hour)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(10))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+	assert.Equal(t, 3, len(db.ListKeys()))
+
+	// 3.过期之后视为不存在
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.NotEqual(t, utils.GetTestKey(1), iter.Key())
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 2, count)
+
+	count = 0
+	err = db.Fold(func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	// 4.重启之后过期的数据不会被加载
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val2, err := db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, val2)
+	assert.Equal(t, 2, len(db2.ListKeys()))
+
+	// 5.merge 之后过期的数据被清理
+	err = db2.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(10), 50*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, db3.index.Size())
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Stat(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stat")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 100; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	stat1, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(9900), stat1.KeyNum)
+	assert.Equal(t, uint(1), stat1.DataFileNum)
+	assert.Equal(t, int64(0), stat1.ReclaimableSize)
+	assert.True(t, stat1.DiskSize > 0)
+
+	// 覆盖写和删除的数据都可以回收
+	for i := 100; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 1000; i < 2000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	stat2, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(8900), stat2.KeyNum)
+	assert.True(t, stat2.ReclaimableSize > 0)
+
+	// 重启之后重新统计的结果一致
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	stat3, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, stat2.KeyNum, stat3.KeyNum)
+	assert.Equal(t, stat2.ReclaimableSize, stat3.ReclaimableSize)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Expire(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-expire")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Expire(utils.GetTestKey(1), time.Second)
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 没有设置过期时间
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	err = db.Expire(utils.GetTestKey(1), 50*time.Millisecond)
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 50*time.Millisecond)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.TTL(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// key 不存在
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	// value 不一致
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("x"), []byte("b"))
+	assert.Equal(t, ErrValueMismatch, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.PutIfAbsent(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.PutIfAbsent(utils.GetTestKey(1), []byte("b"))
+	assert.Equal(t, ErrKeyAlreadyExists, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// 过期的 key 视为不存在
+	err = db.PutWithTTL(utils.GetTestKey(2), []byte("a"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(5 * time.Millisecond)
+	err = db.PutIfAbsent(utils.GetTestKey(2), []byte("b"))
+	assert.Nil(t, err)
+}
+
+func TestDB_DeleteIfEquals(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-if-equals")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("b"))
+	assert.Equal(t, ErrValueMismatch, err)
+	err = db.DeleteIfEquals(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"payload":"%s"}`, i, strings.Repeat("bitcask-go ", 100)))
+	}
+
+	// 没有压缩的数据
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	rawSize := stat.DiskSize
+	assert.Nil(t, db.Close())
+
+	// 压缩和没有压缩的数据文件可以同时读取
+	opts.Compression = GzipCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+	}
+	stat, err = db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskSize-rawSize < rawSize/4)
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value(i), val)
+	}
+
+	// merge 之后使用当前的压缩方式重写
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	opts.Compression = FlateCompression
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	stat, err = db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskSize < rawSize/2)
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value(i), val)
+	}
+
+	// 使用其他压缩方式压缩的数据文件，没有无效数据也会在 merge 时重写
+	db.options.DataFileMergeRatio = 0.5
+	assert.Nil(t, db.Merge())
+	assert.True(t, len(db.olderFiles) > 0)
+	for _, file := range db.olderFiles {
+		// 没有数据的文件
+		codecId, err := file.ReadCodecId(file.HeaderSize())
+		if err == io.EOF {
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, FlateCompression, codecId)
+	}
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value(i), val)
+	}
+
+	// 没有注册的压缩方式
+	opts.Compression = 10
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-compression-unknown")
+	defer os.RemoveAll(opts.DirPath)
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrUnknownCodec, err)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	keys := map[uint32][]byte{1: []byte("0123456789abcdef")}
+	opts.KeyProvider = &data.StaticKeyProvider{Current: 1, Keys: keys}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("secret-value-%d", i))
+	}
+	// 没有加密的数据和加密的数据可以同时读取
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	// 磁盘上的文件中没有明文
+	assertEncrypted := func() {
+		entries, err := os.ReadDir(dir)
+		assert.Nil(t, err)
+		for _, entry := range entries {
+			if entry.Name() == fileLockName {
+				continue
+			}
+			content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			assert.Nil(t, err)
+			assert.False(t, strings.Contains(string(content), "secret-value"), entry.Name())
+			assert.False(t, strings.Contains(string(content), "bitcask-go-key"), entry.Name())
+			assert.False(t, strings.Contains(string(content), mergeFinishedKey), entry.Name())
+		}
+	}
+	assertEncrypted()
+
+	// 密钥不正确或者没有配置密钥时无法打开
+	opts.KeyProvider = &data.StaticKeyProvider{Current: 1, Keys: map[uint32][]byte{1: []byte("fedcba9876543210")}}
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrWrongKey, err)
+	opts.KeyProvider = nil
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrMissingKey, err)
+
+	// 更换密钥之后写入的数据使用新的密钥，merge 时使用新的密钥重写旧的数据
+	keys[2] = []byte("0123456789abcdef0123456789abcdef")
+	opts.KeyProvider = &data.StaticKeyProvider{Current: 2, Keys: keys}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 500; i < 600; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	assertEncrypted()
+
+	// merge 完成之后不再需要旧的密钥
+	opts.KeyProvider = &data.StaticKeyProvider{Current: 2, Keys: map[uint32][]byte{2: keys[2]}}
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 1; i < 600; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value(i), val)
+	}
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), val)
+
+	// B+ 树索引中的 key 没有加密
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_LegacyDataFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-legacy")
+	opts.DirPath = dir
+
+	// 旧版本的数据文件没有文件头，最后一个空的文件是活跃文件
+	var content []byte
+	for i := 0; i < 100; i++ {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeq(utils.GetTestKey(i), nonTransactionSeqNo),
+			Value: utils.GetTestKey(i),
+		})
+		content = append(content, encRecord...)
+	}
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir, 0), content, 0644))
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir, 1), nil, 0644))
+
+	// 旧版本的数据文件可以直接读取，新的数据写到带有文件头的文件中
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.olderFiles[0].Header)
+	assert.Nil(t, db.olderFiles[1].Header)
+	assert.Equal(t, uint32(2), db.activeFile.FileId)
+	assert.NotNil(t, db.activeFile.Header)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+
+	// merge 时重写所有旧版本的数据文件
+	opts.DataFileMergeRatio = 1
+	db.options.DataFileMergeRatio = 1
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db.activeFile.Header)
+	for _, dataFile := range db.olderFiles {
+		assert.NotNil(t, dataFile.Header)
+		assert.Equal(t, data.CurrentFileVersion, dataFile.Header.Version)
+	}
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	val, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 不能识别的版本
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-legacy-version")
+	defer os.RemoveAll(opts.DirPath)
+	header := make([]byte, data.FileHeaderSize)
+	copy(header, "BCSK")
+	binary.LittleEndian.PutUint16(header[4:], data.CurrentFileVersion+1)
+	binary.LittleEndian.PutUint32(header[8:], 100)
+	binary.LittleEndian.PutUint32(header[20:], crc32.ChecksumIEEE(header[:20]))
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(opts.DirPath, 100), header, 0644))
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrUnsupportedFileVersion, err)
+}
+
+func TestDB_TornTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-torn-tail")
+	opts.DirPath = dir
+	var tails []TornTail
+	opts.OnTornTail = func(tail TornTail) {
+		tails = append(tails, tail)
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	fileName := data.GetDataFileName(dir, db.activeFile.FileId)
+	assert.Nil(t, db.Close())
+
+	appendBytes := func(buf []byte) int64 {
+		stat, err := os.Stat(fileName)
+		assert.Nil(t, err)
+		file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+		assert.Nil(t, err)
+		_, err = file.Write(buf)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+		return stat.Size()
+	}
+	assertKeys := func(db *DB, n int) {
+		for i := 0; i < n; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+	}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("torn"), nonTransactionSeqNo),
+		Value: []byte("torn-value"),
+	})
+
+	// 最后一条数据只写入了一半
+	offset := appendBytes(encRecord[:len(encRecord)/2])
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assertKeys(db, 10)
+	assert.Equal(t, []TornTail{{FileId: 0, Offset: offset, Size: int64(len(encRecord) / 2)}}, tails)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, offset, stat.Size())
+	assert.Nil(t, db.Put(utils.GetTestKey(10), utils.GetTestKey(10)))
+	assert.Nil(t, db.Close())
+
+	// 最后一条数据完整写入但是 crc 校验失败，截断的数据保存到 .torn 文件
+	corrupted := append([]byte(nil), encRecord...)
+	corrupted[len(corrupted)-1]++
+	offset = appendBytes(corrupted)
+	opts.QuarantineTornTail = true
+	tails = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assertKeys(db, 11)
+	_, err = db.Get([]byte("torn"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 1, len(tails))
+	assert.Equal(t, offset, tails[0].Offset)
+	quarantined, err := os.ReadFile(tails[0].QuarantinePath)
+	assert.Nil(t, err)
+	assert.Equal(t, corrupted, quarantined)
+	assert.Nil(t, db.Close())
+
+	// 新建的数据文件的文件头没有完整写入
+	nextFileName := data.GetDataFileName(dir, 1)
+	assert.Nil(t, os.WriteFile(nextFileName, []byte("BCSK\x01"), 0644))
+	tails = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []TornTail{{FileId: 1, Offset: 0, Size: 5, QuarantinePath: filepath.Join(dir, "000000001-0.torn")}}, tails)
+	assert.NotNil(t, db.activeFile.Header)
+	assert.Nil(t, db.Put(utils.GetTestKey(11), utils.GetTestKey(11)))
+	assertKeys(db, 12)
+	fileName = data.GetDataFileName(dir, db.activeFile.FileId)
+	assert.Nil(t, db.Close())
+
+	// 损坏的数据之后还有其他数据，无法恢复
+	offset = appendBytes(corrupted)
+	appendBytes(encRecord)
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Nil(t, os.Truncate(fileName, offset))
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assertKeys(db, 12)
+}
+
+func TestDB_CorruptedLength(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-corrupted-length")
+	opts.DirPath = dir
+	var tails []TornTail
+	opts.OnTornTail = func(tail TornTail) {
+		tails = append(tails, tail)
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	pos := db.index.Get(utils.GetTestKey(5))
+	fileName := data.GetDataFileName(dir, db.activeFile.FileId)
+	assert.Nil(t, db.Close())
+	defer os.RemoveAll(dir)
+
+	// 中间数据的 key size 损坏，长度超出文件末尾，之后的数据不能被当作没有完整写入的数据截断
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	buf[pos.Offset+5] = 0x7f
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+
+	_, err = Open(opts)
+	assert.Equal(t, ErrDataFileCorrupted, err)
+	assert.Nil(t, tails)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(buf)), stat.Size())
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	problems := findProblems(report, ProblemCorruptedLength)
+	assert.Equal(t, 1, len(problems))
+	assert.Equal(t, pos.Offset, problems[0].Offset)
+	assert.Equal(t, 0, len(findProblems(report, ProblemTornTail)))
+}
+
+func TestDB_TornTail_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-torn-tail-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.MMapAtStartup = false
+	var tails []TornTail
+	opts.OnTornTail = func(tail TornTail) {
+		tails = append(tails, tail)
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	fileName := data.GetDataFileName(dir, db.activeFile.FileId)
+	assert.Nil(t, db.Close())
+
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []TornTail{{FileId: 0, Offset: stat.Size(), Size: 3}}, tails)
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v2")))
+	for _, key := range []string{"k1", "k2"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(strings.Replace(key, "k", "v", 1)), val)
+	}
+}
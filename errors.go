@@ -8,7 +8,24 @@ var (
 	ErrKeyNotFound            = errors.New("key not found in database")
 	ErrDataFileNotFound       = errors.New("data file is not found")
 	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
+	ErrDataFileCorrupted      = errors.New("the data file is corrupted, complete log records follow an unreadable one")
 	ErrExceedMaxBatchNum      = errors.New("exceed the max batch num")
 	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
+	ErrInvalidTTL             = errors.New("the ttl must be greater than 0")
+	ErrMergeRatioUnreached    = errors.New("no data file reaches the merge ratio")
+	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys read have been changed")
+	ErrTxnFinished            = errors.New("the transaction has been committed or rolled back")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrValueMismatch          = errors.New("the value does not match the expected value")
+	ErrKeyAlreadyExists       = errors.New("the key already exists")
+	ErrValueNotInteger        = errors.New("the value is not an integer")
+	ErrValueNotFloat          = errors.New("the value is not a valid float")
+	ErrIncrOverflow           = errors.New("increment or decrement would overflow")
+	ErrInvalidLogPosition     = errors.New("the log position does not point to a log record")
+	ErrLogReaderClosed        = errors.New("the log reader has been closed")
+	ErrReadOnly               = errors.New("the database is read-only")
+	ErrReplicationProtocol    = errors.New("unexpected replication message")
+	ErrFollowerClosed         = errors.New("the follower has been closed")
 )
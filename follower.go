@@ -0,0 +1,437 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	replicationPosFileName       = "replication-pos"
+	replicationSnapshotDirName   = "-replication"
+	replicationReconnectInterval = time.Second
+	replicationSaveInterval      = time.Second // 保存同步位置的最小间隔
+)
+
+// ReplicationStat follower 的同步状态
+type ReplicationStat struct {
+	Connected   bool        // 是否已经连接到 primary
+	Pos         LogPosition // 已经同步到的 primary 数据文件中的位置
+	PrimaryPos  LogPosition // 最近一次心跳中 primary 最新的位置
+	LagBytes    int64       // 落后于 primary 的数据量，字节为单位
+	LastContact time.Time   // 最近一次收到 primary 消息的时间
+	Snapshots   int         // 安装快照的次数
+}
+
+// Follower 主从复制的 follower，从 primary 同步数据，本地的数据库只能读取
+// 同步的数据追加写到本地的数据文件中，并使用和启动时加载索引相同的方式更新索引，事务数据在提交完成之后才可见
+type Follower struct {
+	options     Options
+	primaryAddr string
+	db          *DB
+	replayer    *logReplayer // 只在同步协程中使用
+	pos         LogPosition  // 已经同步到的位置，只在同步协程中使用
+	hasPos      bool
+	lastSave    time.Time
+
+	mu     sync.Mutex // 保护 stat、conn 和 closed
+	stat   ReplicationStat
+	conn   net.Conn
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// OpenFollower 打开本地的数据库，并在后台从 primaryAddr 同步数据
+// 本地的数据库没有同步过，或者落后太多时，先从 primary 同步一份快照
+func OpenFollower(options Options, primaryAddr string) (*Follower, error) {
+	db, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+	db.readOnly = true
+
+	f := &Follower{
+		options:     options,
+		primaryAddr: primaryAddr,
+		db:          db,
+		replayer:    newLogReplayer(db),
+		done:        make(chan struct{}),
+	}
+	f.pos, f.hasPos, err = loadReplicationPos(options.DirPath)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	f.stat.Pos = f.pos
+
+	f.wg.Add(1)
+	go f.run()
+	return f, nil
+}
+
+// DB 返回本地的数据库，只能读取，写入时返回 ErrReadOnly
+// 安装快照时会重新加载数据文件，之前创建的迭代器不再可用
+func (f *Follower) DB() *DB {
+	return f.db
+}
+
+// Stat 返回同步状态
+func (f *Follower) Stat() ReplicationStat {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stat
+}
+
+// Promote 停止同步并将本地的数据库转换为可以写入的数据库，用于 primary 故障时切换
+// 没有提交完成的事务数据会被丢弃，之后 Follower 不再可用
+func (f *Follower) Promote() (*DB, error) {
+	if !f.stop() {
+		return nil, ErrFollowerClosed
+	}
+	f.db.mu.Lock()
+	f.replayer.discardPending()
+	f.db.readOnly = false
+	f.db.mu.Unlock()
+
+	if err := os.Remove(filepath.Join(f.options.DirPath, replicationPosFileName)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return f.db, nil
+}
+
+// Close 停止同步并关闭本地的数据库
+func (f *Follower) Close() error {
+	if !f.stop() {
+		return nil
+	}
+	if !f.replayer.hasPending() {
+		if err := f.savePos(); err != nil {
+			_ = f.db.Close()
+			return err
+		}
+	}
+	return f.db.Close()
+}
+
+// 停止同步协程，返回是否是第一次调用
+func (f *Follower) stop() bool {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return false
+	}
+	f.closed = true
+	close(f.done)
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return true
+}
+
+// 同步协程，连接断开之后自动重连
+func (f *Follower) run() {
+	defer f.wg.Done()
+	for {
+		err := f.replicate()
+
+		f.mu.Lock()
+		f.stat.Connected = false
+		f.conn = nil
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			return
+		}
+		if err != nil {
+			log.Printf("replication from %s failed: %v\n", f.primaryAddr, err)
+		}
+
+		select {
+		case <-f.done:
+			return
+		case <-time.After(replicationReconnectInterval):
+		}
+	}
+}
+
+// 连接到 primary 并处理收到的消息，直到连接断开
+func (f *Follower) replicate() error {
+	conn, err := net.DialTimeout("tcp", f.primaryAddr, replicationReadTimeout)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		_ = conn.Close()
+		return nil
+	}
+	f.conn = conn
+	f.mu.Unlock()
+	defer conn.Close()
+
+	writer := bufio.NewWriter(conn)
+	hello := &replicationMessage{Type: replicationHello, Pos: f.pos, HasPos: f.hasPos}
+	if err := gob.NewEncoder(writer).Encode(hello); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.stat.Connected = true
+	f.mu.Unlock()
+
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	snapshotDir := f.getSnapshotPath()
+	receivingSnapshot := false
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(replicationReadTimeout))
+		var msg replicationMessage
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case replicationSnapshotFile:
+			if !receivingSnapshot {
+				if err := os.RemoveAll(snapshotDir); err != nil {
+					return err
+				}
+				if err := os.MkdirAll(snapshotDir, os.ModePerm); err != nil {
+					return err
+				}
+				receivingSnapshot = true
+			}
+			if err := appendSnapshotFile(snapshotDir, msg.FileName, msg.FileData); err != nil {
+				return err
+			}
+			continue
+		case replicationSnapshotEnd:
+			if !receivingSnapshot {
+				if err := os.MkdirAll(snapshotDir, os.ModePerm); err != nil {
+					return err
+				}
+			}
+			receivingSnapshot = false
+			if err := f.installSnapshot(snapshotDir, msg.Pos); err != nil {
+				return err
+			}
+		case replicationRecords:
+			if err := f.apply(&msg); err != nil {
+				return err
+			}
+		case replicationHeartbeat:
+		default:
+			return ErrReplicationProtocol
+		}
+
+		f.mu.Lock()
+		f.stat.Pos = f.pos
+		f.stat.LagBytes = msg.LagBytes
+		f.stat.LastContact = time.Now()
+		if msg.Type == replicationHeartbeat {
+			f.stat.PrimaryPos = msg.Pos
+		}
+		f.mu.Unlock()
+	}
+}
+
+// 写入一批数据，没有正在进行的事务时定期保存同步的位置
+func (f *Follower) apply(msg *replicationMessage) error {
+	if err := f.db.applyReplicatedRecords(f.replayer, msg.Records); err != nil {
+		return err
+	}
+	f.pos, f.hasPos = msg.Pos, true
+	if !f.replayer.hasPending() && time.Since(f.lastSave) >= replicationSaveInterval {
+		return f.savePos()
+	}
+	return nil
+}
+
+// 使用快照替换本地的数据
+func (f *Follower) installSnapshot(snapshotDir string, pos LogPosition) error {
+	// 替换过程中失败时，重新连接之后需要重新同步快照
+	f.hasPos = false
+	if err := os.Remove(filepath.Join(f.options.DirPath, replicationPosFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := f.db.replaceDataFiles(snapshotDir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		return err
+	}
+
+	f.replayer = newLogReplayer(f.db)
+	f.pos, f.hasPos = pos, true
+	// 快照中的数据已经可以读取，同时更新安装的次数
+	f.mu.Lock()
+	f.stat.Snapshots++
+	f.mu.Unlock()
+	return f.savePos()
+}
+
+// 持久化数据文件之后保存同步的位置，保证重启之后不会遗漏数据
+// 保存的位置之后的数据可能已经写入，重启之后会按照顺序重复写入，结果是一致的
+func (f *Follower) savePos() error {
+	if !f.hasPos {
+		return nil
+	}
+	if err := f.db.Sync(); err != nil {
+		return err
+	}
+	fileName := filepath.Join(f.options.DirPath, replicationPosFileName)
+	content := fmt.Sprintf("%d %d %d", f.pos.Fid, f.pos.Offset, f.pos.MergeFileId)
+	if err := os.WriteFile(fileName+".tmp", []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(fileName+".tmp", fileName); err != nil {
+		return err
+	}
+	f.lastSave = time.Now()
+	return nil
+}
+
+// 旧版本保存的位置中没有 merge 的标识，按照没有 merge 过处理，位置所在的数据文件被 merge 重写过时会重新同步快照
+func loadReplicationPos(dirPath string) (LogPosition, bool, error) {
+	var pos LogPosition
+	content, err := os.ReadFile(filepath.Join(dirPath, replicationPosFileName))
+	if os.IsNotExist(err) {
+		return pos, false, nil
+	}
+	if err != nil {
+		return pos, false, err
+	}
+	n, err := fmt.Sscanf(string(content), "%d %d %d", &pos.Fid, &pos.Offset, &pos.MergeFileId)
+	if n < 2 || (err != nil && err != io.EOF) {
+		return pos, false, ErrDataDirectoryCorrupted
+	}
+	return pos, true, nil
+}
+
+// 接收快照的临时目录
+func (f *Follower) getSnapshotPath() string {
+	dir := path.Dir(path.Clean(f.options.DirPath))
+	base := path.Base(f.options.DirPath)
+	return filepath.Join(dir, base+replicationSnapshotDirName)
+}
+
+func appendSnapshotFile(dir string, name string, content []byte) error {
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return ErrReplicationProtocol
+	}
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// 将 primary 的数据追加写到数据文件中，并使用和启动时加载索引相同的方式更新内存索引
+func (db *DB) applyReplicatedRecords(replayer *logReplayer, records []*replicationRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, record := range records {
+		logRecord := &data.LogRecord{
+			Key:    record.Key,
+			Value:  record.Value,
+			Type:   record.Type,
+			Expire: record.Expire,
+		}
+		pos, err := db.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
+		if err := replayer.replay(logRecord, pos); err != nil {
+			return err
+		}
+	}
+	if replayer.seqNo > db.seqNo {
+		db.seqNo = replayer.seqNo
+	}
+	return nil
+}
+
+// 使用 dir 中的文件替换当前所有的数据文件，并重新加载索引
+// 仍然被快照或者 LogReader 引用的数据文件等到释放之后再关闭
+func (db *DB) replaceDataFiles(dir string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.isMerging {
+		return ErrMergeIsProgress
+	}
+
+	// 关闭当前的索引和数据文件
+	if err := db.index.Close(); err != nil {
+		return err
+	}
+	if db.activeFile != nil {
+		if err := db.retireDataFile(db.activeFile); err != nil {
+			return err
+		}
+	}
+	for _, dataFile := range db.olderFiles {
+		if err := db.retireDataFile(dataFile); err != nil {
+			return err
+		}
+	}
+
+	// 删除旧的文件，保留文件锁，然后移动新的文件
+	entries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == fileLockName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(db.options.DirPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(db.getMergePath()); err != nil {
+		return err
+	}
+	entries, err = os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(dir, entry.Name()), filepath.Join(db.options.DirPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	// 重置状态并重新加载
+	db.filesIds = nil
+	db.activeFile = nil
+	db.olderFiles = make(map[uint32]*data.DataFile)
+	db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites)
+	db.seqNo = nonTransactionSeqNo
+	db.seqNoFileExists = false
+	db.bytesWrite = 0
+	db.reclaimSize = 0
+	db.fileReclaimSize = make(map[uint32]int64)
+	return db.load()
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 )
 
 var db *bitcask.DB
@@ -98,16 +99,68 @@ func handleListKeys(writer http.ResponseWriter, request *http.Request) {
 	_ = json.NewEncoder(writer).Encode(result)
 }
 
-// func handleStat(writer http.ResponseWriter, request *http.Request) {
-// 	if request.Method != http.MethodGet {
-// 		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
-// 		return
-// 	}
+func handleIncrBy(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := request.URL.Query().Get("key")
+	delta, err := strconv.ParseInt(request.URL.Query().Get("delta"), 10, 64)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	value, err := db.IncrBy([]byte(key), delta)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		log.Printf("failed to incr kv in db: %v\n", err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(value)
+}
+
+func handleIncrByFloat(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := request.URL.Query().Get("key")
+	delta, err := strconv.ParseFloat(request.URL.Query().Get("delta"), 64)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	value, err := db.IncrByFloat([]byte(key), delta)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		log.Printf("failed to incr kv in db: %v\n", err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(value)
+}
+
+func handleStat(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-// 	stat := db.Stat()
-// 	writer.Header().Set("Content-Type", "application/json")
-// 	_ = json.NewEncoder(writer).Encode(stat)
-// }
+	stat, err := db.Stat()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(stat)
+}
 
 func main() {
 	// 注册处理方法
@@ -115,7 +168,9 @@ func main() {
 	http.HandleFunc("/bitcask/get", handleGet)
 	http.HandleFunc("/bitcask/delete", handleDelete)
 	http.HandleFunc("/bitcask/listkeys", handleListKeys)
-	// http.HandleFunc("/bitcask/stat", handleStat)
+	http.HandleFunc("/bitcask/stat", handleStat)
+	http.HandleFunc("/bitcask/incrby", handleIncrBy)
+	http.HandleFunc("/bitcask/incrbyfloat", handleIncrByFloat)
 
 	// 启动 HTTP 服务
 	_ = http.ListenAndServe("localhost:8080", nil)
@@ -57,7 +57,28 @@ func (art *AdaptiveRadixTree) Size() int {
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-	return newARTIterator(art.tree, reverse)
+	return newARTIterator(art.tree, nil, reverse)
+}
+
+func (art *AdaptiveRadixTree) PrefixIterator(prefix []byte, reverse bool) Iterator {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	return newARTIterator(art.tree, prefix, reverse)
+}
+
+// Snapshot 复制所有的 key 和位置索引到新的自适应基数树中
+func (art *AdaptiveRadixTree) Snapshot() (Indexer, error) {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	tree := goart.New()
+	art.tree.ForEach(func(node goart.Node) bool {
+		tree.Insert(node.Key(), node.Value())
+		return true
+	})
+	return &AdaptiveRadixTree{
+		tree: tree,
+		lock: new(sync.RWMutex),
+	}, nil
 }
 
 func (art *AdaptiveRadixTree) Close() error {
@@ -71,7 +92,29 @@ type artIterator struct {
 	values    []*Item // key+位置索引信息
 }
 
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
+func newARTIterator(tree goart.Tree, prefix []byte, reverse bool) *artIterator {
+	if len(prefix) != 0 {
+		// 只遍历前缀对应的子树，ForEachPrefix 会访问内部节点，只保存叶子节点
+		var values []*Item
+		tree.ForEachPrefix(prefix, func(node goart.Node) bool {
+			if node.Kind() == goart.Leaf {
+				values = append(values, &Item{
+					key: node.Key(),
+					pos: node.Value().(*data.LogRecordPos),
+				})
+			}
+			return true
+		})
+		if reverse {
+			reverseItems(values)
+		}
+		return &artIterator{
+			currIndex: 0,
+			reverse:   reverse,
+			values:    values,
+		}
+	}
+
 	var idx int
 	if reverse {
 		idx = tree.Size() - 1
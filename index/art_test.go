@@ -65,3 +65,20 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_Snapshot(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	art.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 24})
+
+	snap, err := art.Snapshot()
+	assert.Nil(t, err)
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 36})
+	art.Delete([]byte("key-2"))
+	art.Put([]byte("key-3"), &data.LogRecordPos{Fid: 2, Offset: 48})
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, int64(12), snap.Get([]byte("key-1")).Offset)
+	assert.NotNil(t, snap.Get([]byte("key-2")))
+	assert.Nil(t, snap.Get([]byte("key-3")))
+}
@@ -2,11 +2,14 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
 	"go.etcd.io/bbolt"
 	"path/filepath"
+	"time"
 )
 
-const bptreeIndexFileName = "bptree-index"
+// BPlusTreeIndexFileName B+ 树索引在数据目录中的文件名
+const BPlusTreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("bitcask-index")
 
@@ -20,7 +23,7 @@ type BPlusTree struct {
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPlusTreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
@@ -36,6 +39,37 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	return &BPlusTree{tree: bptree}
 }
 
+// ReadBPlusTreeIndex 以只读的方式打开数据目录中的 B+ 树索引文件，并将所有的位置索引复制到内存中的 BTree
+// 用于离线检查数据目录，不会修改索引文件，索引文件被其他进程打开时返回错误
+func ReadBPlusTreeIndex(dirPath string) (Indexer, error) {
+	opts := *bbolt.DefaultOptions
+	opts.ReadOnly = true
+	opts.Timeout = time.Second
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPlusTreeIndexFileName), 0644, &opts)
+	if err != nil {
+		return nil, err
+	}
+	defer bptree.Close()
+
+	bt := NewBTree()
+	err = bptree.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			key := make([]byte, len(k))
+			copy(key, k)
+			bt.Put(key, data.DecodeLogRecordPos(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bt, nil
+}
+
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) bool {
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
@@ -88,8 +122,66 @@ func (bpt *BPlusTree) Size() int {
 	return size
 }
 
+// Remap 在一个事务中遍历所有的位置索引，并替换为 fn 返回的位置索引
+// fn 返回原来的位置索引表示不需要修改，返回 nil 表示删除该 key
+func (bpt *BPlusTree) Remap(fn func(key []byte, pos *data.LogRecordPos) *data.LogRecordPos) error {
+	return bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+
+		// 遍历的过程中不能修改 bucket，先记录需要修改的 key
+		var keys [][]byte
+		var positions []*data.LogRecordPos
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			pos := data.DecodeLogRecordPos(v)
+			newPos := fn(k, pos)
+			if newPos == pos {
+				continue
+			}
+			key := make([]byte, len(k))
+			copy(key, k)
+			keys = append(keys, key)
+			positions = append(positions, newPos)
+		}
+
+		for i, key := range keys {
+			var err error
+			if positions[i] == nil {
+				err = bucket.Delete(key)
+			} else {
+				err = bucket.Put(key, data.EncodeLogRecordPos(positions[i]))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Snapshot 在一个读事务中将所有的位置索引复制到内存中的 BTree，读事务失败时返回错误
+// 长时间持有 bbolt 的读事务会阻塞写事务扩容文件，因此不直接使用读事务作为副本
+func (bpt *BPlusTree) Snapshot() (Indexer, error) {
+	bt := NewBTree()
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(indexBucketName).ForEach(func(k, v []byte) error {
+			key := make([]byte, len(k))
+			copy(key, k)
+			bt.Put(key, data.DecodeLogRecordPos(v))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return bt, nil
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+	return newBptreeIterator(bpt.tree, nil, reverse)
+}
+
+func (bpt *BPlusTree) PrefixIterator(prefix []byte, reverse bool) Iterator {
+	return newBptreeIterator(bpt.tree, prefix, reverse)
 }
 
 func (bpt *BPlusTree) Close() error {
@@ -101,11 +193,12 @@ type bptreeIterator struct {
 	tx        *bbolt.Tx
 	cursor    *bbolt.Cursor
 	reverse   bool
+	prefix    []byte
 	currKey   []byte
 	currValue []byte
 }
 
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
+func newBptreeIterator(tree *bbolt.DB, prefix []byte, reverse bool) *bptreeIterator {
 	tx, err := tree.Begin(false)
 	if err != nil {
 		panic("failed to begin a transaction")
@@ -114,16 +207,32 @@ func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
 		tx:      tx,
 		cursor:  tx.Bucket(indexBucketName).Cursor(),
 		reverse: reverse,
+		prefix:  prefix,
 	}
 	bpi.Rewind()
 	return bpi
 }
 
+// Rewind 有前缀时定位到前缀范围内的第一个（或最后一个）key
 func (bpi *bptreeIterator) Rewind() {
-	if bpi.reverse {
+	if !bpi.reverse {
+		if len(bpi.prefix) == 0 {
+			bpi.currKey, bpi.currValue = bpi.cursor.First()
+		} else {
+			bpi.currKey, bpi.currValue = bpi.cursor.Seek(bpi.prefix)
+		}
+		return
+	}
+	upperBound := prefixUpperBound(bpi.prefix)
+	if upperBound == nil {
+		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+		return
+	}
+	// 定位到第一个大于所有前缀 key 的位置，它的前一个 key 就是前缀范围内的最后一个 key
+	if k, _ := bpi.cursor.Seek(upperBound); k == nil {
 		bpi.currKey, bpi.currValue = bpi.cursor.Last()
 	} else {
-		bpi.currKey, bpi.currValue = bpi.cursor.First()
+		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
 	}
 }
 
@@ -140,7 +249,20 @@ func (bpi *bptreeIterator) Next() {
 }
 
 func (bpi *bptreeIterator) Valid() bool {
-	return len(bpi.currKey) != 0
+	return len(bpi.currKey) != 0 && bytes.HasPrefix(bpi.currKey, bpi.prefix)
+}
+
+// 返回大于所有以 prefix 开头的 key 的最小 key，prefix 为空或者全部是 0xff 时不存在，返回 nil
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			upperBound := make([]byte, i+1)
+			copy(upperBound, prefix)
+			upperBound[i]++
+			return upperBound
+		}
+	}
+	return nil
 }
 
 func (bpi *bptreeIterator) Key() []byte {
@@ -96,3 +96,79 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_Remap(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-remap")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	tree.Put([]byte("acc"), &data.LogRecordPos{Fid: 2, Offset: 30})
+
+	err := tree.Remap(func(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+		if pos.Fid != 1 {
+			return pos
+		}
+		if string(key) == "abc" {
+			return nil
+		}
+		return &data.LogRecordPos{Fid: 1, Offset: 0}
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, tree.Size())
+	assert.Equal(t, int64(0), tree.Get([]byte("aac")).Offset)
+	assert.Nil(t, tree.Get([]byte("abc")))
+	assert.Equal(t, int64(30), tree.Get([]byte("acc")).Offset)
+}
+
+func TestBPlusTree_Snapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-snapshot")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	snap, err := tree.Snapshot()
+	assert.Nil(t, err)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	tree.Delete([]byte("abc"))
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, int64(10), snap.Get([]byte("aac")).Offset)
+	assert.NotNil(t, snap.Get([]byte("abc")))
+
+	// 索引已经关闭时返回错误
+	assert.Nil(t, tree.Close())
+	_, err = tree.Snapshot()
+	assert.NotNil(t, err)
+}
+
+func TestReadBPlusTreeIndex(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-read")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 124, Offset: 1000})
+
+	// 索引文件正在被使用
+	_, err := ReadBPlusTreeIndex(path)
+	assert.NotNil(t, err)
+	assert.Nil(t, tree.Close())
+
+	index, err := ReadBPlusTreeIndex(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, index.Size())
+	assert.Equal(t, &data.LogRecordPos{Fid: 124, Offset: 1000}, index.Get([]byte("abc")))
+}
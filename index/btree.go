@@ -39,7 +39,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) bool {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -50,15 +52,24 @@ func (bt *BTree) Delete(key []byte) bool {
 	it := &Item{key: key}
 	bt.lock.Lock()
 	olItem := bt.tree.Delete(it)
-	if olItem == nil {
-		return false
-	}
 	bt.lock.Unlock()
-	return true
+	return olItem != nil
 }
 
 
+// Snapshot 基于 btree 的写时复制，创建副本的开销很小
+func (bt *BTree) Snapshot() (Indexer, error) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}, nil
+}
+
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
@@ -68,7 +79,13 @@ func (bt *BTree) Iterator(reverse bool) Iterator {
 	}
 	bt.lock.RLock()
 	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	return newBTreeIterator(bt.tree, nil, reverse)
+}
+
+func (bt *BTree) PrefixIterator(prefix []byte, reverse bool) Iterator {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return newBTreeIterator(bt.tree, prefix, reverse)
 }
 
 // BTree 索引迭代器
@@ -78,19 +95,30 @@ type btreeIterator struct {
 	values    []*Item // key+位置索引信息
 }
 
-func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
+func newBTreeIterator(tree *btree.BTree, prefix []byte, reverse bool) *btreeIterator {
+	var values []*Item
+	if len(prefix) == 0 {
+		values = make([]*Item, 0, tree.Len())
+	}
 
-	// 将所有的数据存放到数组中
+	// 将数据存放到数组中，key 是有序的，遇到第一个不匹配前缀的 key 时停止
 	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
+		item := it.(*Item)
+		if !bytes.HasPrefix(item.key, prefix) {
+			return false
+		}
+		values = append(values, item)
 		return true
 	}
-	if reverse {
+	switch {
+	case len(prefix) != 0:
+		tree.AscendGreaterOrEqual(&Item{key: prefix}, saveValues)
+		if reverse {
+			reverseItems(values)
+		}
+	case reverse:
 		tree.Descend(saveValues)
-	} else {
+	default:
 		tree.Ascend(saveValues)
 	}
 
@@ -91,3 +91,20 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Snapshot(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("bb"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	snap, err := bt.Snapshot()
+	assert.Nil(t, err)
+	bt.Put([]byte("aa"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt.Delete([]byte("bb"))
+	bt.Put([]byte("cc"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, int64(10), snap.Get([]byte("aa")).Offset)
+	assert.NotNil(t, snap.Get([]byte("bb")))
+	assert.Nil(t, snap.Get([]byte("cc")))
+}
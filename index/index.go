@@ -23,6 +23,12 @@ type Indexer interface {
 	// Iterator 迭代器
 	Iterator(reverse bool) Iterator
 
+	// PrefixIterator 只遍历以 prefix 开头的 key 的迭代器，prefix 为空时和 Iterator 相同
+	PrefixIterator(prefix []byte, reverse bool) Iterator
+
+	// Snapshot 返回索引当前状态的副本，之后对原索引的修改不会影响副本，创建副本失败时返回错误
+	Snapshot() (Indexer, error)
+
 	// Close 关闭索引
 	Close() error
 }
@@ -62,6 +68,13 @@ func (ai *Item) Less(bi btree.Item) bool {
 }
 
 
+// 将 items 原地反转，用于把顺序遍历的结果转换为反向遍历的结果
+func reverseItems(items []*Item) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
+
 // Iterator 通用索引迭代器
 type Iterator interface {
 	// Rewind 重新回到迭代器的起点，即第一个数据
@@ -0,0 +1,58 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexer_PrefixIterator(t *testing.T) {
+	path, _ := os.MkdirTemp("", "index-prefix-iter")
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	indexers := map[string]Indexer{
+		"btree":  NewBTree(),
+		"art":    NewART(),
+		"bptree": NewBPlusTree(path, false),
+	}
+
+	keys := []string{"a", "ab", "abc", "abd", "ac", "b", "ab\xff", "ab\xff\x01", "abe"}
+	for name, indexer := range indexers {
+		for i, key := range keys {
+			indexer.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		collect := func(prefix string, reverse bool) []string {
+			iter := indexer.PrefixIterator([]byte(prefix), reverse)
+			defer iter.Close()
+			var result []string
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				assert.NotNil(t, iter.Value(), name)
+				result = append(result, string(iter.Key()))
+			}
+			return result
+		}
+		assert.Equal(t, []string{"ab", "abc", "abd", "abe", "ab\xff", "ab\xff\x01"}, collect("ab", false), name)
+		assert.Equal(t, []string{"ab\xff\x01", "ab\xff", "abe", "abd", "abc", "ab"}, collect("ab", true), name)
+		assert.Equal(t, []string{"ab\xff", "ab\xff\x01"}, collect("ab\xff", false), name)
+		assert.Equal(t, []string{"ab\xff\x01", "ab\xff"}, collect("ab\xff", true), name)
+		assert.Nil(t, collect("abz", false), name)
+		assert.Nil(t, collect("c", true), name)
+		assert.Equal(t, len(keys), len(collect("", false)), name)
+		assert.Equal(t, len(keys), len(collect("", true)), name)
+
+		// Seek 只在前缀范围内定位
+		iter := indexer.PrefixIterator([]byte("ab"), false)
+		iter.Seek([]byte("abd"))
+		assert.True(t, iter.Valid(), name)
+		assert.Equal(t, "abd", string(iter.Key()), name)
+		iter.Seek([]byte("b"))
+		assert.False(t, iter.Valid(), name)
+		iter.Close()
+
+		assert.Nil(t, indexer.Close())
+	}
+}
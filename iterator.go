@@ -2,19 +2,20 @@ package bitcask_go
 
 import (
 	"bitcask-go/index"
-	"bytes"
 )
 
 // Iterator 迭代器
 type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db 	  *DB            // 数据库实例
+	snapshot *Snapshot      // 快照，不为空时从快照中读取数据
 	options IteratorOptions // 迭代器配置项
 }
 
 // NewIterator 初始化迭代器
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	// 有前缀时索引迭代器只遍历前缀范围内的 key，不需要遍历整个索引
+	indexIter := db.index.PrefixIterator(opts.Prefix, opts.Reverse)
 	return &Iterator{
 		db:        db,
 		indexIter: indexIter,
@@ -53,6 +54,9 @@ func (it *Iterator) Key() []byte {
 // Value 当前遍历位置的 Value 数据
 func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
+	if it.snapshot != nil {
+		return it.snapshot.getValueByPosition(logRecordPos)
+	}
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
 	return it.db.getValueByPosition(logRecordPos)
@@ -63,15 +67,10 @@ func (it *Iterator) Close() {
 	it.indexIter.Close()
 }
 
+// 跳过已经过期的 key，前缀不匹配的 key 已经被索引迭代器过滤
 func (it *Iterator) skipToNext() {
-	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
-
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
-		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		if !it.indexIter.Value().IsExpired() {
 			break
 		}
 	}
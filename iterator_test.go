@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestDB_NewIterator(t *testing.T) {
@@ -95,3 +96,45 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 		assert.NotNil(t, iter3.Key())
 	}
 }
+
+func TestDB_Iterator_Prefix(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-4")
+	opts.DirPath = dir
+	opts.IndexType = ART
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for _, key := range []string{"a", "ab", "abc", "abd", "abe", "b"} {
+		assert.Nil(t, db.Put([]byte(key), utils.RandomValue(10)))
+	}
+	// 过期的 key 在前缀范围内也会被跳过
+	assert.Nil(t, db.PutWithTTL([]byte("abd"), utils.RandomValue(10), time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	collect := func(iter *Iterator) []string {
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	iterOpts := DefaultIteratorOptions
+	iterOpts.Prefix = []byte("ab")
+	iter1 := db.NewIterator(iterOpts)
+	defer iter1.Close()
+	iter1.Rewind()
+	assert.Equal(t, []string{"ab", "abc", "abe"}, collect(iter1))
+	iter1.Seek([]byte("abd"))
+	assert.Equal(t, []string{"abe"}, collect(iter1))
+
+	iterOpts.Reverse = true
+	iter2 := db.NewIterator(iterOpts)
+	defer iter2.Close()
+	iter2.Rewind()
+	assert.Equal(t, []string{"abe", "abc", "ab"}, collect(iter2))
+	iter2.Seek([]byte("abd"))
+	assert.Equal(t, []string{"abc", "ab"}, collect(iter2))
+}
@@ -0,0 +1,303 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"sort"
+	"sync"
+)
+
+// LogEntry 从数据文件中读取到的一条已经提交的数据
+type LogEntry struct {
+	Key    []byte
+	Value  []byte
+	Type   data.LogRecordType // LogRecordNormal 或者 LogRecordDeleted
+	SeqNo  uint64             // 事务序列号，非事务写入为 0
+	Expire int64              // 过期时间，0 表示永不过期
+	Pos    data.LogRecordPos  // 数据在数据文件中的位置
+}
+
+// LogPosition LogReader 读取到的位置
+// merge 重写数据文件时会重复使用文件 id，因此同时记录创建 LogReader 时最近一次 merge 的标识，
+// 之后的 merge 重写了位置所在的数据文件时，同一个文件 id 中的数据已经不同，这个位置不再有效
+type LogPosition struct {
+	data.LogRecordPos
+	MergeFileId uint32 // 最近一次 merge 时没有参与 merge 的最小文件 id，没有 merge 过为 0
+}
+
+// LogReader 按照文件 id 和 offset 的顺序读取数据文件中的数据，用于将数据变更同步到其他系统
+// LogReader 引用从起始位置开始的所有数据文件，包括之后新建的数据文件，在 Close 之前这些文件不会被 merge 关闭，
+// 因此 merge 不会影响正在读取的数据，读取完毕的数据文件会立即释放
+type LogReader struct {
+	mu          *sync.Mutex
+	db          *DB
+	files       []*data.DataFile // 待读取的数据文件，按照文件 id 排序，由 db.mu 保护
+	fileIndex   int              // 当前读取的文件在 files 中的下标
+	offset      int64            // 下一条数据在当前文件中的 offset
+	pending     []*LogEntry      // 还没有读取到完成标识的事务数据
+	ready       []*LogEntry      // 已经提交、等待返回的事务数据
+	readyPos    data.LogRecordPos
+	from        data.LogRecordPos
+	mergeFileId uint32 // 创建时最近一次 merge 的标识，见 LogPosition
+	raw         bool   // 返回所有的数据，包括事务完成的标识和没有提交的事务数据
+	closed      bool
+}
+
+// ReadLog 从 from 指定的位置开始读取数据，只返回非事务写入和已经提交的事务中的数据，零值表示从头开始读取
+// from 所在的数据文件已经被删除时，从下一个数据文件的开头开始读取
+// 读取进度可以通过 LogReader.Pos 保存，重启之后从保存的位置继续读取，可能会重复读取到少量数据
+// 如果 from 所在的数据文件在这期间被 merge 重写，from 不再指向原来的数据，返回 ErrInvalidLogPosition
+func (db *DB) ReadLog(from LogPosition) (*LogReader, error) {
+	return db.newLogReader(&from, false)
+}
+
+// 创建 LogReader，from 为空时从当前最新的位置开始读取
+// raw 模式下原样返回所有的数据，from 所在的数据文件不存在时返回 ErrInvalidLogPosition
+func (db *DB) newLogReader(from *LogPosition, raw bool) (*LogReader, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if from == nil {
+		from = &LogPosition{MergeFileId: db.lastMergeFileId}
+		if db.activeFile != nil {
+			from.Fid, from.Offset = db.activeFile.FileId, db.activeFile.WriteOff
+		}
+	}
+	// 之后的 merge 重写了 from 之前的数据文件，文件 id 被重复使用，from 中的 offset 已经没有意义
+	fromStart := from.Fid == 0 && from.Offset == 0
+	if !fromStart && from.MergeFileId != db.lastMergeFileId && from.Fid < db.lastMergeFileId {
+		return nil, ErrInvalidLogPosition
+	}
+
+	files := make([]*data.DataFile, 0, len(db.olderFiles)+1)
+	for fid, dataFile := range db.olderFiles {
+		if fid >= from.Fid {
+			files = append(files, dataFile)
+		}
+	}
+	if db.activeFile != nil && db.activeFile.FileId >= from.Fid {
+		files = append(files, db.activeFile)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileId < files[j].FileId
+	})
+
+	reader := &LogReader{mu: new(sync.Mutex), db: db, files: files, from: from.LogRecordPos, mergeFileId: db.lastMergeFileId, raw: raw}
+	if len(files) > 0 && files[0].FileId == from.Fid {
+		if err := checkLogPosition(files[0], from.Offset); err != nil {
+			return nil, err
+		}
+		reader.offset = from.Offset
+	} else if raw && (len(files) > 0 || from.Offset > 0) {
+		return nil, ErrInvalidLogPosition
+	}
+
+	for _, dataFile := range files {
+		db.fileRefs[dataFile]++
+	}
+	db.logReaders[reader] = struct{}{}
+	return reader, nil
+}
+
+// 校验 offset 是否是一条数据的开始，0 表示文件的开头
+func checkLogPosition(dataFile *data.DataFile, offset int64) error {
+	if offset == 0 || offset == dataFile.HeaderSize() {
+		return nil
+	}
+	if offset < dataFile.HeaderSize() {
+		return ErrInvalidLogPosition
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if offset > size {
+		return ErrInvalidLogPosition
+	}
+	if offset == size {
+		return nil
+	}
+	if _, _, err := dataFile.ReadLogRecord(offset); err != nil {
+		if err == data.ErrInvalidCRC {
+			return ErrInvalidLogPosition
+		}
+		return err
+	}
+	return nil
+}
+
+// Next 返回下一条数据，已经读取到最新的数据时返回 io.EOF，之后有新的写入时可以继续调用
+func (r *LogReader) Next() (*LogEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrLogReaderClosed
+	}
+
+	r.db.mu.RLock()
+	entry, finished, err := r.next()
+	r.db.mu.RUnlock()
+
+	// 释放已经读取完毕的数据文件
+	if len(finished) > 0 {
+		r.db.mu.Lock()
+		for _, dataFile := range finished {
+			if releaseErr := r.db.releaseDataFile(dataFile); releaseErr != nil && err == nil {
+				err = releaseErr
+			}
+		}
+		r.db.mu.Unlock()
+	}
+	return entry, err
+}
+
+// 读取下一条数据，同时返回读取完毕的数据文件。该方法必须持有读锁
+func (r *LogReader) next() (*LogEntry, []*data.DataFile, error) {
+	var finished []*data.DataFile
+	for {
+		if len(r.ready) > 0 {
+			entry := r.ready[0]
+			r.ready = r.ready[1:]
+			return entry, finished, nil
+		}
+		if r.fileIndex >= len(r.files) {
+			return nil, finished, io.EOF
+		}
+
+		dataFile := r.files[r.fileIndex]
+		// 跳过文件头
+		if r.offset < dataFile.HeaderSize() {
+			r.offset = dataFile.HeaderSize()
+		}
+		logRecord, size, err := dataFile.ReadLogRecord(r.offset)
+		if err == io.EOF {
+			// 已经有更新的数据文件，说明当前文件不会再写入
+			if r.fileIndex == len(r.files)-1 {
+				return nil, finished, io.EOF
+			}
+			finished = append(finished, dataFile)
+			r.files[r.fileIndex] = nil
+			r.fileIndex++
+			r.offset = 0
+			continue
+		}
+		if err != nil {
+			return nil, finished, err
+		}
+
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		entry := &LogEntry{
+			Key:    realKey,
+			Value:  logRecord.Value,
+			Type:   logRecord.Type,
+			SeqNo:  seqNo,
+			Expire: logRecord.Expire,
+			Pos: data.LogRecordPos{
+				Fid:    dataFile.FileId,
+				Offset: r.offset,
+				Expire: logRecord.Expire,
+				Size:   uint32(size),
+			},
+		}
+		r.offset += size
+		if r.raw {
+			return entry, finished, nil
+		}
+
+		// 同一个事务的数据是连续写入的，读取到其他数据说明之前的事务没有提交完成
+		if len(r.pending) > 0 && r.pending[0].SeqNo != seqNo {
+			r.pending = nil
+		}
+		if seqNo == nonTransactionSeqNo {
+			return entry, finished, nil
+		}
+		if logRecord.Type == data.LogRecordTxnFinished {
+			if len(r.pending) > 0 {
+				r.ready, r.readyPos = r.pending, r.pending[0].Pos
+				r.pending = nil
+			}
+			continue
+		}
+		r.pending = append(r.pending, entry)
+	}
+}
+
+// Pos 返回可以恢复读取的位置，从该位置重新读取不会遗漏数据
+// 如果正在读取一个事务的数据，返回事务的第一条数据的位置，恢复之后会重复读取该事务中已经返回的数据
+func (r *LogReader) Pos() LogPosition {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pos := LogPosition{LogRecordPos: r.from, MergeFileId: r.mergeFileId}
+	if len(r.ready) > 0 {
+		pos.LogRecordPos = r.readyPos
+		return pos
+	}
+	if len(r.pending) > 0 {
+		pos.LogRecordPos = r.pending[0].Pos
+		return pos
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if r.fileIndex < len(r.files) {
+		pos.LogRecordPos = data.LogRecordPos{Fid: r.files[r.fileIndex].FileId, Offset: r.offset}
+	}
+	// 还没有可以读取的数据文件时返回起始位置
+	return pos
+}
+
+// 还没有读取的数据量，字节为单位
+func (r *LogReader) remaining() (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrLogReaderClosed
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var size int64
+	for i, dataFile := range r.files[r.fileIndex:] {
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		// 文件头不计算在内
+		size += fileSize - dataFile.HeaderSize()
+		if i == 0 && r.offset > dataFile.HeaderSize() {
+			size -= r.offset - dataFile.HeaderSize()
+		}
+	}
+	return size, nil
+}
+
+// Close 关闭 LogReader，释放引用的数据文件
+func (r *LogReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	delete(r.db.logReaders, r)
+	var err error
+	for _, dataFile := range r.files[r.fileIndex:] {
+		if releaseErr := r.db.releaseDataFile(dataFile); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}
+	r.files = nil
+	r.pending, r.ready = nil, nil
+	return err
+}
+
+// 新建的数据文件加入到所有 LogReader 的待读取文件中。该方法必须持有互斥锁
+func (db *DB) addFileToLogReaders(dataFile *data.DataFile) {
+	for r := range db.logReaders {
+		r.files = append(r.files, dataFile)
+		db.fileRefs[dataFile]++
+	}
+}
@@ -0,0 +1,201 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+// 读取所有已经写入的数据，直到 io.EOF
+func readAllLog(t *testing.T, reader *LogReader) []*LogEntry {
+	var entries []*LogEntry
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		assert.Nil(t, err)
+		entries = append(entries, entry)
+	}
+}
+
+func TestDB_ReadLog(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-log")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Delete([]byte("k1")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, wb.Commit())
+
+	reader, err := db.ReadLog(LogPosition{})
+	assert.Nil(t, err)
+	defer reader.Close()
+
+	entries := readAllLog(t, reader)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, []byte("k1"), entries[0].Key)
+	assert.Equal(t, []byte("v1"), entries[0].Value)
+	assert.Equal(t, data.LogRecordNormal, entries[0].Type)
+	assert.Equal(t, nonTransactionSeqNo, entries[0].SeqNo)
+	assert.Equal(t, data.LogRecordDeleted, entries[1].Type)
+	assert.Equal(t, []byte("k2"), entries[2].Key)
+	assert.True(t, entries[2].SeqNo > nonTransactionSeqNo)
+
+	// 读取到最新的数据之后，有新的写入可以继续读取
+	assert.Nil(t, db.Put([]byte("k3"), []byte("v3")))
+	entry, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("k3"), entry.Key)
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	assert.Nil(t, reader.Close())
+	_, err = reader.Next()
+	assert.Equal(t, ErrLogReaderClosed, err)
+}
+
+// 没有提交完成的事务数据不会返回
+func TestDB_ReadLog_UncommittedTxn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-log-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 模拟写入一半时崩溃的事务
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("lost"), 100),
+		Value: []byte("v"),
+		Type:  data.LogRecordNormal,
+	})
+	assert.Nil(t, err)
+
+	reader, err := db.ReadLog(LogPosition{})
+	assert.Nil(t, err)
+	defer reader.Close()
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+	// 事务还没有结束，恢复位置停在事务的第一条数据
+	assert.Equal(t, int64(data.FileHeaderSize), reader.Pos().Offset)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	entries := readAllLog(t, reader)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, []byte("k1"), entries[0].Key)
+	assert.Equal(t, entries[0].Pos.Offset+int64(entries[0].Pos.Size), reader.Pos().Offset)
+}
+
+// 重启之后从保存的位置继续读取
+func TestDB_ReadLog_Resume(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-log-resume")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	reader, err := db.ReadLog(LogPosition{})
+	assert.Nil(t, err)
+	for i := 0; i < 60; i++ {
+		entry, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), entry.Key)
+	}
+	pos := reader.Pos()
+	assert.Nil(t, reader.Close())
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	reader2, err := db2.ReadLog(pos)
+	assert.Nil(t, err)
+	defer reader2.Close()
+	entries := readAllLog(t, reader2)
+	assert.Equal(t, 40, len(entries))
+	for i, entry := range entries {
+		assert.Equal(t, utils.GetTestKey(60+i), entry.Key)
+	}
+
+	// 不是一条数据开始的位置
+	_, err = db2.ReadLog(LogPosition{LogRecordPos: data.LogRecordPos{Fid: pos.Fid, Offset: 1 << 20}})
+	assert.Equal(t, ErrInvalidLogPosition, err)
+}
+
+// merge 不影响正在读取的数据
+func TestDB_ReadLog_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-log-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%10), utils.RandomValue(128)))
+	}
+	reader, err := db.ReadLog(LogPosition{})
+	assert.Nil(t, err)
+	defer reader.Close()
+	for i := 0; i < 30; i++ {
+		_, err := reader.Next()
+		assert.Nil(t, err)
+	}
+
+	// merge 之后大部分数据文件被删除，之后的写入在新的数据文件中
+	assert.Nil(t, db.Merge())
+	for i := 100; i < 110; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	entries := readAllLog(t, reader)
+	assert.Equal(t, 80, len(entries))
+	for i, entry := range entries[:70] {
+		assert.Equal(t, utils.GetTestKey((30+i)%10), entry.Key)
+	}
+	for i, entry := range entries[70:] {
+		assert.Equal(t, utils.GetTestKey(100+i), entry.Key)
+	}
+
+	// merge 之前保存的位置所在的数据文件已经被重写
+	_, err = db.ReadLog(LogPosition{LogRecordPos: data.LogRecordPos{Fid: 2, Offset: 100}})
+	assert.Equal(t, ErrInvalidLogPosition, err)
+
+	// merge 之后新写入的数据不受影响，可以从读取完的位置继续读取
+	assert.Nil(t, db.Put(utils.GetTestKey(110), utils.RandomValue(128)))
+	reader2, err := db.ReadLog(reader.Pos())
+	assert.Nil(t, err)
+	defer reader2.Close()
+	entries = readAllLog(t, reader2)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, utils.GetTestKey(110), entries[0].Key)
+
+	// 重启之后仍然能够识别 merge 之前的位置
+	pos := reader2.Pos()
+	assert.Nil(t, reader.Close())
+	assert.Nil(t, reader2.Close())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.ReadLog(LogPosition{LogRecordPos: data.LogRecordPos{Fid: 2, Offset: 100}})
+	assert.Equal(t, ErrInvalidLogPosition, err)
+	reader3, err := db.ReadLog(pos)
+	assert.Nil(t, err)
+	defer reader3.Close()
+	assert.Equal(t, 0, len(readAllLog(t, reader3)))
+}
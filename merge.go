@@ -2,20 +2,32 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"encoding/binary"
 	"io"
+	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 )
 
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+	mergeFilesKey    = "merge.files"
+
+	// 旧的数据文件删除完成之后在 merge 目录中创建的标识文件
+	mergeFilesDeletedName = "merge-deleted"
 )
 
 // Merge 清理无效数据，生成 Hint 文件
+// 只有无效数据占比达到 DataFileMergeRatio 的数据文件才会被重写，其余旧的数据文件保持不变
+// merge 完成后直接替换当前实例中的数据文件和索引，不需要重新打开数据库
 func (db *DB) Merge() error {
 	// 如果数据库为空，则直接返回
 	if db.activeFile == nil {
@@ -27,6 +39,30 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return ErrMergeIsProgress
 	}
+
+	// 挑选出需要 merge 的文件
+	mergeFiles, mergeSize, err := db.pickMergeFiles()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if len(mergeFiles) == 0 {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+
+	// 查看剩余的空间容量是否可以容纳 merge 之后的数据量
+	// merge 目录和数据目录在同一个父目录下，重写的数据先写到 merge 目录中
+	availableDiskSize, err := utils.AvailableDiskSize(filepath.Dir(db.getMergePath()))
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if uint64(mergeSize) >= availableDiskSize {
+		db.mu.Unlock()
+		return ErrNoEnoughSpaceForMerge
+	}
+
 	db.isMerging = true
 	defer func() {
 		db.isMerging = false
@@ -42,16 +78,10 @@ func (db *DB) Merge() error {
 	// 打开新的活跃文件
 	if err := db.setActiveDataFile(); err != nil {
 		db.mu.Unlock()
-		return nil
+		return err
 	}
 	// 记录最近没有参与 merge 的文件 id
 	nonMergeFileId := db.activeFile.FileId
-
-	// 取出所有需要 merge 的文件
-	var mergeFiles []*data.DataFile
-	for _, file := range db.olderFiles {
-		mergeFiles = append(mergeFiles, file)
-	}
 	db.mu.Unlock()
 
 	//	待 merge 的文件从小到大进行排序，依次 merge
@@ -59,93 +89,353 @@ func (db *DB) Merge() error {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
 
+	// 将有效数据重写到 merge 目录中
+	result, err := db.rewriteMergeFiles(mergeFiles, nonMergeFileId)
+	if err != nil {
+		return err
+	}
+
+	// 用 merge 的结果替换当前实例中的数据文件和索引
+	return db.applyMergeResult(result)
+}
+
+// merge 的结果
+type mergeResult struct {
+	nonMergeFileId uint32       // 最近没有参与 merge 的文件 id
+	mergeFileIds   []uint32     // 参与 merge 的文件 id
+	positions      []*mergedPos // 被重写的数据新的位置索引
+	expiredKeys    [][]byte     // merge 时已经过期被丢弃的 key
+}
+
+type mergedPos struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+// 将待 merge 文件中的有效数据重写到 merge 目录中，并生成 Hint 文件和标识 merge 完成的文件
+func (db *DB) rewriteMergeFiles(mergeFiles []*data.DataFile, nonMergeFileId uint32) (*mergeResult, error) {
+	result := &mergeResult{
+		nonMergeFileId: nonMergeFileId,
+		mergeFileIds:   make([]uint32, len(mergeFiles)),
+	}
+	isMergeFile := make(map[uint32]bool, len(mergeFiles))
+	for i, file := range mergeFiles {
+		result.mergeFileIds[i] = file.FileId
+		isMergeFile[file.FileId] = true
+	}
+
 	mergePath := db.getMergePath()
 	// 如果目录存在，说明发生过 merge，将其删除掉
 	if _, err := os.Stat(mergePath); err == nil {
 		if err := os.RemoveAll(mergePath); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	// 新建一个 merge path 的目录
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
-		return err
+		return nil, err
 	}
-	// 打开一个新的临时 bitcask 实例
-	mergeOptions := db.options
-	mergeOptions.DirPath = mergePath
-	mergeOptions.SyncWrites = false
-	mergeDB, err := Open(mergeOptions)
-	if err != nil {
-		return err
+	// 重写之后的数据文件复用参与 merge 的文件 id
+	writer := &mergeWriter{
+		dirPath:     mergePath,
+		fileIds:     result.mergeFileIds,
+		maxFileSize: db.options.DataFileSize,
+		codec:       db.codec,
+		cipher:      db.cipher,
 	}
+	defer writer.close()
 
 	// 打开 hint 文件存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	hintFile.Cipher = db.cipher
+	defer hintFile.Close()
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		offset := dataFile.HeaderSize()
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
-				return err
+				return nil, err
 			}
 			// 解析拿到实际的 key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
-			// 和内存中的索引位置进行比较，如果有效则重写
+			// 和内存中的索引位置进行比较，如果有效且未过期则重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
 				logRecordPos.Offset == offset {
+				if logRecordPos.IsExpired() {
+					result.expiredKeys = append(result.expiredKeys, realKey)
+					offset += size
+					continue
+				}
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				pos, err := mergeDB.appendLogRecord(logRecord)
+				pos, err := writer.write(logRecord)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				// 将当前位置索引写到 Hint 文件当中
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
-					return err
+					return nil, err
 				}
+				result.positions = append(result.positions, &mergedPos{key: realKey, pos: pos})
 			}
 			// 增加 offset
 			offset += size
 		}
 	}
 
+	// 没有参与 merge 的旧数据文件中的有效数据保持原来的位置，同样写到 Hint 文件当中
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.Fid >= nonMergeFileId || isMergeFile[pos.Fid] || pos.IsExpired() {
+			continue
+		}
+		if err := hintFile.WriteHintRecord(iterator.Key(), pos); err != nil {
+			iterator.Close()
+			return nil, err
+		}
+	}
+	iterator.Close()
+
 	// sync 保证持久化
 	if err := hintFile.Sync(); err != nil {
-		return err
+		return nil, err
 	}
-	if err := mergeDB.Sync(); err != nil {
-		return err
+	if err := writer.sync(); err != nil {
+		return nil, err
 	}
 
 	// 写标识 merge 完成的文件
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer mergeFinishedFile.Close()
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
-		return err
+	mergeFilesRecord := &data.LogRecord{
+		Key:   []byte(mergeFilesKey),
+		Value: encodeFileIds(result.mergeFileIds),
+	}
+	for _, record := range []*data.LogRecord{mergeFinRecord, mergeFilesRecord} {
+		encRecord, _ := data.EncodeLogRecordWith(record, nil, db.cipher)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return nil, err
+		}
 	}
 	if err := mergeFinishedFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// 将 merge 的结果应用到当前实例中：替换数据文件，更新内存索引，并删除无用的旧数据文件
+// 如果中途失败，下次启动时会在 loadMergeFiles 中继续完成
+func (db *DB) applyMergeResult(result *mergeResult) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	isMergeFile := make(map[uint32]bool, len(result.mergeFileIds))
+	// 关闭参与 merge 的旧数据文件
+	for _, fid := range result.mergeFileIds {
+		isMergeFile[fid] = true
+		if dataFile := db.olderFiles[fid]; dataFile != nil {
+			if err := db.retireDataFile(dataFile); err != nil {
+				return err
+			}
+			delete(db.olderFiles, fid)
+		}
+		db.reclaimSize -= db.fileReclaimSize[fid]
+		delete(db.fileReclaimSize, fid)
+	}
+
+	// 将 merge 目录中的文件移动到数据目录中，覆盖或删除旧的数据文件
+	if err := db.loadMergeFiles(); err != nil {
 		return err
 	}
+	db.lastMergeFileId = result.nonMergeFileId
+
+	// 打开重写之后的数据文件
+	for _, fid := range result.mergeFileIds {
+		if _, err := os.Stat(data.GetDataFileName(db.options.DirPath, fid)); os.IsNotExist(err) {
+			continue
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Cipher = db.cipher
+		db.olderFiles[fid] = dataFile
+	}
 
+	// B+ 树索引已经在 loadMergeFiles 中更新，没有生效的重写数据是无效的
+	if db.options.IndexType == BPlusTree {
+		for _, item := range result.positions {
+			pos := db.index.Get(item.key)
+			if pos == nil || pos.Fid != item.pos.Fid || pos.Offset != item.pos.Offset {
+				db.addReclaimSize(item.pos)
+			}
+		}
+		return nil
+	}
+
+	// 更新内存索引，只有仍然指向参与 merge 的文件的 key 才需要更新
+	// 其余的 key 在 merge 期间已经被重新写入或者删除，重写的数据随即失效
+	for _, item := range result.positions {
+		if oldPos := db.index.Get(item.key); oldPos != nil && isMergeFile[oldPos.Fid] {
+			if ok := db.index.Put(item.key, item.pos); !ok {
+				return ErrIndexUpdateFailed
+			}
+		} else {
+			db.addReclaimSize(item.pos)
+		}
+	}
+	for _, key := range result.expiredKeys {
+		if oldPos := db.index.Get(key); oldPos != nil && isMergeFile[oldPos.Fid] {
+			db.index.Delete(key)
+		}
+	}
 	return nil
 }
 
+// 挑选无效数据占比达到阈值的数据文件，同时返回这些文件中有效数据的大小。该方法必须持有互斥锁
+func (db *DB) pickMergeFiles() ([]*data.DataFile, int64, error) {
+	files := []*data.DataFile{db.activeFile}
+	for _, file := range db.olderFiles {
+		files = append(files, file)
+	}
+
+	var mergeFiles []*data.DataFile
+	var mergeSize int64
+	for _, file := range files {
+		size, err := file.IoManager.Size()
+		if err != nil {
+			return nil, 0, err
+		}
+		// 旧版本、使用其他压缩方式压缩或者使用旧的密钥加密的文件，不管无效数据的占比都需要重写
+		rewrite, err := db.needRewrite(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		if size <= file.HeaderSize() && !rewrite {
+			continue
+		}
+		reclaimSize := db.fileReclaimSize[file.FileId]
+		if rewrite || float32(reclaimSize)/float32(size) >= db.options.DataFileMergeRatio {
+			mergeFiles = append(mergeFiles, file)
+			mergeSize += size - reclaimSize
+		}
+	}
+	return mergeFiles, mergeSize, nil
+}
+
+// 数据文件是否需要使用当前的格式重写：没有文件头的旧版本数据文件，使用其他压缩方式压缩的数据文件，或者没有使用当前的密钥加密的数据文件
+// 同一个数据文件中的数据都使用相同的密钥加密和相同的压缩方式压缩，因此只需要读取第一条数据
+// 压缩之后没有变小的 value 不会压缩，第一条数据没有压缩时无法判断文件的压缩方式，不需要重写
+func (db *DB) needRewrite(dataFile *data.DataFile) (bool, error) {
+	if dataFile.Header == nil {
+		return true, nil
+	}
+	codecId, err := dataFile.ReadCodecId(dataFile.HeaderSize())
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	currentCodecId := data.CodecNone
+	if db.codec != nil {
+		currentCodecId = db.codec.ID()
+	}
+	if codecId != data.CodecNone && codecId != currentCodecId {
+		return true, nil
+	}
+
+	if db.cipher == nil {
+		return false, nil
+	}
+	keyId, encrypted, err := dataFile.ReadKeyId(dataFile.HeaderSize())
+	if err != nil {
+		return false, err
+	}
+	return !encrypted || keyId != db.cipher.KeyId(), nil
+}
+
+// 后台协程，定期检查无效数据的占比，达到阈值时自动进行 merge
+func (db *DB) autoMerge() {
+	defer close(db.autoMergeDone)
+	ticker := time.NewTicker(db.options.AutoMergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !db.needAutoMerge() {
+				continue
+			}
+			err := db.Merge()
+			if err != nil && err != ErrMergeIsProgress && err != ErrMergeRatioUnreached {
+				log.Printf("failed to auto merge: %v\n", err)
+			}
+		case <-db.autoMergeStop:
+			return
+		}
+	}
+}
+
+// 判断是否需要自动 merge
+func (db *DB) needAutoMerge() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.activeFile == nil || db.isMerging {
+		return false
+	}
+	// 已经有 merge 完成的数据等待加载，不再重复 merge
+	mergeFinFileName := filepath.Join(db.getMergePath(), data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); err == nil {
+		return false
+	}
+	if db.reclaimSize < db.options.AutoMergeMinReclaimSize {
+		return false
+	}
+
+	totalSize := db.activeFile.WriteOff
+	for _, file := range db.olderFiles {
+		size, err := file.IoManager.Size()
+		if err != nil {
+			return false
+		}
+		totalSize += size
+	}
+	if totalSize == 0 {
+		return false
+	}
+	return float32(db.reclaimSize)/float32(totalSize) >= db.options.AutoMergeRatio
+}
+
+// 通知自动 merge 的协程退出，并等待其结束
+func (db *DB) stopAutoMerge() {
+	if db.autoMergeStop == nil {
+		return
+	}
+	close(db.autoMergeStop)
+	<-db.autoMergeDone
+	db.autoMergeStop = nil
+}
+
 func (db *DB) getMergePath() string {
 	dir := path.Dir(path.Clean(db.options.DirPath))
 	base := path.Base(db.options.DirPath)
@@ -159,9 +449,6 @@ func (db *DB) loadMergeFiles() error {
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
-	defer func() {
-		_ = os.RemoveAll(mergePath)
-	}()
 
 	dirEntries, err := os.ReadDir(mergePath)
 	if err != nil {
@@ -169,55 +456,143 @@ func (db *DB) loadMergeFiles() error {
 	}
 
 	// 查找标识 merge 完成的文件，判断 merge 是否处理完了
-	var mergeFinished bool
+	var mergeFinished, filesDeleted bool
 	var mergeFileNames []string
 	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName {
+		switch entry.Name() {
+		case data.MergeFinishedFileName:
 			mergeFinished = true
+		case mergeFilesDeletedName:
+			filesDeleted = true
+		case data.SeqNoFileName, fileLockName:
+		default:
+			mergeFileNames = append(mergeFileNames, entry.Name())
 		}
-		if entry.Name() == data.SeqNoFileName {
-			continue
-		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 
-	// 没有 merge 完成则直接返回
+	// 没有 merge 完成则直接删除 merge 目录
 	if !mergeFinished {
+		_ = os.RemoveAll(mergePath)
 		return nil
 	}
 
+	// 之后出错时保留 merge 目录，下次启动时继续完成
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
-		return nil
+		return err
+	}
+	mergeFileIds, err := db.getMergeFileIds(mergePath)
+	if err != nil {
+		return err
+	}
+	// 旧版本的 merge 没有记录参与 merge 的文件，此时所有旧的数据文件都参与了 merge
+	if mergeFileIds == nil {
+		var fileId uint32 = 0
+		for ; fileId < nonMergeFileId; fileId++ {
+			mergeFileIds = append(mergeFileIds, fileId)
+		}
 	}
 
-	// 删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+	// B+ 树索引持久化在磁盘上，需要在移动数据文件之前更新为 merge 之后的位置
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		if err := db.remapBPlusTreeIndex(bpt, mergePath, mergeFileIds); err != nil {
+			return err
+		}
+	}
+
+	// 删除参与 merge 的旧数据文件，重写后的同名文件会在移动时直接覆盖
+	// 删除完成之后创建标识文件，移动文件的过程中崩溃时，下次启动不能再删除，否则会删除已经移动到数据目录中的重写之后的文件
+	if !filesDeleted {
+		for _, fileId := range mergeFileIds {
+			if _, err := os.Stat(data.GetDataFileName(mergePath, fileId)); err == nil {
+				continue
+			}
+			fileName := data.GetDataFileName(db.options.DirPath, fileId)
+			if _, err := os.Stat(fileName); err == nil {
+				if err := os.Remove(fileName); err != nil {
+					return err
+				}
 			}
 		}
+		if err := createSyncedFile(filepath.Join(mergePath, mergeFilesDeletedName)); err != nil {
+			return err
+		}
 	}
 
-	// 将新的数据文件移动到数据目录中
-	for _, fileName := range mergeFileNames {
+	// 将新的数据文件移动到数据目录中，标识 merge 完成的文件最后移动
+	for _, fileName := range append(mergeFileNames, data.MergeFinishedFileName) {
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
 		if err := os.Rename(srcPath, destPath); err != nil {
 			return err
 		}
 	}
+	_ = os.RemoveAll(mergePath)
 	return nil
 }
 
+// 创建一个空文件并持久化
+func createSyncedFile(fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// 根据 merge 目录中的 hint 文件，在一个事务中更新 B+ 树索引
+// 仍然指向参与 merge 的文件的 key 替换为重写之后的位置，没有被重写的说明已经失效，直接删除
+// 重复执行的结果是一样的，因此 merge 中途失败之后可以在下次启动时重新执行
+func (db *DB) remapBPlusTreeIndex(bpt *index.BPlusTree, mergePath string, mergeFileIds []uint32) error {
+	isMergeFile := make(map[uint32]bool, len(mergeFileIds))
+	for _, fid := range mergeFileIds {
+		isMergeFile[fid] = true
+	}
+
+	hintFile, err := data.OpenHintFile(mergePath)
+	if err != nil {
+		return err
+	}
+	hintFile.Cipher = db.cipher
+	defer hintFile.Close()
+
+	// 重写之后的数据仍然使用参与 merge 的文件 id
+	positions := make(map[string]*data.LogRecordPos)
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if isMergeFile[pos.Fid] {
+			positions[string(logRecord.Key)] = pos
+		}
+		offset += size
+	}
+
+	return bpt.Remap(func(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+		if !isMergeFile[pos.Fid] {
+			return pos
+		}
+		return positions[string(key)]
+	})
+}
+
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return 0, err
 	}
+	mergeFinishedFile.Cipher = db.cipher
+	defer mergeFinishedFile.Close()
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
 		return 0, err
@@ -229,6 +604,43 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
+// 读取数据目录中最近一次 merge 时没有参与 merge 的最小文件 id，没有 merge 过为 0
+func (db *DB) loadLastMergeFileId() error {
+	db.lastMergeFileId = 0
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); os.IsNotExist(err) {
+		return nil
+	}
+	fid, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	db.lastMergeFileId = fid
+	return nil
+}
+
+// 获取参与 merge 的文件 id，旧版本的 merge 没有记录时返回 nil
+func (db *DB) getMergeFileIds(dirPath string) ([]uint32, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	mergeFinishedFile.Cipher = db.cipher
+	defer mergeFinishedFile.Close()
+	_, size, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return nil, err
+	}
+	record, _, err := mergeFinishedFile.ReadLogRecord(size)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeFileIds(record.Value), nil
+}
+
 // 从 hint 文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
 	// 查看 hint 索引文件是否存在
@@ -242,8 +654,11 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
+	defer hintFile.Close()
 
 	// 读取文件中的索引
+	liveSize := make(map[uint32]int64)
 	var offset int64 = 0
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
@@ -254,10 +669,107 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
-		// 解码拿到实际的位置索引
+		// 解码拿到实际的位置索引，已经过期的数据不再加载
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		if !pos.IsExpired() {
+			db.index.Put(logRecord.Key, pos)
+			liveSize[pos.Fid] += int64(pos.Size)
+		}
 		offset += size
 	}
+
+	// Hint 文件记录了 merge 时所有的有效数据，旧数据文件中剩余的部分都是无效的
+	nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for fid, dataFile := range db.olderFiles {
+		if fid >= nonMergeFileId {
+			continue
+		}
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		if reclaimSize := fileSize - dataFile.HeaderSize() - liveSize[fid]; reclaimSize > 0 {
+			db.reclaimSize += reclaimSize
+			db.fileReclaimSize[fid] += reclaimSize
+		}
+	}
+	return nil
+}
+
+// merge 时重写有效数据使用的写入器，依次复用参与 merge 的文件 id
+type mergeWriter struct {
+	dirPath     string
+	fileIds     []uint32 // 可以使用的文件 id
+	maxFileSize int64
+	codec       data.Codec   // 使用当前的压缩方式重写数据
+	cipher      *data.Cipher // 使用当前的密钥重写数据
+	files       []*data.DataFile
+}
+
+func (mw *mergeWriter) write(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	encRecord, size := data.EncodeLogRecordWith(logRecord, mw.codec, mw.cipher)
+
+	// 当前文件写满之后使用下一个文件 id，id 用完之后继续写在最后一个文件中
+	n := len(mw.files)
+	if n == 0 || (mw.files[n-1].WriteOff+size > mw.maxFileSize && n < len(mw.fileIds)) {
+		dataFile, err := data.OpenDataFile(mw.dirPath, mw.fileIds[n], fio.StandardFIO)
+		if err != nil {
+			return nil, err
+		}
+		mw.files = append(mw.files, dataFile)
+	}
+
+	dataFile := mw.files[len(mw.files)-1]
+	writeOff := dataFile.WriteOff
+	if err := dataFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	return &data.LogRecordPos{
+		Fid:    dataFile.FileId,
+		Offset: writeOff,
+		Expire: logRecord.Expire,
+		Size:   uint32(size),
+	}, nil
+}
+
+func (mw *mergeWriter) sync() error {
+	for _, dataFile := range mw.files {
+		if err := dataFile.Sync(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func (mw *mergeWriter) close() {
+	for _, dataFile := range mw.files {
+		_ = dataFile.Close()
+	}
+}
+
+// 对文件 id 列表进行编码
+func encodeFileIds(fileIds []uint32) []byte {
+	buf := make([]byte, binary.MaxVarintLen32*len(fileIds))
+	var index = 0
+	for _, fid := range fileIds {
+		index += binary.PutUvarint(buf[index:], uint64(fid))
+	}
+	return buf[:index]
+}
+
+// 解码文件 id 列表
+func decodeFileIds(buf []byte) []uint32 {
+	fileIds := make([]uint32, 0)
+	for len(buf) > 0 {
+		fid, n := binary.Uvarint(buf)
+		if n <= 0 {
+			break
+		}
+		fileIds = append(fileIds, uint32(fid))
+		buf = buf[n:]
+	}
+	return fileIds
+}
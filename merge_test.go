@@ -0,0 +1,396 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 没有任何数据的情况下进行 merge
+func TestDB_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+}
+
+// 有失效的数据，也有被重复 Put 的数据
+func TestDB_Merge2(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-2")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 40000; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new value in merge"))
+		assert.Nil(t, err)
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	reclaimSize := stat.ReclaimableSize
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 40000, len(keys))
+
+	for i := 0; i < 10000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	for i := 40000; i < 50000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new value in merge"), val)
+	}
+	stat, err = db2.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.ReclaimableSize < reclaimSize)
+}
+
+// 只有无效数据占比达到阈值的文件参与 merge
+func TestDB_Merge_Ratio(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-ratio")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.DataFileMergeRatio = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 5000; i++ {
+		val := utils.RandomValue(512)
+		err := db.Put(utils.GetTestKey(i), val)
+		assert.Nil(t, err)
+		values[string(utils.GetTestKey(i))] = val
+	}
+	assert.True(t, len(db.olderFiles) >= 2)
+
+	// 无效数据不足，不会进行 merge
+	err = db.Merge()
+	assert.Equal(t, ErrMergeRatioUnreached, err)
+
+	// 覆盖第一个数据文件中的所有数据
+	var firstFileKeys [][]byte
+	for _, key := range db.ListKeys() {
+		if db.index.Get(key).Fid == 0 {
+			firstFileKeys = append(firstFileKeys, key)
+		}
+	}
+	assert.True(t, len(firstFileKeys) > 0)
+	for _, key := range firstFileKeys {
+		val := utils.RandomValue(512)
+		err := db.Put(key, val)
+		assert.Nil(t, err)
+		values[string(key)] = val
+	}
+	assert.True(t, db.fileReclaimSize[0] > 0)
+
+	secondFile := data.GetDataFileName(dir, 1)
+	secondInfo, err := os.Stat(secondFile)
+	assert.Nil(t, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+
+	// 第一个文件中的数据全部失效，被删除；第二个文件没有参与 merge
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.True(t, os.IsNotExist(err))
+	secondInfo2, err := os.Stat(secondFile)
+	assert.Nil(t, err)
+	assert.Equal(t, secondInfo.Size(), secondInfo2.Size())
+	assert.Equal(t, int64(0), db2.fileReclaimSize[1])
+
+	assert.Equal(t, len(values), len(db2.ListKeys()))
+	for key, val := range values {
+		v, err := db2.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, val, v)
+	}
+}
+
+// 无效数据达到阈值之后自动 merge
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.AutoMergeInterval = 20 * time.Millisecond
+	opts.AutoMergeRatio = 0.3
+	opts.AutoMergeMinReclaimSize = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(512))
+		assert.Nil(t, err)
+	}
+	// 没有达到阈值，不会 merge
+	time.Sleep(100 * time.Millisecond)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	for i := 0; i < 2000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	_, err = os.Stat(filepath.Join(dir, data.MergeFinishedFileName))
+	assert.Nil(t, err)
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.ReclaimableSize)
+	assert.Equal(t, uint(1), stat.DataFileNum)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.Nil(t, db.autoMergeStop)
+}
+
+// merge 的结果直接生效，并且 merge 期间的写入不受影响
+func TestDB_Merge_Online(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(256))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	statBefore, err := db.Stat()
+	assert.Nil(t, err)
+	sizeBefore := statBefore.DiskSize
+
+	// merge 的同时写入新的数据
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 5000; i < 7000; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("value written in merge"))
+			assert.Nil(t, err)
+		}
+	}()
+	err = db.Merge()
+	assert.Nil(t, err)
+	<-done
+
+	// 不需要重启就可以看到 merge 的结果
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskSize < sizeBefore)
+	assert.Equal(t, uint(5000), stat.KeyNum)
+
+	check := func(db *DB) {
+		for i := 0; i < 5000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		for i := 5000; i < 7000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("value written in merge"), val)
+		}
+		for i := 7000; i < 10000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.NotNil(t, val)
+		}
+	}
+	check(db)
+
+	// 重启之后数据依然正确
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+	stat2, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, stat2.ReclaimableSize)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+// B+ 树索引的 merge，包括 merge 中途退出之后重新打开的情况
+func TestDB_Merge_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-bptree")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 5000; i++ {
+		val := utils.RandomValue(256)
+		err := db.Put(utils.GetTestKey(i), val)
+		assert.Nil(t, err)
+		values[string(utils.GetTestKey(i))] = val
+	}
+	for i := 0; i < 2000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, string(utils.GetTestKey(i)))
+	}
+
+	check := func(db *DB) {
+		assert.Equal(t, len(values), len(db.ListKeys()))
+		for key, val := range values {
+			v, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, val, v)
+		}
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	check(db)
+
+	// 覆盖部分数据之后再次 merge，但是只重写数据文件，模拟应用 merge 结果之前退出
+	for i := 2000; i < 3000; i++ {
+		val := utils.RandomValue(256)
+		err := db.Put(utils.GetTestKey(i), val)
+		assert.Nil(t, err)
+		values[string(utils.GetTestKey(i))] = val
+	}
+	db.mu.Lock()
+	mergeFiles, _, err := db.pickMergeFiles()
+	assert.Nil(t, err)
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	err = db.setActiveDataFile()
+	assert.Nil(t, err)
+	nonMergeFileId := db.activeFile.FileId
+	db.mu.Unlock()
+	sort.Slice(mergeFiles, func(i, j int) bool {
+		return mergeFiles[i].FileId < mergeFiles[j].FileId
+	})
+	_, err = db.rewriteMergeFiles(mergeFiles, nonMergeFileId)
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, err = os.Stat(db2.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	check(db2)
+}
+
+// 移动重写之后的数据文件的过程中崩溃，下次启动时继续完成 merge
+func TestDB_Merge_ResumeAfterPartialMove(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-resume")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 只生成 merge 目录，不应用到当前实例
+	db.mu.Lock()
+	mergeFiles, _, err := db.pickMergeFiles()
+	assert.Nil(t, err)
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	assert.Nil(t, db.setActiveDataFile())
+	nonMergeFileId := db.activeFile.FileId
+	db.mu.Unlock()
+	_, err = db.rewriteMergeFiles(mergeFiles, nonMergeFileId)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 第二个重写之后的数据文件无法移动，启动失败时第一个文件已经移动到数据目录中
+	mergedNames, err := filepath.Glob(filepath.Join(db.getMergePath(), "*"+data.DataFileNameSuffix))
+	assert.Nil(t, err)
+	assert.True(t, len(mergedNames) >= 2)
+	blocked := filepath.Join(dir, filepath.Base(mergedNames[1]))
+	assert.Nil(t, os.Remove(blocked))
+	assert.Nil(t, os.MkdirAll(filepath.Join(blocked, "blocked"), os.ModePerm))
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(mergedNames[0])
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(mergedNames[1])
+	assert.Nil(t, err)
+
+	// 已经移动到数据目录中的文件不能被当作参与 merge 的旧文件删除
+	assert.Nil(t, os.RemoveAll(blocked))
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	for i := 0; i < 2000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i < 500 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+}
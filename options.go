@@ -1,6 +1,10 @@
 package bitcask_go
 
-import "os"
+import (
+	"bitcask-go/data"
+	"os"
+	"time"
+)
 
 type IndexType = int8
 
@@ -32,15 +36,66 @@ type Options struct {
 
 	// 启动时是否使用 MMap 加载数据
 	MMapAtStartup bool
+
+	// 数据文件中无效数据的占比达到该阈值才会参与 merge，为 0 时所有旧的数据文件都参与 merge
+	DataFileMergeRatio float32
+
+	// 自动 merge 的检查间隔，为 0 表示不开启自动 merge
+	AutoMergeInterval time.Duration
+
+	// 无效数据占全部数据的比例达到该阈值时自动 merge
+	AutoMergeRatio float32
+
+	// 无效数据至少达到多少字节才会自动 merge
+	AutoMergeMinReclaimSize int64
+
+	// value 的压缩方式，只对之后写入的数据生效，merge 时使用当前的压缩方式重写参与 merge 的数据
+	// 第一条数据使用其他压缩方式压缩的数据文件，不管无效数据的占比都会在 merge 时重写；没有压缩的数据文件按照无效数据的占比参与 merge
+	Compression Compression
+
+	// 加密数据使用的密钥，为 nil 时不加密。数据文件、hint 文件、标识 merge 完成的文件和事务序列号文件都会加密
+	// 更换当前的密钥之后，merge 时会使用新的密钥重写旧的数据文件，merge 完成之后才可以删除旧的密钥
+	KeyProvider KeyProvider
+
+	// 启动时截断活跃文件末尾没有完整写入的数据之前，是否将这部分数据保存到数据目录中的 .torn 文件
+	QuarantineTornTail bool
+
+	// 截断活跃文件末尾没有完整写入的数据之后的回调，为 nil 时输出日志
+	OnTornTail func(tail TornTail)
 }
 
+// TornTail 启动时在活跃文件末尾发现的没有完整写入的数据，一般是写入的过程中进程崩溃导致的
+type TornTail struct {
+	FileId         uint32 // 数据文件 id
+	Offset         int64  // 截断的位置，之前的数据都是完整的
+	Size           int64  // 被截断的数据长度
+	QuarantinePath string // 保存被截断的数据的文件，没有保存时为空
+}
+
+// Compression value 的压缩方式，自定义的压缩方式通过 data.RegisterCodec 注册之后使用对应的 id
+type Compression = byte
+
+const (
+	NoCompression    Compression = data.CodecNone
+	FlateCompression Compression = data.CodecFlate
+	GzipCompression  Compression = data.CodecGzip
+)
+
+// KeyProvider 提供加密数据使用的密钥，可以使用 data.StaticKeyProvider
+type KeyProvider = data.KeyProvider
+
 var DefaultOptions = Options{
-	DirPath:       os.TempDir(),
-	DataFileSize:  256 * 1024 * 1024, // 256MB
-	SyncWrites:    false,
-	BytesPerSync:  0,
-	IndexType:     BTree,
-	MMapAtStartup: true,
+	DirPath:                 os.TempDir(),
+	DataFileSize:            256 * 1024 * 1024, // 256MB
+	SyncWrites:              false,
+	BytesPerSync:            0,
+	IndexType:               BTree,
+	MMapAtStartup:           true,
+	DataFileMergeRatio:      0,
+	AutoMergeInterval:       0,
+	AutoMergeRatio:          0.5,
+	AutoMergeMinReclaimSize: 64 * 1024 * 1024, // 64MB
+	Compression:             NoCompression,
 }
 
 
@@ -69,4 +124,46 @@ type WriteBatchOptions struct {
 var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
-}
\ No newline at end of file
+}
+
+// TxnOptions 事务配置项
+type TxnOptions struct {
+	// 一个事务当中最多写入的数据量
+	MaxWriteNum uint
+
+	// 提交时是否 sync 持久化
+	SyncWrites bool
+}
+
+var DefaultTxnOptions = TxnOptions{
+	MaxWriteNum: 10000,
+	SyncWrites:  true,
+}
+
+// WatchPolicy 订阅者的缓冲区已满时的处理策略
+type WatchPolicy = int8
+
+const (
+	// WatchDrop 丢弃新的事件，丢弃的数量可以通过 Watcher.Dropped 获取
+	WatchDrop WatchPolicy = iota + 1
+
+	// WatchBlock 阻塞写入，直到订阅者取走事件
+	// 所有订阅者的事件按照写入的顺序依次投递，任意一个订阅者的缓冲区已满都会阻塞之后所有的写入，
+	// 写入的速度受限于最慢的订阅者，其他订阅者也要等待它取走事件之后才能收到新的事件。
+	// 有多个写入在等待投递时，读取也会被阻塞
+	WatchBlock
+)
+
+// WatchOptions 订阅配置项
+type WatchOptions struct {
+	// 事件缓冲区的大小
+	BufferSize uint
+
+	// 缓冲区已满时的处理策略
+	Policy WatchPolicy
+}
+
+var DefaultWatchOptions = WatchOptions{
+	BufferSize: 1024,
+	Policy:     WatchDrop,
+}
@@ -0,0 +1,237 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"encoding/binary"
+	"math/bits"
+)
+
+// Bitmap 按照固定大小切分成多个分片，每个分片对应一条数据，SetBit 只需要重写一个分片
+// 元数据中的 size 记录 Bitmap 的字节长度，长度之内不存在的分片和分片末尾缺少的字节都视为 0
+const (
+	bitmapChunkSize = 1024 // 每个分片的字节数
+
+	// 和 Redis 一样，最多支持 2^32 个 bit，即 512MB
+	maxBitOffset = 1<<32 - 1
+)
+
+// BitOperation BitOp 支持的位运算
+type BitOperation byte
+
+const (
+	BitAnd BitOperation = iota
+	BitOr
+	BitXor
+	BitNot
+)
+
+// SetBit 设置 offset 处的 bit，返回原来的值，Bitmap 长度不够时自动扩展
+func (rds *RedisDataStructure) SetBit(key []byte, offset uint64, value bool) (bool, error) {
+	if offset > maxBitOffset {
+		return false, ErrBitOffsetOutOfRange
+	}
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, Bitmap)
+	if err != nil {
+		return false, err
+	}
+
+	byteIndex := offset / 8
+	chunkIndex := uint32(byteIndex / bitmapChunkSize)
+	pos := int(byteIndex % bitmapChunkSize)
+	mask := byte(1) << (7 - offset%8)
+
+	chunkKey := encodeBitmapChunkKey(key, meta.version, chunkIndex)
+	chunk, err := rds.getBitmapChunk(chunkKey)
+	if err != nil {
+		return false, err
+	}
+	old := pos < len(chunk) && chunk[pos]&mask != 0
+	grown := uint32(byteIndex)+1 > meta.size
+	if old == value && !grown {
+		return old, nil
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	if old != value {
+		if pos >= len(chunk) {
+			chunk = append(chunk, make([]byte, pos+1-len(chunk))...)
+		}
+		chunk[pos] ^= mask
+		_ = wb.Put(chunkKey, chunk)
+	}
+	if grown {
+		meta.size = uint32(byteIndex) + 1
+	}
+	_ = wb.Put(encodeMetaKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return false, err
+	}
+	return old, nil
+}
+
+// GetBit 读取 offset 处的 bit，key 不存在或者超出长度时返回 false
+func (rds *RedisDataStructure) GetBit(key []byte, offset uint64) (bool, error) {
+	meta, err := rds.existingMetadata(key, Bitmap)
+	if err != nil || meta == nil {
+		return false, err
+	}
+	byteIndex := offset / 8
+	if byteIndex >= uint64(meta.size) {
+		return false, nil
+	}
+
+	chunk, err := rds.getBitmapChunk(encodeBitmapChunkKey(key, meta.version, uint32(byteIndex/bitmapChunkSize)))
+	if err != nil {
+		return false, err
+	}
+	pos := int(byteIndex % bitmapChunkSize)
+	return pos < len(chunk) && chunk[pos]&(byte(1)<<(7-offset%8)) != 0, nil
+}
+
+// BitCount 返回值为 1 的 bit 的数量
+func (rds *RedisDataStructure) BitCount(key []byte) (uint64, error) {
+	meta, err := rds.existingMetadata(key, Bitmap)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+
+	// 只需要遍历实际存在的分片
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: dataKeyPrefix(key, meta.version)})
+	defer iter.Close()
+	var count uint64
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		chunk, err := iter.Value()
+		if err != nil {
+			return 0, err
+		}
+		for _, b := range chunk {
+			count += uint64(bits.OnesCount8(b))
+		}
+	}
+	return count, nil
+}
+
+// BitOp 对多个 Bitmap 做位运算，结果保存到 destKey，返回结果的字节长度
+// 结果的长度和最长的 Bitmap 一致，较短的 Bitmap 缺少的部分视为 0。BitNot 只能有一个 key
+// 所有的 key 都不存在时删除 destKey
+func (rds *RedisDataStructure) BitOp(op BitOperation, destKey []byte, keys ...[]byte) (uint32, error) {
+	if op > BitNot {
+		return 0, ErrUnknownBitOperation
+	}
+	if len(keys) == 0 || (op == BitNot && len(keys) != 1) {
+		return 0, ErrBitOpArgs
+	}
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	metas := make([]*metadata, len(keys))
+	var length uint32
+	for i, key := range keys {
+		meta, err := rds.existingMetadata(key, Bitmap)
+		if err != nil {
+			return 0, err
+		}
+		metas[i] = meta
+		if meta != nil && meta.size > length {
+			length = meta.size
+		}
+	}
+	if length == 0 {
+		if err := rds.db.Delete(encodeMetaKey(destKey)); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	// 结果总是使用新的版本号，destKey 也可以是参与运算的 key
+	// 分片每 dataBatchNum 条提交一次，元数据和最后一批分片一起提交，在此之前新版本的数据不可见，destKey 原来的数据保持不变
+	// 中途失败时已经提交的分片，以及成功之后 destKey 原来的数据，都由 SweepStaleData 清理
+	destMeta := &metadata{dataType: Bitmap, version: rds.nextVersion(), size: length}
+	chunkNum := (length + bitmapChunkSize - 1) / bitmapChunkSize
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	var pending int
+	for chunkIndex := uint32(0); chunkIndex < chunkNum; chunkIndex++ {
+		chunkLen := bitmapChunkSize
+		if rest := length - chunkIndex*bitmapChunkSize; rest < bitmapChunkSize {
+			chunkLen = int(rest)
+		}
+		result := make([]byte, chunkLen)
+		for i, key := range keys {
+			var chunk []byte
+			if metas[i] != nil {
+				var err error
+				chunk, err = rds.getBitmapChunk(encodeBitmapChunkKey(key, metas[i].version, chunkIndex))
+				if err != nil {
+					return 0, err
+				}
+			}
+			applyBitOperation(op, result, chunk, i == 0)
+		}
+		// 全部为 0 的分片不需要保存
+		if isZeroChunk(result) {
+			continue
+		}
+		_ = wb.Put(encodeBitmapChunkKey(destKey, destMeta.version, chunkIndex), result)
+		if pending++; pending == dataBatchNum {
+			if err := wb.Commit(); err != nil {
+				return 0, err
+			}
+			pending = 0
+		}
+	}
+	_ = wb.Put(encodeMetaKey(destKey), destMeta.encode())
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// 将 chunk 按照 op 合并到 result 中，chunk 缺少的字节视为 0
+func applyBitOperation(op BitOperation, result, chunk []byte, first bool) {
+	for j := range result {
+		var b byte
+		if j < len(chunk) {
+			b = chunk[j]
+		}
+		switch {
+		case op == BitNot:
+			result[j] = ^b
+		case first:
+			result[j] = b
+		case op == BitAnd:
+			result[j] &= b
+		case op == BitOr:
+			result[j] |= b
+		case op == BitXor:
+			result[j] ^= b
+		}
+	}
+}
+
+func isZeroChunk(chunk []byte) bool {
+	for _, b := range chunk {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 读取分片，分片不存在时返回 nil
+func (rds *RedisDataStructure) getBitmapChunk(chunkKey []byte) ([]byte, error) {
+	chunk, err := rds.db.Get(chunkKey)
+	if err == bitcask.ErrKeyNotFound {
+		return nil, nil
+	}
+	return chunk, err
+}
+
+// 分片的 key，分片下标使用大端序编码，key 的顺序和分片的顺序一致
+func encodeBitmapChunkKey(key []byte, version int64, chunkIndex uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, chunkIndex)
+	return encodeDataKey(key, version, buf)
+}
@@ -0,0 +1,167 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRedisDataStructure_SetBit(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-setbit")
+	defer cleanup()
+
+	old, err := rds.SetBit([]byte("dau"), 7, true)
+	assert.Nil(t, err)
+	assert.False(t, old)
+	old, err = rds.SetBit([]byte("dau"), 7, true)
+	assert.Nil(t, err)
+	assert.True(t, old)
+
+	// 跨越多个分片
+	offsets := []uint64{0, bitmapChunkSize*8 - 1, bitmapChunkSize * 8, bitmapChunkSize*8*10 + 3}
+	for _, offset := range offsets {
+		_, err := rds.SetBit([]byte("dau"), offset, true)
+		assert.Nil(t, err)
+	}
+	for _, offset := range append(offsets, 7) {
+		bit, err := rds.GetBit([]byte("dau"), offset)
+		assert.Nil(t, err)
+		assert.True(t, bit)
+	}
+	bit, err := rds.GetBit([]byte("dau"), 1)
+	assert.Nil(t, err)
+	assert.False(t, bit)
+	bit, err = rds.GetBit([]byte("dau"), 1<<30)
+	assert.Nil(t, err)
+	assert.False(t, bit)
+
+	count, err := rds.BitCount([]byte("dau"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), count)
+
+	old, err = rds.SetBit([]byte("dau"), 7, false)
+	assert.Nil(t, err)
+	assert.True(t, old)
+	count, err = rds.BitCount([]byte("dau"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), count)
+
+	_, err = rds.SetBit([]byte("dau"), 1<<32, true)
+	assert.Equal(t, ErrBitOffsetOutOfRange, err)
+	_, err = rds.HSet([]byte("h"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = rds.SetBit([]byte("h"), 1, true)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+// SetBit 只重写 offset 所在的分片
+func TestRedisDataStructure_SetBit_Chunk(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-setbit-chunk")
+	defer cleanup()
+
+	_, err := rds.SetBit([]byte("dau"), bitmapChunkSize*8*100, true)
+	assert.Nil(t, err)
+	meta, err := rds.existingMetadata([]byte("dau"), Bitmap)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(bitmapChunkSize*100+1), meta.size)
+
+	chunk, err := rds.db.Get(encodeBitmapChunkKey([]byte("dau"), meta.version, 100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x80}, chunk)
+	_, err = rds.db.Get(encodeBitmapChunkKey([]byte("dau"), meta.version, 0))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
+
+func TestRedisDataStructure_BitOp(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-bitop")
+	defer cleanup()
+
+	// a: bit 0, 1, 2; b: bit 1, 2, 3 以及第二个分片中的一个 bit
+	for _, offset := range []uint64{0, 1, 2} {
+		_, _ = rds.SetBit([]byte("a"), offset, true)
+	}
+	for _, offset := range []uint64{1, 2, 3, bitmapChunkSize*8 + 5} {
+		_, _ = rds.SetBit([]byte("b"), offset, true)
+	}
+
+	bitsOf := func(key string, max uint64) []uint64 {
+		var result []uint64
+		for offset := uint64(0); offset < max; offset++ {
+			bit, err := rds.GetBit([]byte(key), offset)
+			assert.Nil(t, err)
+			if bit {
+				result = append(result, offset)
+			}
+		}
+		return result
+	}
+
+	length, err := rds.BitOp(BitAnd, []byte("and"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(bitmapChunkSize+1), length)
+	assert.Equal(t, []uint64{1, 2}, bitsOf("and", bitmapChunkSize*8+8))
+
+	_, err = rds.BitOp(BitOr, []byte("or"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0, 1, 2, 3, bitmapChunkSize*8 + 5}, bitsOf("or", bitmapChunkSize*8+8))
+
+	_, err = rds.BitOp(BitXor, []byte("xor"), []byte("a"), []byte("b"), []byte("not exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0, 3, bitmapChunkSize*8 + 5}, bitsOf("xor", bitmapChunkSize*8+8))
+
+	length, err = rds.BitOp(BitNot, []byte("not"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), length)
+	assert.Equal(t, []uint64{3, 4, 5, 6, 7}, bitsOf("not", 16))
+	count, err := rds.BitCount([]byte("not"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), count)
+
+	// 结果可以覆盖参与运算的 key
+	_, err = rds.BitOp(BitAnd, []byte("a"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2}, bitsOf("a", 16))
+
+	// 所有的 key 都不存在时删除 destKey
+	length, err = rds.BitOp(BitOr, []byte("or"), []byte("x"), []byte("y"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), length)
+	_, err = rds.Type([]byte("or"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	_, err = rds.BitOp(BitNot, []byte("not"), []byte("a"), []byte("b"))
+	assert.Equal(t, ErrBitOpArgs, err)
+	_, err = rds.BitOp(BitAnd, []byte("and"))
+	assert.Equal(t, ErrBitOpArgs, err)
+}
+
+// 结果的分片超过一批时分多次提交
+func TestRedisDataStructure_BitOp_Batches(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-bitop-batches")
+	defer cleanup()
+
+	chunkNum := uint64(dataBatchNum*2 + 5)
+	offset := chunkNum*bitmapChunkSize*8 - 1
+	_, err := rds.SetBit([]byte("a"), offset, true)
+	assert.Nil(t, err)
+	_, err = rds.SetBit([]byte("not"), 0, true)
+	assert.Nil(t, err)
+
+	length, err := rds.BitOp(BitNot, []byte("not"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(chunkNum*bitmapChunkSize), length)
+	count, err := rds.BitCount([]byte("not"))
+	assert.Nil(t, err)
+	assert.Equal(t, offset, count)
+	bit, err := rds.GetBit([]byte("not"), offset)
+	assert.Nil(t, err)
+	assert.False(t, bit)
+
+	// 原来的数据在清理之后删除
+	swept, err := rds.SweepStaleData()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, swept)
+	count, err = rds.BitCount([]byte("not"))
+	assert.Nil(t, err)
+	assert.Equal(t, offset, count)
+}
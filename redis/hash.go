@@ -0,0 +1,134 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+)
+
+// HSet 设置 field 的值，返回 field 是否是新增的
+func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return false, err
+	}
+
+	dataKey := encodeDataKey(key, meta.version, field)
+	exist, err := rds.dataKeyExists(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	// 元数据和数据一起写入
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	if !exist {
+		meta.size++
+		_ = wb.Put(encodeMetaKey(key), meta.encode())
+	}
+	_ = wb.Put(dataKey, value)
+	if err := wb.Commit(); err != nil {
+		return false, err
+	}
+	return !exist, nil
+}
+
+// HGet 读取 field 的值，key 或者 field 不存在时返回 bitcask.ErrKeyNotFound
+func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
+	meta, err := rds.existingMetadata(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, bitcask.ErrKeyNotFound
+	}
+	return rds.db.Get(encodeDataKey(key, meta.version, field))
+}
+
+// HDel 删除 field，返回实际删除的数量
+func (rds *RedisDataStructure) HDel(key []byte, fields ...[]byte) (int, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.existingMetadata(key, Hash)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	deleted := make(map[string]bool)
+	for _, field := range fields {
+		if deleted[string(field)] {
+			continue
+		}
+		dataKey := encodeDataKey(key, meta.version, field)
+		exist, err := rds.dataKeyExists(dataKey)
+		if err != nil {
+			return 0, err
+		}
+		if exist {
+			_ = wb.Delete(dataKey)
+			deleted[string(field)] = true
+		}
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	// 所有的 field 都被删除之后，删除元数据
+	meta.size -= uint32(len(deleted))
+	if meta.size == 0 {
+		_ = wb.Delete(encodeMetaKey(key))
+	} else {
+		_ = wb.Put(encodeMetaKey(key), meta.encode())
+	}
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return len(deleted), nil
+}
+
+// HGetAll 读取所有的 field 和值
+func (rds *RedisDataStructure) HGetAll(key []byte) (map[string][]byte, error) {
+	meta, err := rds.existingMetadata(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte)
+	if meta == nil {
+		return result, nil
+	}
+
+	prefix := dataKeyPrefix(key, meta.version)
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		if err != nil {
+			return nil, err
+		}
+		result[string(iter.Key()[len(prefix):])] = value
+	}
+	return result, nil
+}
+
+// HLen 返回 field 的数量
+func (rds *RedisDataStructure) HLen(key []byte) (uint32, error) {
+	meta, err := rds.existingMetadata(key, Hash)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// 判断数据 key 是否存在
+func (rds *RedisDataStructure) dataKeyExists(dataKey []byte) (bool, error) {
+	_, err := rds.db.TTL(dataKey)
+	if err == nil {
+		return true, nil
+	}
+	if err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,213 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func openRedis(t *testing.T, name string) (*RedisDataStructure, func()) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", name)
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	return rds, func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestRedisDataStructure_HGet(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-hget")
+	defer cleanup()
+
+	ok, err := rds.HSet([]byte("user"), []byte("name"), []byte("alice"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.HSet([]byte("user"), []byte("name"), []byte("bob"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.HSet([]byte("user"), []byte("age"), []byte("20"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	val, err := rds.HGet([]byte("user"), []byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bob"), val)
+	_, err = rds.HGet([]byte("user"), []byte("not exist"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	_, err = rds.HGet([]byte("not exist"), []byte("name"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	size, err := rds.HLen([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+
+	all, err := rds.HGetAll([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"name": []byte("bob"), "age": []byte("20")}, all)
+
+	typ, err := rds.Type([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, Hash, typ)
+}
+
+func TestRedisDataStructure_HDel(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-hdel")
+	defer cleanup()
+
+	n, err := rds.HDel([]byte("user"), []byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	_, _ = rds.HSet([]byte("user"), []byte("name"), []byte("alice"))
+	_, _ = rds.HSet([]byte("user"), []byte("age"), []byte("20"))
+	n, err = rds.HDel([]byte("user"), []byte("name"), []byte("name"), []byte("not exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	size, err := rds.HLen([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+
+	// 删除最后一个 field 之后 key 不存在
+	n, err = rds.HDel([]byte("user"), []byte("age"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = rds.Type([]byte("user"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
+
+func TestRedisDataStructure_Del(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-del")
+	defer cleanup()
+
+	_, _ = rds.HSet([]byte("user"), []byte("name"), []byte("alice"))
+	_, _ = rds.HSet([]byte("user"), []byte("age"), []byte("20"))
+
+	// 删除 key 之后旧的 field 不可见
+	err := rds.Del([]byte("user"))
+	assert.Nil(t, err)
+	_, err = rds.HGet([]byte("user"), []byte("name"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	ok, err := rds.HSet([]byte("user"), []byte("email"), []byte("a@b.c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = rds.HGet([]byte("user"), []byte("age"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	all, err := rds.HGetAll([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"email": []byte("a@b.c")}, all)
+	size, err := rds.HLen([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+}
+
+func TestRedisDataStructure_Del_DataKeys(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-del-data")
+	defer cleanup()
+
+	countDataKeys := func(key []byte) int {
+		iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: allVersionsDataKeyPrefix(key)})
+		defer iter.Close()
+		var count int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			count++
+		}
+		return count
+	}
+
+	_, _ = rds.HSet([]byte("hash"), []byte("name"), []byte("alice"))
+	_, _ = rds.RPush([]byte("list"), []byte("a"), []byte("b"))
+	_, _ = rds.SAdd([]byte("set"), []byte("a"), []byte("b"))
+	_, _ = rds.ZAdd([]byte("zset"), 1, []byte("a"))
+	_, _ = rds.SetBit([]byte("bitmap"), 100000, true)
+	_, _ = rds.HSet([]byte("hash2"), []byte("name"), []byte("bob"))
+	// 覆盖之后遗留的旧版本数据
+	_, _ = rds.SetBit([]byte("bitmap2"), 1, true)
+	_, err := rds.BitOp(BitNot, []byte("bitmap"), []byte("bitmap2"))
+	assert.Nil(t, err)
+
+	// 删除 key 只删除元数据，数据不再可见
+	for _, key := range []string{"hash", "list", "set", "zset", "bitmap"} {
+		assert.True(t, countDataKeys([]byte(key)) > 0, key)
+		assert.Nil(t, rds.Del([]byte(key)))
+		_, err := rds.Type([]byte(key))
+		assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	}
+	// 删除之后重新创建的 key 使用新的版本号，过期的 key 的数据也是旧版本的数据
+	_, _ = rds.HSet([]byte("hash"), []byte("age"), []byte("20"))
+	_, _ = rds.HSet([]byte("expired"), []byte("name"), []byte("carol"))
+	meta, err := rds.getMetadata([]byte("expired"))
+	assert.Nil(t, err)
+	meta.expire = time.Now().Add(-time.Second).UnixNano()
+	assert.Nil(t, rds.db.Put(encodeMetaKey([]byte("expired")), meta.encode()))
+
+	// 超过一批的旧版本数据
+	for i := 0; i < 2500; i++ {
+		_, _ = rds.HSet([]byte("big"), []byte(strconv.Itoa(i)), []byte("v"))
+	}
+	assert.Nil(t, rds.Del([]byte("big")))
+
+	// 清理之后只剩下当前版本的数据
+	swept, err := rds.SweepStaleData()
+	assert.Nil(t, err)
+	assert.True(t, swept > 2500)
+	for _, key := range []string{"list", "set", "zset", "bitmap", "expired", "big"} {
+		assert.Equal(t, 0, countDataKeys([]byte(key)), key)
+	}
+	assert.Equal(t, 1, countDataKeys([]byte("hash")))
+	val, err := rds.HGet([]byte("hash"), []byte("age"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("20"), val)
+	assert.Equal(t, 1, countDataKeys([]byte("hash2")))
+	assert.Equal(t, 1, countDataKeys([]byte("bitmap2")))
+	bit, err := rds.GetBit([]byte("bitmap2"), 0)
+	assert.Nil(t, err)
+	assert.False(t, bit)
+
+	// 没有旧版本的数据时不会删除任何数据
+	swept, err = rds.SweepStaleData()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, swept)
+
+	// 删除不存在的 key
+	assert.Nil(t, rds.Del([]byte("not exist")))
+}
+
+// 开启自动 merge 时在后台清理旧版本的数据
+func TestRedisDataStructure_AutoSweep(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-auto-sweep")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.AutoMergeInterval = 10 * time.Millisecond
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := rds.HSet([]byte("hash"), []byte{byte(i)}, []byte("v"))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, rds.Del([]byte("hash")))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: allVersionsDataKeyPrefix([]byte("hash"))})
+		iter.Rewind()
+		valid := iter.Valid()
+		iter.Close()
+		if !valid {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale data is not swept before timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, rds.Close())
+}
@@ -0,0 +1,158 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"encoding/binary"
+)
+
+// LPush 从头部插入元素，按照参数的顺序依次插入，返回插入之后的长度
+func (rds *RedisDataStructure) LPush(key []byte, elements ...[]byte) (uint32, error) {
+	return rds.pushInner(key, elements, true)
+}
+
+// RPush 从尾部插入元素，按照参数的顺序依次插入，返回插入之后的长度
+func (rds *RedisDataStructure) RPush(key []byte, elements ...[]byte) (uint32, error) {
+	return rds.pushInner(key, elements, false)
+}
+
+// LPop 弹出头部的元素，List 为空时返回 bitcask.ErrKeyNotFound
+func (rds *RedisDataStructure) LPop(key []byte) ([]byte, error) {
+	return rds.popInner(key, true)
+}
+
+// RPop 弹出尾部的元素，List 为空时返回 bitcask.ErrKeyNotFound
+func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
+	return rds.popInner(key, false)
+}
+
+// LRange 返回下标在 [start, stop] 之间的元素，负数表示从尾部开始计数，-1 为最后一个元素
+func (rds *RedisDataStructure) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	meta, err := rds.existingMetadata(key, List)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	size := int64(meta.size)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+
+	// 元素的 key 按照下标排序，从 start 对应的 key 开始遍历
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: dataKeyPrefix(key, meta.version)})
+	defer iter.Close()
+	var result [][]byte
+	count := stop - start + 1
+	for iter.Seek(encodeListDataKey(key, meta.version, meta.head+uint64(start))); iter.Valid() && int64(len(result)) < count; iter.Next() {
+		value, err := iter.Value()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// LLen 返回元素的数量
+func (rds *RedisDataStructure) LLen(key []byte) (uint32, error) {
+	meta, err := rds.existingMetadata(key, List)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+func (rds *RedisDataStructure) pushInner(key []byte, elements [][]byte, isLeft bool) (uint32, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	if len(elements) == 0 {
+		return meta.size, nil
+	}
+
+	// 元数据和数据一起写入
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	for _, element := range elements {
+		var index uint64
+		if isLeft {
+			meta.head--
+			index = meta.head
+		} else {
+			index = meta.tail
+			meta.tail++
+		}
+		_ = wb.Put(encodeListDataKey(key, meta.version, index), element)
+	}
+	meta.size += uint32(len(elements))
+	_ = wb.Put(encodeMetaKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.existingMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, bitcask.ErrKeyNotFound
+	}
+
+	var index uint64
+	if isLeft {
+		index = meta.head
+	} else {
+		index = meta.tail - 1
+	}
+	dataKey := encodeListDataKey(key, meta.version, index)
+	element, err := rds.db.Get(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// 弹出最后一个元素之后，删除元数据
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	_ = wb.Delete(dataKey)
+	meta.size--
+	if meta.size == 0 {
+		_ = wb.Delete(encodeMetaKey(key))
+	} else {
+		if isLeft {
+			meta.head++
+		} else {
+			meta.tail--
+		}
+		_ = wb.Put(encodeMetaKey(key), meta.encode())
+	}
+	if err := wb.Commit(); err != nil {
+		return nil, err
+	}
+	return element, nil
+}
+
+// List 元素的 key，下标使用大端序编码，key 的顺序和下标的顺序一致
+func encodeListDataKey(key []byte, version int64, index uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, index)
+	return encodeDataKey(key, version, buf)
+}
@@ -0,0 +1,100 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRedisDataStructure_LPush(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-lpush")
+	defer cleanup()
+
+	size, err := rds.LPush([]byte("list"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+	size, err = rds.RPush([]byte("list"), []byte("c"), []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), size)
+
+	size, err = rds.LLen([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), size)
+
+	elements, err := rds.LRange([]byte("list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("a"), []byte("c"), []byte("d")}, elements)
+
+	// 不同类型的 key
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.LPush([]byte("hash"), []byte("a"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_LPop(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-lpop")
+	defer cleanup()
+
+	_, err := rds.LPop([]byte("list"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	_, _ = rds.RPush([]byte("list"), []byte("a"), []byte("b"), []byte("c"))
+	val, err := rds.LPop([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+	val, err = rds.RPop([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	val, err = rds.RPop([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	// 弹出所有元素之后 key 不存在
+	_, err = rds.LPop([]byte("list"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	_, err = rds.Type([]byte("list"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	// 可以继续使用
+	_, _ = rds.LPush([]byte("list"), []byte("x"))
+	val, err = rds.RPop([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("x"), val)
+}
+
+func TestRedisDataStructure_LRange(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-lrange")
+	defer cleanup()
+
+	elements, err := rds.LRange([]byte("list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Nil(t, elements)
+
+	for _, e := range []string{"a", "b", "c", "d", "e"} {
+		_, err := rds.RPush([]byte("list"), []byte(e))
+		assert.Nil(t, err)
+	}
+	_, _ = rds.LPop([]byte("list"))
+
+	cases := []struct {
+		start, stop int64
+		expected    []string
+	}{
+		{0, -1, []string{"b", "c", "d", "e"}},
+		{1, 2, []string{"c", "d"}},
+		{-2, -1, []string{"d", "e"}},
+		{-100, 1, []string{"b", "c"}},
+		{2, 100, []string{"d", "e"}},
+		{3, 1, nil},
+		{10, 20, nil},
+	}
+	for _, c := range cases {
+		elements, err := rds.LRange([]byte("list"), c.start, c.stop)
+		assert.Nil(t, err)
+		var result []string
+		for _, e := range elements {
+			result = append(result, string(e))
+		}
+		assert.Equal(t, c.expected, result, "%d %d", c.start, c.stop)
+	}
+}
@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// 所有的 key 都带有一个字节的标识，元数据和数据分别存储，互相之间不会冲突
+const (
+	metaKeyTag byte = 'M'
+	dataKeyTag byte = 'D'
+)
+
+const (
+	maxMetadataSize   = 1 + binary.MaxVarintLen64*2 + binary.MaxVarintLen32
+	extraListMetaSize = binary.MaxVarintLen64 * 2
+
+	// List 的头尾下标从中间开始，两个方向都可以继续写入
+	initialListMark = math.MaxUint64 / 2
+)
+
+// 元数据
+//
+//	+----------+------------+------------+-----------+-----------+-----------+
+//	| dataType |   expire   |  version   |   size    |   head    |   tail    |
+//	+----------+------------+------------+-----------+-----------+-----------+
+//	   1 byte    varint(10)   varint(10)  uvarint(5)  uvarint(10) uvarint(10)
+//
+// head 和 tail 只有 List 类型才有
+type metadata struct {
+	dataType redisDataType // 数据类型
+	expire   int64         // 过期时间，0 表示不过期
+	version  int64         // 版本号，删除之后重新创建的 key 使用新的版本号，旧版本的数据不再可见
+	size     uint32        // 元素的数量
+	head     uint64        // List 第一个元素的下标
+	tail     uint64        // List 最后一个元素的下一个下标
+}
+
+func (md *metadata) encode() []byte {
+	var size = maxMetadataSize
+	if md.dataType == List {
+		size += extraListMetaSize
+	}
+	buf := make([]byte, size)
+
+	buf[0] = md.dataType
+	var index = 1
+	index += binary.PutVarint(buf[index:], md.expire)
+	index += binary.PutVarint(buf[index:], md.version)
+	index += binary.PutUvarint(buf[index:], uint64(md.size))
+
+	if md.dataType == List {
+		index += binary.PutUvarint(buf[index:], md.head)
+		index += binary.PutUvarint(buf[index:], md.tail)
+	}
+	return buf[:index]
+}
+
+func decodeMetadata(buf []byte) *metadata {
+	dataType := buf[0]
+	var index = 1
+	expire, n := binary.Varint(buf[index:])
+	index += n
+	version, n := binary.Varint(buf[index:])
+	index += n
+	size, n := binary.Uvarint(buf[index:])
+	index += n
+
+	var head, tail uint64
+	if dataType == List {
+		head, n = binary.Uvarint(buf[index:])
+		index += n
+		tail, _ = binary.Uvarint(buf[index:])
+	}
+
+	return &metadata{
+		dataType: dataType,
+		expire:   expire,
+		version:  version,
+		size:     uint32(size),
+		head:     head,
+		tail:     tail,
+	}
+}
+
+// 元数据的 key
+//
+//	+-----+---------+
+//	| tag |   key   |
+//	+-----+---------+
+func encodeMetaKey(key []byte) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = metaKeyTag
+	copy(buf[1:], key)
+	return buf
+}
+
+// 数据的 key，同一个 key 同一个版本的数据都以相同的前缀开头，可以使用前缀遍历
+//
+//	+-----+-------------+---------+-----------+---------+
+//	| tag | key size    |   key   |  version  |   sub   |
+//	+-----+-------------+---------+-----------+---------+
+//	        uvarint(5)             8 bytes BE
+func encodeDataKey(key []byte, version int64, sub []byte) []byte {
+	prefix := dataKeyPrefix(key, version)
+	buf := make([]byte, len(prefix)+len(sub))
+	copy(buf, prefix)
+	copy(buf[len(prefix):], sub)
+	return buf
+}
+
+// 数据 key 的前缀
+func dataKeyPrefix(key []byte, version int64) []byte {
+	prefix := allVersionsDataKeyPrefix(key)
+	buf := make([]byte, len(prefix)+8)
+	copy(buf, prefix)
+	binary.BigEndian.PutUint64(buf[len(prefix):], uint64(version))
+	return buf
+}
+
+// 同一个 key 所有版本的数据 key 的公共前缀
+func allVersionsDataKeyPrefix(key []byte) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen32+len(key))
+	buf[0] = dataKeyTag
+	var index = 1
+	index += binary.PutUvarint(buf[index:], uint64(len(key)))
+	index += copy(buf[index:], key)
+	return buf[:index]
+}
+
+// 解析数据 key 中的 key 和版本号，格式不正确时返回 false
+func decodeDataKey(buf []byte) ([]byte, int64, bool) {
+	if len(buf) == 0 || buf[0] != dataKeyTag {
+		return nil, 0, false
+	}
+	keySize, n := binary.Uvarint(buf[1:])
+	if n <= 0 {
+		return nil, 0, false
+	}
+	index := 1 + n
+	if uint64(len(buf)-index) < keySize+8 {
+		return nil, 0, false
+	}
+	key := buf[index : index+int(keySize)]
+	version := int64(binary.BigEndian.Uint64(buf[index+int(keySize):]))
+	return key, version, true
+}
+
+// 保序的 float64 编码，编码之后的字节序和数值的大小顺序一致
+// 正数翻转符号位，负数翻转所有的位，-0 和 +0 统一编码为 +0
+func encodeFloat64(f float64) []byte {
+	if f == 0 {
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+func decodeFloat64(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
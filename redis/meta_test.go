@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestMetadata_Encode(t *testing.T) {
+	meta := &metadata{dataType: Hash, expire: 123, version: 456, size: 789}
+	assert.Equal(t, meta, decodeMetadata(meta.encode()))
+
+	listMeta := &metadata{dataType: List, version: 1, size: 2, head: initialListMark - 1, tail: initialListMark + 1}
+	assert.Equal(t, listMeta, decodeMetadata(listMeta.encode()))
+}
+
+func TestEncodeDataKey(t *testing.T) {
+	// key 带有长度，前缀不同的 key 之间不会冲突
+	k1 := encodeDataKey([]byte("a"), 1, []byte("bc"))
+	k2 := encodeDataKey([]byte("ab"), 1, []byte("c"))
+	assert.NotEqual(t, k1, k2)
+
+	prefix := dataKeyPrefix([]byte("a"), 1)
+	assert.Equal(t, prefix, k1[:len(prefix)])
+	assert.Equal(t, []byte("bc"), k1[len(prefix):])
+}
+
+func TestEncodeFloat64(t *testing.T) {
+	values := []float64{math.Inf(-1), -1e100, -2.5, -1, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 1, 2.5, 1e100, math.Inf(1)}
+	for i, v := range values {
+		assert.Equal(t, v, decodeFloat64(encodeFloat64(v)))
+		if i > 0 {
+			assert.Equal(t, -1, bytes.Compare(encodeFloat64(values[i-1]), encodeFloat64(v)))
+		}
+	}
+	assert.Equal(t, encodeFloat64(0), encodeFloat64(math.Copysign(0, -1)))
+}
@@ -0,0 +1,293 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"bytes"
+)
+
+// SAdd 添加成员，返回实际新增的数量
+func (rds *RedisDataStructure) SAdd(key []byte, members ...[]byte) (int, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return 0, err
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	added := make(map[string]bool)
+	for _, member := range members {
+		if added[string(member)] {
+			continue
+		}
+		dataKey := encodeDataKey(key, meta.version, member)
+		exist, err := rds.dataKeyExists(dataKey)
+		if err != nil {
+			return 0, err
+		}
+		if !exist {
+			_ = wb.Put(dataKey, nil)
+			added[string(member)] = true
+		}
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	// 元数据和数据一起写入
+	meta.size += uint32(len(added))
+	_ = wb.Put(encodeMetaKey(key), meta.encode())
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return len(added), nil
+}
+
+// SRem 删除成员，返回实际删除的数量
+func (rds *RedisDataStructure) SRem(key []byte, members ...[]byte) (int, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.existingMetadata(key, Set)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	removed := make(map[string]bool)
+	for _, member := range members {
+		if removed[string(member)] {
+			continue
+		}
+		dataKey := encodeDataKey(key, meta.version, member)
+		exist, err := rds.dataKeyExists(dataKey)
+		if err != nil {
+			return 0, err
+		}
+		if exist {
+			_ = wb.Delete(dataKey)
+			removed[string(member)] = true
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	// 所有的成员都被删除之后，删除元数据
+	meta.size -= uint32(len(removed))
+	if meta.size == 0 {
+		_ = wb.Delete(encodeMetaKey(key))
+	} else {
+		_ = wb.Put(encodeMetaKey(key), meta.encode())
+	}
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+// SIsMember 判断是否是集合的成员
+func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
+	meta, err := rds.existingMetadata(key, Set)
+	if err != nil || meta == nil {
+		return false, err
+	}
+	return rds.dataKeyExists(encodeDataKey(key, meta.version, member))
+}
+
+// SMembers 返回所有的成员，按照字节序排列
+func (rds *RedisDataStructure) SMembers(key []byte) ([][]byte, error) {
+	cursor, err := rds.newSetCursor(key)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.close()
+
+	var members [][]byte
+	for ; cursor.valid(); cursor.next() {
+		members = append(members, cursor.member())
+	}
+	return members, nil
+}
+
+// SCard 返回成员的数量
+func (rds *RedisDataStructure) SCard(key []byte) (uint32, error) {
+	meta, err := rds.existingMetadata(key, Set)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// SInter 返回所有集合的交集，按照字节序排列
+// 每个集合的成员按照字节序存储，同时遍历所有的集合，落后的集合直接 Seek 到当前最大的成员
+func (rds *RedisDataStructure) SInter(keys ...[]byte) ([][]byte, error) {
+	cursors, err := rds.newSetCursors(keys)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSetCursors(cursors)
+	if len(cursors) == 0 {
+		return nil, nil
+	}
+
+	var result [][]byte
+	for {
+		var max []byte
+		for _, c := range cursors {
+			if !c.valid() {
+				return result, nil
+			}
+			if max == nil || bytes.Compare(c.member(), max) > 0 {
+				max = c.member()
+			}
+		}
+
+		matched := true
+		for _, c := range cursors {
+			if !bytes.Equal(c.member(), max) {
+				c.seek(max)
+				matched = false
+			}
+		}
+		if matched {
+			result = append(result, max)
+			for _, c := range cursors {
+				c.next()
+			}
+		}
+	}
+}
+
+// SUnion 返回所有集合的并集，按照字节序排列
+func (rds *RedisDataStructure) SUnion(keys ...[]byte) ([][]byte, error) {
+	cursors, err := rds.newSetCursors(keys)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSetCursors(cursors)
+
+	var result [][]byte
+	for {
+		var min []byte
+		for _, c := range cursors {
+			if c.valid() && (min == nil || bytes.Compare(c.member(), min) < 0) {
+				min = c.member()
+			}
+		}
+		if min == nil {
+			return result, nil
+		}
+
+		result = append(result, min)
+		for _, c := range cursors {
+			if c.valid() && bytes.Equal(c.member(), min) {
+				c.next()
+			}
+		}
+	}
+}
+
+// SDiff 返回第一个集合中不属于其他集合的成员，按照字节序排列
+func (rds *RedisDataStructure) SDiff(keys ...[]byte) ([][]byte, error) {
+	cursors, err := rds.newSetCursors(keys)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSetCursors(cursors)
+	if len(cursors) == 0 {
+		return nil, nil
+	}
+
+	var result [][]byte
+	first := cursors[0]
+	for ; first.valid(); first.next() {
+		member := first.member()
+		found := false
+		for _, c := range cursors[1:] {
+			if c.valid() && bytes.Compare(c.member(), member) < 0 {
+				c.seek(member)
+			}
+			if c.valid() && bytes.Equal(c.member(), member) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, member)
+		}
+	}
+	return result, nil
+}
+
+// 按照字节序遍历一个集合的成员
+type setCursor struct {
+	iter   *bitcask.Iterator
+	prefix []byte
+}
+
+// 创建集合的遍历游标，key 不存在时遍历空集合
+func (rds *RedisDataStructure) newSetCursor(key []byte) (*setCursor, error) {
+	meta, err := rds.existingMetadata(key, Set)
+	if err != nil {
+		return nil, err
+	}
+	// 不存在的 key 使用一个不会有数据的版本号
+	var version int64 = -1
+	if meta != nil {
+		version = meta.version
+	}
+
+	prefix := dataKeyPrefix(key, version)
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	iter.Rewind()
+	return &setCursor{iter: iter, prefix: prefix}, nil
+}
+
+func (rds *RedisDataStructure) newSetCursors(keys [][]byte) ([]*setCursor, error) {
+	cursors := make([]*setCursor, 0, len(keys))
+	for _, key := range keys {
+		cursor, err := rds.newSetCursor(key)
+		if err != nil {
+			closeSetCursors(cursors)
+			return nil, err
+		}
+		cursors = append(cursors, cursor)
+	}
+	return cursors, nil
+}
+
+func closeSetCursors(cursors []*setCursor) {
+	for _, c := range cursors {
+		c.close()
+	}
+}
+
+func (c *setCursor) valid() bool {
+	return c.iter.Valid()
+}
+
+// 当前的成员，B+ 树索引的 key 在迭代器关闭之后失效，因此返回一份拷贝
+func (c *setCursor) member() []byte {
+	key := c.iter.Key()
+	member := make([]byte, len(key)-len(c.prefix))
+	copy(member, key[len(c.prefix):])
+	return member
+}
+
+func (c *setCursor) next() {
+	c.iter.Next()
+}
+
+// 跳转到第一个大于等于 member 的成员
+func (c *setCursor) seek(member []byte) {
+	key := make([]byte, len(c.prefix)+len(member))
+	copy(key, c.prefix)
+	copy(key[len(c.prefix):], member)
+	c.iter.Seek(key)
+}
+
+func (c *setCursor) close() {
+	c.iter.Close()
+}
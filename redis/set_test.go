@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func toStrings(members [][]byte) []string {
+	var result []string
+	for _, m := range members {
+		result = append(result, string(m))
+	}
+	return result
+}
+
+func TestRedisDataStructure_SAdd(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-sadd")
+	defer cleanup()
+
+	n, err := rds.SAdd([]byte("set"), []byte("b"), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	n, err = rds.SAdd([]byte("set"), []byte("a"), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	size, err := rds.SCard([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+
+	ok, err := rds.SIsMember([]byte("set"), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SIsMember([]byte("set"), []byte("x"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.SIsMember([]byte("not exist"), []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	members, err := rds.SMembers([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, toStrings(members))
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.SAdd([]byte("hash"), []byte("a"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_SRem(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-srem")
+	defer cleanup()
+
+	n, err := rds.SRem([]byte("set"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	_, _ = rds.SAdd([]byte("set"), []byte("a"), []byte("b"), []byte("c"))
+	n, err = rds.SRem([]byte("set"), []byte("a"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	members, err := rds.SMembers([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c"}, toStrings(members))
+
+	n, err = rds.SRem([]byte("set"), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	size, err := rds.SCard([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	members, err = rds.SMembers([]byte("set"))
+	assert.Nil(t, err)
+	assert.Nil(t, members)
+}
+
+func TestRedisDataStructure_SInter(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-sinter")
+	defer cleanup()
+
+	_, _ = rds.SAdd([]byte("s1"), []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("f"))
+	_, _ = rds.SAdd([]byte("s2"), []byte("b"), []byte("d"), []byte("e"), []byte("f"))
+	_, _ = rds.SAdd([]byte("s3"), []byte("a"), []byte("d"), []byte("f"), []byte("g"))
+	// 前缀相同的 key 不会互相影响
+	_, _ = rds.SAdd([]byte("s"), []byte("a"), []byte("d"))
+
+	members, err := rds.SInter([]byte("s1"), []byte("s2"), []byte("s3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "f"}, toStrings(members))
+
+	members, err = rds.SInter([]byte("s1"), []byte("not exist"))
+	assert.Nil(t, err)
+	assert.Nil(t, members)
+
+	members, err = rds.SUnion([]byte("s1"), []byte("s2"), []byte("not exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, toStrings(members))
+
+	members, err = rds.SDiff([]byte("s1"), []byte("s2"), []byte("s3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c"}, toStrings(members))
+
+	members, err = rds.SDiff([]byte("s1"), []byte("s"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "f"}, toStrings(members))
+
+	_, _ = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	_, err = rds.SUnion([]byte("s1"), []byte("hash"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
@@ -0,0 +1,269 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"bytes"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWrongTypeOperation  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrScoreIsNaN          = errors.New("the score is not a number")
+	ErrBitOffsetOutOfRange = errors.New("bit offset is not an integer or out of range")
+	ErrUnknownBitOperation = errors.New("unknown bit operation")
+	ErrBitOpArgs           = errors.New("BITOP requires at least one source key and BITOP NOT requires exactly one")
+)
+
+type redisDataType = byte
+
+const (
+	Hash redisDataType = iota + 1
+	List
+	Set
+	ZSet
+	Bitmap
+)
+
+// RedisDataStructure Redis 数据结构服务
+// 每个 key 对应一条元数据，集合中的每个元素对应一条数据，修改元数据和数据时使用 WriteBatch 保证原子性
+type RedisDataStructure struct {
+	db          *bitcask.DB
+	mu          *sync.Mutex // 修改操作需要先读取元数据再写入，加锁保证串行执行
+	lastVersion int64
+	sweepStop   chan struct{} // 通知清理旧版本数据的协程退出
+	sweepDone   chan struct{} // 清理旧版本数据的协程已经退出
+}
+
+// 清理旧版本的数据以及 BitOp 写入结果时，每个 WriteBatch 最多写入的数据条数，避免一次提交过多的数据
+const dataBatchNum = 1000
+
+// NewRedisDataStructure 初始化 Redis 数据结构服务
+func NewRedisDataStructure(options bitcask.Options) (*RedisDataStructure, error) {
+	db, err := bitcask.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	rds := &RedisDataStructure{db: db, mu: new(sync.Mutex)}
+	// 开启自动 merge 时使用相同的间隔在后台清理旧版本的数据，清理之后的空间由 merge 回收
+	if options.AutoMergeInterval > 0 {
+		rds.sweepStop = make(chan struct{})
+		rds.sweepDone = make(chan struct{})
+		go rds.autoSweep(options.AutoMergeInterval)
+	}
+	return rds, nil
+}
+
+// Close 停止后台清理，关闭底层的数据库
+func (rds *RedisDataStructure) Close() error {
+	if rds.sweepStop != nil {
+		close(rds.sweepStop)
+		<-rds.sweepDone
+		rds.sweepStop = nil
+	}
+	return rds.db.Close()
+}
+
+// Del 删除 key，只需要删除元数据，旧版本的数据不再可见，由 SweepStaleData 清理
+func (rds *RedisDataStructure) Del(key []byte) error {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+	return rds.db.Delete(encodeMetaKey(key))
+}
+
+// SweepStaleData 删除已经不可见的旧版本数据，返回删除的数据条数
+// Del、过期以及 BitOp 覆盖 key 时只修改元数据，旧版本的数据仍然占用空间，清理之后 merge 时才能回收
+// 每次查找并删除最多 dataBatchNum 条数据，批次之间不会阻塞其他的修改操作
+func (rds *RedisDataStructure) SweepStaleData() (int, error) {
+	return rds.sweep(nil)
+}
+
+// 分批清理旧版本的数据，stop 关闭之后在下一批开始之前返回
+func (rds *RedisDataStructure) sweep(stop chan struct{}) (int, error) {
+	var swept int
+	start := []byte{dataKeyTag}
+	for start != nil {
+		select {
+		case <-stop:
+			return swept, nil
+		default:
+		}
+		dataKeys, next, err := rds.findStaleDataKeys(start)
+		if err != nil {
+			return swept, err
+		}
+		n, err := rds.deleteStaleDataKeys(dataKeys)
+		swept += n
+		if err != nil {
+			return swept, err
+		}
+		start = next
+	}
+	return swept, nil
+}
+
+// 从 start 开始查找最多 dataBatchNum 条旧版本的数据，同时返回下一次查找的起点，已经查找完毕时为 nil
+// 迭代器关闭之后才删除数据，B+ 树索引在迭代的过程中不能写入
+func (rds *RedisDataStructure) findStaleDataKeys(start []byte) ([][]byte, []byte, error) {
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: []byte{dataKeyTag}})
+	defer iter.Close()
+
+	var dataKeys [][]byte
+	var lastKey []byte
+	var lastMeta *metadata
+	var hasLast bool
+	for iter.Seek(start); iter.Valid(); iter.Next() {
+		if len(dataKeys) == dataBatchNum {
+			return dataKeys, copyBytes(iter.Key()), nil
+		}
+		key, version, ok := decodeDataKey(iter.Key())
+		if !ok {
+			continue
+		}
+		// 同一个 key 的数据是连续的，只需要读取一次元数据
+		if !hasLast || !bytes.Equal(key, lastKey) {
+			meta, err := rds.getMetadata(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			lastKey, lastMeta, hasLast = copyBytes(key), meta, true
+		}
+		if lastMeta == nil || lastMeta.version != version {
+			dataKeys = append(dataKeys, copyBytes(iter.Key()))
+		}
+	}
+	return dataKeys, nil, nil
+}
+
+// 加锁之后重新检查元数据再删除，查找之后重新创建的 key 使用新的版本号，数据不会被误删
+func (rds *RedisDataStructure) deleteStaleDataKeys(dataKeys [][]byte) (int, error) {
+	if len(dataKeys) == 0 {
+		return 0, nil
+	}
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	var count int
+	for _, dataKey := range dataKeys {
+		key, version, _ := decodeDataKey(dataKey)
+		meta, err := rds.getMetadata(key)
+		if err != nil {
+			return 0, err
+		}
+		if meta != nil && meta.version == version {
+			continue
+		}
+		if err := wb.Delete(dataKey); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// 后台协程，定期清理旧版本的数据
+func (rds *RedisDataStructure) autoSweep(interval time.Duration) {
+	defer close(rds.sweepDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := rds.sweep(rds.sweepStop); err != nil {
+				log.Printf("failed to sweep stale data: %v\n", err)
+			}
+		case <-rds.sweepStop:
+			return
+		}
+	}
+}
+
+func copyBytes(buf []byte) []byte {
+	result := make([]byte, len(buf))
+	copy(result, buf)
+	return result
+}
+
+// Type 返回 key 的数据类型，key 不存在时返回 bitcask.ErrKeyNotFound
+func (rds *RedisDataStructure) Type(key []byte) (redisDataType, error) {
+	meta, err := rds.getMetadata(key)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		return 0, bitcask.ErrKeyNotFound
+	}
+	return meta.dataType, nil
+}
+
+// 读取 key 的元数据，key 不存在或者已经过期时返回 nil
+func (rds *RedisDataStructure) getMetadata(key []byte) (*metadata, error) {
+	buf, err := rds.db.Get(encodeMetaKey(key))
+	if err != nil {
+		if err == bitcask.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	meta := decodeMetadata(buf)
+	if meta.expire != 0 && meta.expire <= time.Now().UnixNano() {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+// 查找 key 的元数据，key 不存在时初始化一个新的版本，数据类型不一致时返回 ErrWrongTypeOperation
+// 新的元数据在写入第一个元素时才会保存
+func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType) (*metadata, error) {
+	meta, err := rds.getMetadata(key)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		if meta.dataType != dataType {
+			return nil, ErrWrongTypeOperation
+		}
+		return meta, nil
+	}
+	meta = &metadata{
+		dataType: dataType,
+		version:  rds.nextVersion(),
+	}
+	if dataType == List {
+		meta.head = initialListMark
+		meta.tail = initialListMark
+	}
+	return meta, nil
+}
+
+// 读取已经存在的 key 的元数据，key 不存在时返回 nil，数据类型不一致时返回 ErrWrongTypeOperation
+func (rds *RedisDataStructure) existingMetadata(key []byte, dataType redisDataType) (*metadata, error) {
+	meta, err := rds.getMetadata(key)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	if meta.dataType != dataType {
+		return nil, ErrWrongTypeOperation
+	}
+	return meta, nil
+}
+
+// 生成新的版本号，使用当前时间并保证单调递增。该方法必须持有互斥锁
+func (rds *RedisDataStructure) nextVersion() int64 {
+	version := time.Now().UnixNano()
+	if version <= rds.lastVersion {
+		version = rds.lastVersion + 1
+	}
+	rds.lastVersion = version
+	return version
+}
@@ -0,0 +1,253 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"bytes"
+	"math"
+)
+
+// ZSet 的每个成员存储两条数据，都以 key 和版本号为前缀
+//   - 成员 -> 分数：	prefix | 'm' | member，value 为编码之后的分数
+//   - 分数索引：		prefix | 's' | score | member，value 为空
+//
+// 分数使用保序的编码，分数索引的 key 按照分数从小到大排列，分数相同时按照成员的字节序排列
+const (
+	zsetMemberTag byte = 'm'
+	zsetScoreTag  byte = 's'
+)
+
+// ZMember ZSet 中的成员和分数
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ZAdd 添加成员或者更新成员的分数，返回成员是否是新增的
+func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	if math.IsNaN(score) {
+		return false, ErrScoreIsNaN
+	}
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return false, err
+	}
+
+	memberKey := encodeZSetMemberKey(key, meta.version, member)
+	oldScore, err := rds.db.Get(memberKey)
+	if err != nil && err != bitcask.ErrKeyNotFound {
+		return false, err
+	}
+	exist := err == nil
+	if exist && bytes.Equal(oldScore, encodeFloat64(score)) {
+		return false, nil
+	}
+
+	// 元数据、成员和分数索引一起写入，分数变化时删除旧的分数索引
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	if exist {
+		_ = wb.Delete(encodeZSetScoreKey(key, meta.version, decodeFloat64(oldScore), member))
+	} else {
+		meta.size++
+		_ = wb.Put(encodeMetaKey(key), meta.encode())
+	}
+	_ = wb.Put(memberKey, encodeFloat64(score))
+	_ = wb.Put(encodeZSetScoreKey(key, meta.version, score, member), nil)
+	if err := wb.Commit(); err != nil {
+		return false, err
+	}
+	return !exist, nil
+}
+
+// ZScore 返回成员的分数，成员不存在时返回 bitcask.ErrKeyNotFound
+func (rds *RedisDataStructure) ZScore(key, member []byte) (float64, error) {
+	meta, err := rds.existingMetadata(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		return 0, bitcask.ErrKeyNotFound
+	}
+	score, err := rds.db.Get(encodeZSetMemberKey(key, meta.version, member))
+	if err != nil {
+		return 0, err
+	}
+	return decodeFloat64(score), nil
+}
+
+// ZRem 删除成员，返回实际删除的数量
+func (rds *RedisDataStructure) ZRem(key []byte, members ...[]byte) (int, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.existingMetadata(key, ZSet)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	removed := make(map[string]bool)
+	for _, member := range members {
+		if removed[string(member)] {
+			continue
+		}
+		memberKey := encodeZSetMemberKey(key, meta.version, member)
+		score, err := rds.db.Get(memberKey)
+		if err == bitcask.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		_ = wb.Delete(memberKey)
+		_ = wb.Delete(encodeZSetScoreKey(key, meta.version, decodeFloat64(score), member))
+		removed[string(member)] = true
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	// 所有的成员都被删除之后，删除元数据
+	meta.size -= uint32(len(removed))
+	if meta.size == 0 {
+		_ = wb.Delete(encodeMetaKey(key))
+	} else {
+		_ = wb.Put(encodeMetaKey(key), meta.encode())
+	}
+	if err := wb.Commit(); err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+// ZCard 返回成员的数量
+func (rds *RedisDataStructure) ZCard(key []byte) (uint32, error) {
+	meta, err := rds.existingMetadata(key, ZSet)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// ZRange 按照分数从小到大的顺序，返回排名在 [start, stop] 之间的成员，负数表示从最后一名开始计数
+func (rds *RedisDataStructure) ZRange(key []byte, start, stop int64) ([]*ZMember, error) {
+	meta, err := rds.existingMetadata(key, ZSet)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	size := int64(meta.size)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+
+	prefix := zsetScorePrefix(key, meta.version)
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	defer iter.Close()
+	var result []*ZMember
+	var rank int64
+	for iter.Rewind(); iter.Valid() && rank <= stop; iter.Next() {
+		if rank >= start {
+			result = append(result, decodeZSetScoreKey(iter.Key(), len(prefix)))
+		}
+		rank++
+	}
+	return result, nil
+}
+
+// ZRangeByScore 按照分数从小到大的顺序，返回分数在 [min, max] 之间的成员
+func (rds *RedisDataStructure) ZRangeByScore(key []byte, min, max float64) ([]*ZMember, error) {
+	if math.IsNaN(min) || math.IsNaN(max) {
+		return nil, ErrScoreIsNaN
+	}
+	meta, err := rds.existingMetadata(key, ZSet)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	// 从分数为 min 的第一个索引开始遍历，遇到大于 max 的分数时结束
+	prefix := zsetScorePrefix(key, meta.version)
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: prefix})
+	defer iter.Close()
+	var result []*ZMember
+	for iter.Seek(append(prefix, encodeFloat64(min)...)); iter.Valid(); iter.Next() {
+		zm := decodeZSetScoreKey(iter.Key(), len(prefix))
+		if zm.Score > max {
+			break
+		}
+		result = append(result, zm)
+	}
+	return result, nil
+}
+
+// ZRank 返回成员按照分数从小到大的排名，从 0 开始，成员不存在时返回 bitcask.ErrKeyNotFound
+func (rds *RedisDataStructure) ZRank(key, member []byte) (int64, error) {
+	meta, err := rds.existingMetadata(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	if meta == nil {
+		return 0, bitcask.ErrKeyNotFound
+	}
+	score, err := rds.db.Get(encodeZSetMemberKey(key, meta.version, member))
+	if err != nil {
+		return 0, err
+	}
+
+	// 统计分数索引中排在该成员之前的数量
+	target := encodeZSetScoreKey(key, meta.version, decodeFloat64(score), member)
+	iter := rds.db.NewIterator(bitcask.IteratorOptions{Prefix: zsetScorePrefix(key, meta.version)})
+	defer iter.Close()
+	var rank int64
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if bytes.Equal(iter.Key(), target) {
+			return rank, nil
+		}
+		rank++
+	}
+	return 0, bitcask.ErrKeyNotFound
+}
+
+func encodeZSetMemberKey(key []byte, version int64, member []byte) []byte {
+	sub := make([]byte, 1+len(member))
+	sub[0] = zsetMemberTag
+	copy(sub[1:], member)
+	return encodeDataKey(key, version, sub)
+}
+
+func encodeZSetScoreKey(key []byte, version int64, score float64, member []byte) []byte {
+	sub := make([]byte, 1+8+len(member))
+	sub[0] = zsetScoreTag
+	copy(sub[1:], encodeFloat64(score))
+	copy(sub[9:], member)
+	return encodeDataKey(key, version, sub)
+}
+
+// 分数索引的前缀
+func zsetScorePrefix(key []byte, version int64) []byte {
+	return encodeDataKey(key, version, []byte{zsetScoreTag})
+}
+
+// 从分数索引的 key 中解析出分数和成员
+func decodeZSetScoreKey(scoreKey []byte, prefixLen int) *ZMember {
+	member := make([]byte, len(scoreKey)-prefixLen-8)
+	copy(member, scoreKey[prefixLen+8:])
+	return &ZMember{
+		Member: member,
+		Score:  decodeFloat64(scoreKey[prefixLen : prefixLen+8]),
+	}
+}
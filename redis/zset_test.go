@@ -0,0 +1,107 @@
+package redis
+
+import (
+	bitcask "bitcask-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func zmembers(zms []*ZMember) []string {
+	var result []string
+	for _, zm := range zms {
+		result = append(result, string(zm.Member))
+	}
+	return result
+}
+
+func TestRedisDataStructure_ZAdd(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-zadd")
+	defer cleanup()
+
+	ok, err := rds.ZAdd([]byte("board"), 100, []byte("alice"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.ZAdd([]byte("board"), 50, []byte("bob"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// 更新分数
+	ok, err = rds.ZAdd([]byte("board"), 10, []byte("alice"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	score, err := rds.ZScore([]byte("board"), []byte("alice"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(10), score)
+	_, err = rds.ZScore([]byte("board"), []byte("not exist"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	size, err := rds.ZCard([]byte("board"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+
+	// 旧的分数索引已经删除
+	zms, err := rds.ZRange([]byte("board"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, zmembers(zms))
+	assert.Equal(t, float64(10), zms[0].Score)
+}
+
+func TestRedisDataStructure_ZRem(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-zrem")
+	defer cleanup()
+
+	_, _ = rds.ZAdd([]byte("board"), 1, []byte("a"))
+	_, _ = rds.ZAdd([]byte("board"), 2, []byte("b"))
+	n, err := rds.ZRem([]byte("board"), []byte("a"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	zms, err := rds.ZRange([]byte("board"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b"}, zmembers(zms))
+
+	n, err = rds.ZRem([]byte("board"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = rds.Type([]byte("board"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
+
+func TestRedisDataStructure_ZRange(t *testing.T) {
+	rds, cleanup := openRedis(t, "bitcask-go-redis-zrange")
+	defer cleanup()
+
+	scores := map[string]float64{"a": -1.5, "b": 0, "c": 2, "d": 2, "e": 100}
+	for member, score := range scores {
+		_, err := rds.ZAdd([]byte("board"), score, []byte(member))
+		assert.Nil(t, err)
+	}
+
+	zms, err := rds.ZRange([]byte("board"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, zmembers(zms))
+	zms, err = rds.ZRange([]byte("board"), 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c"}, zmembers(zms))
+	zms, err = rds.ZRange([]byte("board"), -2, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "e"}, zmembers(zms))
+
+	zms, err = rds.ZRangeByScore([]byte("board"), -2, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, zmembers(zms))
+	zms, err = rds.ZRangeByScore([]byte("board"), 0.5, 99)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "d"}, zmembers(zms))
+	zms, err = rds.ZRangeByScore([]byte("board"), 101, 200)
+	assert.Nil(t, err)
+	assert.Nil(t, zms)
+
+	rank, err := rds.ZRank([]byte("board"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), rank)
+	rank, err = rds.ZRank([]byte("board"), []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), rank)
+	_, err = rds.ZRank([]byte("board"), []byte("x"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+}
@@ -0,0 +1,343 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bufio"
+	"encoding/gob"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 主从复制：follower 连接到 primary 之后发送自己已经同步到的位置，primary 从该位置开始发送数据文件中的数据
+// follower 没有同步过，或者该位置所在的数据文件已经被 merge 删除时，primary 先发送一份 Backup 的快照
+// 同步的位置是 primary 数据文件中的位置，follower 落后的字节数随数据和心跳一起发送
+
+const (
+	replicationBatchSize         = 512         // 每条消息最多包含的数据条数
+	replicationChunkSize         = 1024 * 1024 // 发送快照文件时每条消息的大小
+	replicationPollInterval      = 10 * time.Millisecond
+	replicationHeartbeatInterval = time.Second
+	replicationReadTimeout       = 5 * replicationHeartbeatInterval
+)
+
+// 主从复制的消息类型
+const (
+	replicationHello byte = iota + 1
+	replicationSnapshotFile
+	replicationSnapshotEnd
+	replicationRecords
+	replicationHeartbeat
+)
+
+// 主从复制的消息，使用 gob 编码
+type replicationMessage struct {
+	Type     byte
+	Pos      LogPosition // hello：follower 同步到的位置；快照结束：快照对应的位置；心跳：primary 最新的位置
+	HasPos   bool
+	FileName string
+	FileData []byte
+	Records  []*replicationRecord
+	LagBytes int64 // 发送完这条消息之后 primary 还没有发送的数据量
+}
+
+// 数据文件中的一条数据
+type replicationRecord struct {
+	Key    []byte // 带有事务序列号的 key
+	Value  []byte
+	Type   data.LogRecordType
+	Expire int64
+	Pos    data.LogRecordPos // 在 primary 数据文件中的位置
+}
+
+// FollowerStat primary 记录的 follower 同步状态
+type FollowerStat struct {
+	Addr     string      // follower 的地址
+	Pos      LogPosition // 已经发送到的位置
+	LagBytes int64       // 还没有发送的数据量，字节为单位
+}
+
+// Primary 主从复制的 primary，将数据文件中的数据发送给 follower
+type Primary struct {
+	db        *DB
+	mu        sync.Mutex
+	listener  net.Listener
+	followers map[net.Conn]*FollowerStat
+	closed    bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewPrimary 初始化 primary
+func NewPrimary(db *DB) *Primary {
+	return &Primary{
+		db:        db,
+		followers: make(map[net.Conn]*FollowerStat),
+		done:      make(chan struct{}),
+	}
+}
+
+// ListenAndServe 监听 TCP 地址并处理 follower 的连接
+func (p *Primary) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(listener)
+}
+
+// Serve 在 listener 上接收 follower 的连接，直到 Close 被调用
+func (p *Primary) Serve(listener net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = listener.Close()
+		return net.ErrClosed
+	}
+	p.listener = listener
+	p.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		p.followers[conn] = &FollowerStat{Addr: conn.RemoteAddr().String()}
+		p.wg.Add(1)
+		p.mu.Unlock()
+
+		go p.handleConn(conn)
+	}
+}
+
+// Addr 返回监听的地址，服务没有启动时返回 nil
+func (p *Primary) Addr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+// Followers 返回所有已连接的 follower 的同步状态
+func (p *Primary) Followers() []FollowerStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]FollowerStat, 0, len(p.followers))
+	for _, stat := range p.followers {
+		stats = append(stats, *stat)
+	}
+	return stats
+}
+
+// Close 停止接收新的连接，断开所有的 follower 并等待处理协程退出
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var err error
+	if p.listener != nil {
+		err = p.listener.Close()
+	}
+	for conn := range p.followers {
+		_ = conn.Close()
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return err
+}
+
+// 处理一个 follower 的连接
+func (p *Primary) handleConn(conn net.Conn) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.followers, conn)
+		p.mu.Unlock()
+		_ = conn.Close()
+		p.wg.Done()
+	}()
+
+	if err := p.serveFollower(conn); err != nil && err != io.EOF {
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if !closed {
+			log.Printf("replication to %s stopped: %v\n", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+func (p *Primary) serveFollower(conn net.Conn) error {
+	writer := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(writer)
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	send := func(msg *replicationMessage) error {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(replicationReadTimeout))
+	var hello replicationMessage
+	if err := dec.Decode(&hello); err != nil {
+		return err
+	}
+	if hello.Type != replicationHello {
+		return ErrReplicationProtocol
+	}
+
+	// 从 follower 同步到的位置开始发送，位置已经失效时先发送快照
+	var reader *LogReader
+	var err error
+	if hello.HasPos {
+		reader, err = p.db.newLogReader(&hello.Pos, true)
+	}
+	if !hello.HasPos || err == ErrInvalidLogPosition {
+		reader, err = p.sendSnapshot(send)
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	lastSend := time.Now()
+	for {
+		records := make([]*replicationRecord, 0, replicationBatchSize)
+		for len(records) < replicationBatchSize {
+			entry, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			records = append(records, &replicationRecord{
+				Key:    logRecordKeyWithSeq(entry.Key, entry.SeqNo),
+				Value:  entry.Value,
+				Type:   entry.Type,
+				Expire: entry.Expire,
+				Pos:    entry.Pos,
+			})
+		}
+
+		// 没有新的数据时定期发送心跳
+		if len(records) == 0 && time.Since(lastSend) < replicationHeartbeatInterval {
+			select {
+			case <-p.done:
+				return nil
+			case <-time.After(replicationPollInterval):
+			}
+			continue
+		}
+
+		lagBytes, err := reader.remaining()
+		if err != nil {
+			return err
+		}
+		msg := &replicationMessage{Type: replicationHeartbeat, Pos: reader.Pos(), LagBytes: lagBytes}
+		if len(records) > 0 {
+			msg.Type, msg.Records = replicationRecords, records
+		}
+		if err := send(msg); err != nil {
+			return err
+		}
+		lastSend = time.Now()
+
+		p.mu.Lock()
+		if stat := p.followers[conn]; stat != nil {
+			stat.Pos, stat.LagBytes = msg.Pos, lagBytes
+		}
+		p.mu.Unlock()
+	}
+}
+
+// 发送数据库的快照，返回从快照之后开始读取数据的 LogReader
+// 先创建 LogReader 再备份，备份中可能包含 LogReader 之后的少量数据，follower 会按照顺序重复写入，结果是一致的
+func (p *Primary) sendSnapshot(send func(*replicationMessage) error) (*LogReader, error) {
+	reader, err := p.db.newLogReader(nil, true)
+	if err != nil {
+		return nil, err
+	}
+	pos := reader.Pos()
+
+	backupDir, err := os.MkdirTemp("", "bitcask-go-replication")
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	defer os.RemoveAll(backupDir)
+	if err := p.db.Backup(backupDir); err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := sendSnapshotFile(send, backupDir, entry.Name()); err != nil {
+			_ = reader.Close()
+			return nil, err
+		}
+	}
+	if err := send(&replicationMessage{Type: replicationSnapshotEnd, Pos: pos}); err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// 分块发送快照中的一个文件，空文件也会发送一条消息
+func sendSnapshotFile(send func(*replicationMessage) error, dir string, name string) error {
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, replicationChunkSize)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 || first {
+			msg := &replicationMessage{Type: replicationSnapshotFile, FileName: name, FileData: buf[:n]}
+			if sendErr := send(msg); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
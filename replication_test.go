@@ -0,0 +1,213 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// 等待条件满足，超时之后测试失败
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not satisfied before timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 等待 follower 读取到 key 对应的 value，value 为空表示 key 不存在
+func waitForValue(t *testing.T, db *DB, key []byte, value []byte) {
+	waitFor(t, func() bool {
+		val, err := db.Get(key)
+		if value == nil {
+			return err == ErrKeyNotFound
+		}
+		return err == nil && string(val) == string(value)
+	})
+}
+
+func startPrimary(t *testing.T, db *DB) (*Primary, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	primary := NewPrimary(db)
+	go func() {
+		_ = primary.Serve(listener)
+	}()
+	return primary, listener.Addr().String()
+}
+
+func TestReplication(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-primary")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// follower 连接之前已经存在的数据通过快照同步
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	primary, addr := startPrimary(t, db)
+	defer primary.Close()
+
+	followerOpts := DefaultOptions
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-follower")
+	followerOpts.DirPath = followerDir
+	follower, err := OpenFollower(followerOpts, addr)
+	assert.Nil(t, err)
+	defer os.RemoveAll(followerDir)
+
+	waitForValue(t, follower.DB(), utils.GetTestKey(99), utils.GetTestKey(99))
+	waitFor(t, func() bool { return follower.Stat().Snapshots == 1 })
+	assert.True(t, follower.Stat().Connected)
+	assert.Equal(t, 1, len(primary.Followers()))
+
+	// 之后的写入、删除和事务实时同步
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+
+	waitForValue(t, follower.DB(), []byte("k2"), []byte("v2"))
+	waitForValue(t, follower.DB(), utils.GetTestKey(1), nil)
+	val, err := follower.DB().Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	_, err = follower.DB().Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 100, len(follower.DB().ListKeys()))
+	assert.Equal(t, int64(0), follower.Stat().LagBytes)
+
+	// follower 只能读取
+	assert.Equal(t, ErrReadOnly, follower.DB().Put([]byte("k3"), []byte("v3")))
+	assert.Equal(t, ErrReadOnly, follower.DB().Delete([]byte("k1")))
+
+	// 重启之后从保存的位置继续同步，不需要快照
+	assert.Nil(t, follower.Close())
+	assert.Nil(t, db.Put([]byte("k3"), []byte("v3")))
+	follower, err = OpenFollower(followerOpts, addr)
+	assert.Nil(t, err)
+	waitForValue(t, follower.DB(), []byte("k3"), []byte("v3"))
+	assert.Equal(t, 0, follower.Stat().Snapshots)
+
+	// 提升为 primary 之后可以写入
+	promoted, err := follower.Promote()
+	assert.Nil(t, err)
+	defer promoted.Close()
+	assert.Nil(t, promoted.Put([]byte("k4"), []byte("v4")))
+	_, err = follower.Promote()
+	assert.Equal(t, ErrFollowerClosed, err)
+}
+
+// follower 同步到的数据文件被 merge 删除之后，重新同步快照
+func TestReplication_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	primary, addr := startPrimary(t, db)
+	defer primary.Close()
+
+	followerOpts := opts
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-snapshot-follower")
+	followerOpts.DirPath = followerDir
+	follower, err := OpenFollower(followerOpts, addr)
+	assert.Nil(t, err)
+	defer os.RemoveAll(followerDir)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%10), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Put([]byte("last"), []byte("1")))
+	waitForValue(t, follower.DB(), []byte("last"), []byte("1"))
+	assert.Nil(t, follower.Close())
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%10), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Put([]byte("last"), []byte("2")))
+	assert.Nil(t, db.Merge())
+
+	follower, err = OpenFollower(followerOpts, addr)
+	assert.Nil(t, err)
+	defer follower.Close()
+	waitForValue(t, follower.DB(), []byte("last"), []byte("2"))
+	waitFor(t, func() bool { return follower.Stat().Snapshots == 1 })
+	for i := 0; i < 10; i++ {
+		expected, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		val, err := follower.DB().Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+
+	// 安装快照之后继续同步
+	assert.Nil(t, db.Put([]byte("last"), []byte("3")))
+	waitForValue(t, follower.DB(), []byte("last"), []byte("3"))
+}
+
+// merge 重写了 follower 同步到的数据文件，文件 id 不变，保存的位置不再可用，需要重新同步快照
+func TestReplication_MergeRewritesFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-replication-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	primary, addr := startPrimary(t, db)
+	defer primary.Close()
+
+	followerOpts := opts
+	followerDir, _ := os.MkdirTemp("", "bitcask-go-replication-merge-follower")
+	followerOpts.DirPath = followerDir
+	follower, err := OpenFollower(followerOpts, addr)
+	assert.Nil(t, err)
+	defer os.RemoveAll(followerDir)
+
+	// 所有数据的长度相同，merge 之后同一个文件中相同的 offset 仍然是一条数据的开始
+	value := utils.RandomValue(128)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	waitForValue(t, follower.DB(), utils.GetTestKey(9), value)
+	assert.Nil(t, follower.Close())
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Merge())
+	_, err = os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+
+	follower, err = OpenFollower(followerOpts, addr)
+	assert.Nil(t, err)
+	defer follower.Close()
+	waitForValue(t, follower.DB(), utils.GetTestKey(99), mustGet(t, db, utils.GetTestKey(99)))
+	waitFor(t, func() bool { return follower.Stat().Snapshots == 1 })
+	for i := 0; i < 100; i++ {
+		val, err := follower.DB().Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, mustGet(t, db, utils.GetTestKey(i)), val)
+	}
+}
+
+func mustGet(t *testing.T, db *DB, key []byte) []byte {
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	return val
+}
@@ -0,0 +1,339 @@
+package resp
+
+import (
+	bitcask "bitcask-go"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type commandHandler func(db *bitcask.DB, w *respWriter, args [][]byte)
+
+// 命令的处理方法以及参数数量
+// arity 包含命令名本身，为正数时参数数量必须相等，为负数时参数数量至少为 -arity，和 Redis 的约定一致
+type command struct {
+	handler commandHandler
+	arity   int
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"ping":    {handler: ping, arity: -1},
+		"get":     {handler: get, arity: 2},
+		"set":     {handler: set, arity: -3},
+		"del":     {handler: del, arity: -2},
+		"exists":  {handler: exists, arity: -2},
+		"keys":    {handler: keys, arity: 2},
+		"scan":    {handler: scan, arity: -2},
+		"mget":    {handler: mget, arity: -2},
+		"mset":    {handler: mset, arity: -3},
+		"expire":  {handler: expire, arity: 3},
+		"ttl":     {handler: ttl, arity: 2},
+		"command": {handler: commandCmd, arity: -1},
+	}
+}
+
+const scanDefaultCount = 10
+
+func writeDBError(w *respWriter, err error) {
+	w.writeError("ERR " + err.Error())
+}
+
+// 判断以 unit 为单位的过期时间 n 是否有效
+// 过期时间点以纳秒时间戳保存，n 必须大于 0，并且过期时间点不能超出 int64 的范围
+func validExpireTime(n int64, unit time.Duration) bool {
+	if n <= 0 || n > math.MaxInt64/int64(unit) {
+		return false
+	}
+	return n*int64(unit) <= math.MaxInt64-time.Now().UnixNano()
+}
+
+// 判断 key 是否存在，TTL 只需要查询索引，不需要读取数据文件
+func keyExists(db *bitcask.DB, key []byte) (bool, error) {
+	_, err := db.TTL(key)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// PING [message]
+func ping(_ *bitcask.DB, w *respWriter, args [][]byte) {
+	switch len(args) {
+	case 0:
+		w.writeSimpleString("PONG")
+	case 1:
+		w.writeBulk(args[0])
+	default:
+		w.writeError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// GET key
+func get(db *bitcask.DB, w *respWriter, args [][]byte) {
+	value, err := db.Get(args[0])
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		w.writeBulk(nil)
+		return
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	w.writeBulk(value)
+}
+
+// SET key value [EX seconds | PX milliseconds]
+func set(db *bitcask.DB, w *respWriter, args [][]byte) {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if (opt != "ex" && opt != "px") || ttl != 0 || i+1 >= len(args) {
+			w.writeError("ERR syntax error")
+			return
+		}
+		unit := time.Second
+		if opt == "px" {
+			unit = time.Millisecond
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil || !validExpireTime(n, unit) {
+			w.writeError("ERR invalid expire time in 'set' command")
+			return
+		}
+		ttl = time.Duration(n) * unit
+		i++
+	}
+
+	var err error
+	if ttl > 0 {
+		err = db.PutWithTTL(key, value, ttl)
+	} else {
+		err = db.Put(key, value)
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.writeSimpleString("OK")
+}
+
+// DEL key [key ...]
+func del(db *bitcask.DB, w *respWriter, args [][]byte) {
+	var count int64
+	for _, key := range args {
+		ok, err := keyExists(db, key)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if !ok {
+			continue
+		}
+		if err := db.Delete(key); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		count++
+	}
+	w.writeInteger(count)
+}
+
+// EXISTS key [key ...]
+func exists(db *bitcask.DB, w *respWriter, args [][]byte) {
+	var count int64
+	for _, key := range args {
+		ok, err := keyExists(db, key)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if ok {
+			count++
+		}
+	}
+	w.writeInteger(count)
+}
+
+// KEYS pattern
+func keys(db *bitcask.DB, w *respWriter, args [][]byte) {
+	pattern := args[0]
+	var result [][]byte
+	iter := db.NewIterator(bitcask.IteratorOptions{Prefix: literalPrefix(pattern)})
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if globMatch(pattern, iter.Key()) {
+			result = append(result, iter.Key())
+		}
+	}
+	w.writeBulkArray(result)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+// cursor 为 0 时从头开始遍历，否则是上一次遍历到的最后一个 key 的十六进制编码，从这个 key 之后继续遍历
+// 遍历期间写入的 key 是否返回取决于它和 cursor 的先后顺序
+func scan(db *bitcask.DB, w *respWriter, args [][]byte) {
+	var lastKey []byte
+	if cursor := string(args[0]); cursor != "0" {
+		var err error
+		lastKey, err = hex.DecodeString(cursor)
+		if err != nil || len(lastKey) == 0 {
+			w.writeError("ERR invalid cursor")
+			return
+		}
+	}
+	var pattern []byte
+	count := uint64(scanDefaultCount)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.writeError("ERR syntax error")
+			return
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			var err error
+			count, err = strconv.ParseUint(string(args[i+1]), 10, 64)
+			if err != nil || count == 0 {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	iter := db.NewIterator(bitcask.IteratorOptions{Prefix: literalPrefix(pattern)})
+	defer iter.Close()
+	if lastKey == nil {
+		iter.Rewind()
+	} else {
+		iter.Seek(lastKey)
+		if iter.Valid() && bytes.Equal(iter.Key(), lastKey) {
+			iter.Next()
+		}
+	}
+	var scanned uint64
+	var result [][]byte
+	for ; scanned < count && iter.Valid(); iter.Next() {
+		lastKey = iter.Key()
+		if pattern == nil || globMatch(pattern, lastKey) {
+			result = append(result, lastKey)
+		}
+		scanned++
+	}
+
+	next := "0"
+	if iter.Valid() {
+		next = hex.EncodeToString(lastKey)
+	}
+	w.writeArrayHeader(2)
+	w.writeBulk([]byte(next))
+	w.writeBulkArray(result)
+}
+
+// MGET key [key ...]
+func mget(db *bitcask.DB, w *respWriter, args [][]byte) {
+	values := make([][]byte, len(args))
+	for i, key := range args {
+		value, err := db.Get(key)
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		values[i] = value
+	}
+	w.writeBulkArray(values)
+}
+
+// MSET key value [key value ...]，使用 WriteBatch 原子写入
+func mset(db *bitcask.DB, w *respWriter, args [][]byte) {
+	if len(args)%2 != 0 {
+		w.writeError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	wb := db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	for i := 0; i < len(args); i += 2 {
+		if err := wb.Put(args[i], args[i+1]); err != nil {
+			writeDBError(w, err)
+			return
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.writeSimpleString("OK")
+}
+
+// EXPIRE key seconds，过期时间不大于 0 时直接删除 key
+func expire(db *bitcask.DB, w *respWriter, args [][]byte) {
+	seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		w.writeError("ERR value is not an integer or out of range")
+		return
+	}
+
+	if seconds <= 0 {
+		del(db, w, args[:1])
+		return
+	}
+	if !validExpireTime(seconds, time.Second) {
+		w.writeError("ERR invalid expire time in 'expire' command")
+		return
+	}
+	err = db.Expire(args[0], time.Duration(seconds)*time.Second)
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		w.writeInteger(0)
+		return
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.writeInteger(1)
+}
+
+// TTL key，key 不存在返回 -2，没有设置过期时间返回 -1
+func ttl(db *bitcask.DB, w *respWriter, args [][]byte) {
+	remaining, err := db.TTL(args[0])
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		w.writeInteger(-2)
+		return
+	}
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if remaining == 0 {
+		w.writeInteger(-1)
+		return
+	}
+	w.writeInteger(int64((remaining + 500*time.Millisecond) / time.Second))
+}
+
+// COMMAND，redis-cli 启动时会发送，返回空的列表即可
+func commandCmd(_ *bitcask.DB, w *respWriter, _ [][]byte) {
+	w.writeArrayHeader(0)
+}
@@ -0,0 +1,107 @@
+package resp
+
+// glob 风格的模式匹配，和 Redis KEYS/SCAN 的 MATCH 语义一致
+// 支持 * 匹配任意字符串，? 匹配任意单个字符，[abc]、[^abc]、[a-z] 匹配字符集合，\ 转义特殊字符
+func globMatch(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// 连续的 * 等价于一个
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var matched bool
+			matched, pattern = matchClass(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// 匹配 [...] 中的字符集合，pattern 从 [ 之后开始，返回是否匹配以及 ] 之后剩余的模式
+func matchClass(pattern []byte, c byte) (bool, []byte) {
+	not := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		not = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	// 没有闭合的 [ 视为到模式末尾结束
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != not, pattern
+}
+
+// 返回模式中第一个特殊字符之前的固定前缀，用于缩小遍历的范围
+func literalPrefix(pattern []byte) []byte {
+	for i, c := range pattern {
+		if c == '*' || c == '?' || c == '[' || c == '\\' {
+			if i == 0 {
+				return nil
+			}
+			return pattern[:i]
+		}
+	}
+	if len(pattern) == 0 {
+		return nil
+	}
+	return pattern
+}
@@ -0,0 +1,145 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	maxInlineSize  = 64 * 1024         // inline 命令的最大长度
+	maxBulkSize    = 512 * 1024 * 1024 // 单个参数的最大长度
+	maxArrayLength = 1024 * 1024       // 一条命令中参数的最大数量
+)
+
+// 协议格式错误，返回给客户端之后关闭连接
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+var errLineTooLong = protocolError("too big inline request")
+
+// 读取一条命令
+// 支持 RESP 数组形式的命令，以及 telnet 之类的客户端发送的以空格分隔的 inline 命令
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			// 忽略空行
+			continue
+		}
+		if line[0] != '*' {
+			return bytes.Fields(line), nil
+		}
+
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > maxArrayLength {
+			return nil, protocolError("invalid multibulk length")
+		}
+		if n <= 0 {
+			continue
+		}
+		args := make([][]byte, 0, n)
+		for i := 0; i < n; i++ {
+			arg, err := readBulkString(r)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+// 读取一个 $<len>\r\n<data>\r\n 格式的字符串
+func readBulkString(r *bufio.Reader) ([]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, protocolError("expected '$'")
+	}
+	size, err := strconv.Atoi(string(line[1:]))
+	if err != nil || size < 0 || size > maxBulkSize {
+		return nil, protocolError("invalid bulk length")
+	}
+
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return nil, protocolError("expected CRLF after bulk string")
+	}
+	return buf[:size], nil
+}
+
+// 读取一行数据，去掉末尾的 \r\n
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		part, err := r.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+		line = append(line, part...)
+		if len(line) > maxInlineSize {
+			return nil, errLineTooLong
+		}
+		if err == nil {
+			break
+		}
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// 按照 RESP2 格式写回复
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w *respWriter) writeSimpleString(s string) {
+	_, _ = w.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) writeError(s string) {
+	_, _ = w.WriteString("-" + s + "\r\n")
+}
+
+func (w *respWriter) writeInteger(n int64) {
+	_, _ = w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// 写一个字符串，为 nil 时写入 RESP 的空值
+func (w *respWriter) writeBulk(b []byte) {
+	if b == nil {
+		_, _ = w.WriteString("$-1\r\n")
+		return
+	}
+	_, _ = w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	_, _ = w.Write(b)
+	_, _ = w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeArrayHeader(n int) {
+	_, _ = w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w *respWriter) writeBulkArray(items [][]byte) {
+	w.writeArrayHeader(len(items))
+	for _, item := range items {
+		w.writeBulk(item)
+	}
+}
@@ -0,0 +1,171 @@
+package resp
+
+import (
+	bitcask "bitcask-go"
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Server 兼容 Redis RESP2 协议的 TCP 服务
+// 每个连接一个协程，按顺序处理客户端发来的命令，支持 pipeline
+type Server struct {
+	db       *bitcask.DB
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer 初始化 RESP 服务
+func NewServer(db *bitcask.DB) *Server {
+	return &Server{
+		db:    db,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe 监听 TCP 地址并处理客户端连接
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve 在 listener 上接收客户端连接，直到 Close 被调用
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = listener.Close()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.handleConn(conn)
+	}
+}
+
+// Addr 返回监听的地址，服务没有启动时返回 nil
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close 停止接收新的连接，关闭所有的客户端连接并等待处理协程退出
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// 处理一个客户端连接
+func (s *Server) handleConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+		s.wg.Done()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := &respWriter{Writer: bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			var protoErr protocolError
+			if errors.As(err, &protoErr) {
+				writer.writeError("ERR " + protoErr.Error())
+				_ = writer.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("failed to read command from %s: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.execute(writer, args)
+
+		// pipeline 中的命令处理完之后再一起发送回复
+		if quit || reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// 执行一条命令并写入回复，返回是否需要关闭连接
+func (s *Server) execute(w *respWriter, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	if name == "quit" {
+		w.writeSimpleString("OK")
+		return true
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		w.writeError("ERR unknown command '" + string(args[0]) + "'")
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		w.writeError("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+	cmd.handler(s.db, w, args[1:])
+	return false
+}
@@ -0,0 +1,240 @@
+package resp
+
+import (
+	bitcask "bitcask-go"
+	"bufio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T) (*Server, func()) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-resp")
+	opts.DirPath = dir
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+
+	server := NewServer(db)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	for server.Addr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	return server, func() {
+		_ = server.Close()
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// 将命令编码为 RESP 数组
+func encodeCommand(args ...string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		sb.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	return sb.String()
+}
+
+// 读取一个完整的回复，原样返回
+func readReply(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	switch line[0] {
+	case '$':
+		var n int
+		_, _ = fmt.Sscanf(line, "$%d\r\n", &n)
+		if n < 0 {
+			return line
+		}
+		buf := make([]byte, n+2)
+		_, err := io.ReadFull(r, buf)
+		assert.Nil(t, err)
+		return line + string(buf)
+	case '*':
+		var n int
+		_, _ = fmt.Sscanf(line, "*%d\r\n", &n)
+		for i := 0; i < n; i++ {
+			line += readReply(t, r)
+		}
+		return line
+	default:
+		return line
+	}
+}
+
+func TestServer_Commands(t *testing.T) {
+	server, cleanup := startServer(t)
+	defer cleanup()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	cases := []struct {
+		args  []string
+		reply string
+	}{
+		{[]string{"PING"}, "+PONG\r\n"},
+		{[]string{"ping", "hello"}, "$5\r\nhello\r\n"},
+		{[]string{"GET", "k1"}, "$-1\r\n"},
+		{[]string{"SET", "k1", "v1"}, "+OK\r\n"},
+		{[]string{"GET", "k1"}, "$2\r\nv1\r\n"},
+		{[]string{"SET", "k1"}, "-ERR wrong number of arguments for 'set' command\r\n"},
+		{[]string{"SET", "k1", "v1", "NX"}, "-ERR syntax error\r\n"},
+		{[]string{"SET", "k1", "v1", "EX", "0"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{[]string{"SET", "k1", "v1", "EX", "9223372036854775807"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{[]string{"SET", "k1", "v1", "PX", "9223372036854775"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{[]string{"MSET", "k2", "v2", "k3", "v3", "other", "v4"}, "+OK\r\n"},
+		{[]string{"MGET", "k1", "missing", "k3"}, "*3\r\n$2\r\nv1\r\n$-1\r\n$2\r\nv3\r\n"},
+		{[]string{"EXISTS", "k1", "k2", "missing"}, ":2\r\n"},
+		{[]string{"KEYS", "k*"}, "*3\r\n$2\r\nk1\r\n$2\r\nk2\r\n$2\r\nk3\r\n"},
+		{[]string{"KEYS", "[ko]?"}, "*3\r\n$2\r\nk1\r\n$2\r\nk2\r\n$2\r\nk3\r\n"},
+		{[]string{"SCAN", "0", "COUNT", "2"}, "*2\r\n$4\r\n6b32\r\n*2\r\n$2\r\nk1\r\n$2\r\nk2\r\n"},
+		{[]string{"SCAN", "6b32", "COUNT", "2"}, "*2\r\n$1\r\n0\r\n*2\r\n$2\r\nk3\r\n$5\r\nother\r\n"},
+		{[]string{"SCAN", "6b31ff", "COUNT", "1"}, "*2\r\n$4\r\n6b32\r\n*1\r\n$2\r\nk2\r\n"},
+		{[]string{"SCAN", "zz"}, "-ERR invalid cursor\r\n"},
+		{[]string{"SCAN", "0", "MATCH", "o*"}, "*2\r\n$1\r\n0\r\n*1\r\n$5\r\nother\r\n"},
+		{[]string{"TTL", "k1"}, ":-1\r\n"},
+		{[]string{"TTL", "missing"}, ":-2\r\n"},
+		{[]string{"EXPIRE", "k1", "100"}, ":1\r\n"},
+		{[]string{"TTL", "k1"}, ":100\r\n"},
+		{[]string{"EXPIRE", "missing", "100"}, ":0\r\n"},
+		{[]string{"EXPIRE", "k1", "9223372036854775807"}, "-ERR invalid expire time in 'expire' command\r\n"},
+		{[]string{"SET", "k4", "v4", "EX", "10"}, "+OK\r\n"},
+		{[]string{"TTL", "k4"}, ":10\r\n"},
+		{[]string{"EXPIRE", "k4", "0"}, ":1\r\n"},
+		{[]string{"DEL", "k1", "k2", "missing"}, ":2\r\n"},
+		{[]string{"GET", "k1"}, "$-1\r\n"},
+		{[]string{"UNKNOWN"}, "-ERR unknown command 'UNKNOWN'\r\n"},
+	}
+	for _, c := range cases {
+		_, err := conn.Write([]byte(encodeCommand(c.args...)))
+		assert.Nil(t, err)
+		assert.Equal(t, c.reply, readReply(t, r), "%v", c.args)
+	}
+
+	// inline 命令
+	_, err = conn.Write([]byte("GET k3\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "$2\r\nv3\r\n", readReply(t, r))
+
+	_, err = conn.Write([]byte(encodeCommand("QUIT")))
+	assert.Nil(t, err)
+	assert.Equal(t, "+OK\r\n", readReply(t, r))
+}
+
+func TestServer_Pipeline(t *testing.T) {
+	server, cleanup := startServer(t)
+	defer cleanup()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// 一次写入多条命令
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		sb.WriteString(encodeCommand("SET", fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)))
+		sb.WriteString(encodeCommand("GET", fmt.Sprintf("key-%d", i)))
+	}
+	_, err = conn.Write([]byte(sb.String()))
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "+OK\r\n", readReply(t, r))
+		value := fmt.Sprintf("value-%d", i)
+		assert.Equal(t, fmt.Sprintf("$%d\r\n%s\r\n", len(value), value), readReply(t, r))
+	}
+
+	// 协议错误返回错误并关闭连接
+	_, err = conn.Write([]byte("*1\r\n+PING\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "-ERR Protocol error: expected '$'\r\n", readReply(t, r))
+	_, err = r.ReadString('\n')
+	assert.NotNil(t, err)
+}
+
+func TestServer_Clients(t *testing.T) {
+	server, cleanup := startServer(t)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", server.Addr().String())
+			assert.Nil(t, err)
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("client-%d-%d", i, j)
+				_, err := conn.Write([]byte(encodeCommand("SET", key, key)))
+				assert.Nil(t, err)
+				assert.Equal(t, "+OK\r\n", readReply(t, r))
+				_, err = conn.Write([]byte(encodeCommand("GET", key)))
+				assert.Nil(t, err)
+				assert.Equal(t, fmt.Sprintf("$%d\r\n%s\r\n", len(key), key), readReply(t, r))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 关闭服务时断开所有的连接
+	conn, err := net.Dial("tcp", server.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(encodeCommand("PING")))
+	assert.Nil(t, err)
+	assert.Equal(t, "+PONG\r\n", readReply(t, bufio.NewReader(conn)))
+	err = server.Close()
+	assert.Nil(t, err)
+	_, err = bufio.NewReader(conn).ReadString('\n')
+	assert.NotNil(t, err)
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"user:*:name", "user:1:name", true},
+		{"user:*:name", "user:1:age", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, globMatch([]byte(c.pattern), []byte(c.s)), "%s %s", c.pattern, c.s)
+	}
+
+	assert.Equal(t, []byte("user:"), literalPrefix([]byte("user:*")))
+	assert.Nil(t, literalPrefix([]byte("*")))
+	assert.Equal(t, []byte("key"), literalPrefix([]byte("key")))
+}
@@ -49,6 +49,36 @@ func (b *BitcaskService) ListKeys(_ struct{}, keys *[]string) error {
 	return nil
 }
 
+// IncrByArgs 整数计数器的参数
+type IncrByArgs struct {
+	Key   string
+	Delta int64
+}
+
+func (b *BitcaskService) IncrBy(args IncrByArgs, value *int64) error {
+	v, err := b.db.IncrBy([]byte(args.Key), args.Delta)
+	if err != nil {
+		return err
+	}
+	*value = v
+	return nil
+}
+
+// IncrByFloatArgs 浮点数计数器的参数
+type IncrByFloatArgs struct {
+	Key   string
+	Delta float64
+}
+
+func (b *BitcaskService) IncrByFloat(args IncrByFloatArgs, value *float64) error {
+	v, err := b.db.IncrByFloat([]byte(args.Key), args.Delta)
+	if err != nil {
+		return err
+	}
+	*value = v
+	return nil
+}
+
 func main() {
 	// 初始化 DB 实例
 	var err error
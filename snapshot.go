@@ -0,0 +1,138 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"sync"
+)
+
+// Snapshot 数据库在某一时刻的只读视图
+// 持有创建时的索引副本，并且引用当时的数据文件，在 Release 之前这些文件不会被 merge 关闭
+type Snapshot struct {
+	mu       *sync.RWMutex
+	db       *DB
+	index    index.Indexer             // 创建快照时的索引副本
+	files    map[uint32]*data.DataFile // 创建快照时的数据文件
+	released bool
+}
+
+// Snapshot 创建数据库当前状态的快照，使用完毕之后需要调用 Release 释放
+// 创建索引副本失败时返回错误，B+ 树索引需要从磁盘中读取所有的位置索引
+func (db *DB) Snapshot() (*Snapshot, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	indexSnapshot, err := db.index.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[uint32]*data.DataFile, len(db.olderFiles)+1)
+	for fid, dataFile := range db.olderFiles {
+		files[fid] = dataFile
+	}
+	if db.activeFile != nil {
+		files[db.activeFile.FileId] = db.activeFile
+	}
+	for _, dataFile := range files {
+		db.fileRefs[dataFile]++
+	}
+
+	return &Snapshot{
+		mu:    new(sync.RWMutex),
+		db:    db,
+		index: indexSnapshot,
+		files: files,
+	}, nil
+}
+
+// Get 根据 key 读取快照中的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return readValue(s.files[logRecordPos.Fid], logRecordPos)
+}
+
+// NewIterator 初始化快照的迭代器
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var indexIter index.Iterator
+	if s.released {
+		indexIter = index.NewBTree().Iterator(opts.Reverse)
+	} else {
+		indexIter = s.index.PrefixIterator(opts.Prefix, opts.Reverse)
+	}
+	return &Iterator{
+		db:        s.db,
+		snapshot:  s,
+		indexIter: indexIter,
+		options:   opts,
+	}
+}
+
+// Release 释放快照，不再引用的数据文件如果已经被 merge 替换，则在这里关闭
+func (s *Snapshot) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return nil
+	}
+	s.released = true
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	var err error
+	for _, dataFile := range s.files {
+		if releaseErr := s.db.releaseDataFile(dataFile); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}
+	s.files = nil
+	s.index = nil
+	return err
+}
+
+// 根据位置索引读取快照中的数据
+func (s *Snapshot) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	return readValue(s.files[logRecordPos.Fid], logRecordPos)
+}
+
+// 关闭被 merge 替换的数据文件，如果仍然被快照引用，则等到快照释放之后再关闭。该方法必须持有互斥锁
+func (db *DB) retireDataFile(dataFile *data.DataFile) error {
+	if db.fileRefs[dataFile] > 0 {
+		db.retiredFiles[dataFile] = true
+		return nil
+	}
+	return dataFile.Close()
+}
+
+// 释放对数据文件的引用，不再被引用的文件如果已经被 merge 替换，则关闭该文件。该方法必须持有互斥锁
+func (db *DB) releaseDataFile(dataFile *data.DataFile) error {
+	db.fileRefs[dataFile]--
+	if db.fileRefs[dataFile] > 0 {
+		return nil
+	}
+	delete(db.fileRefs, dataFile)
+	if db.retiredFiles[dataFile] {
+		delete(db.retiredFiles, dataFile)
+		return dataFile.Close()
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("old"))
+		assert.Nil(t, err)
+	}
+
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	// 创建快照之后的修改对快照不可见
+	for i := 0; i < 50; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new"))
+		assert.Nil(t, err)
+	}
+	for i := 50; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(100), []byte("new"))
+	assert.Nil(t, err)
+
+	val, err := snap.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), val)
+	val, err = snap.Get(utils.GetTestKey(60))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), val)
+	_, err = snap.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), val)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	err = snap.Release()
+	assert.Nil(t, err)
+	_, err = snap.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	assert.Equal(t, 0, len(db.fileRefs))
+}
+
+// 快照引用的数据文件在 merge 之后依然可以读取
+func TestDB_Snapshot_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 5000; i++ {
+		val := utils.RandomValue(256)
+		err := db.Put(utils.GetTestKey(i), val)
+		assert.Nil(t, err)
+		values[string(utils.GetTestKey(i))] = val
+	}
+
+	snap, err := db.Snapshot()
+	assert.Nil(t, err)
+	for i := 0; i < 4000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, len(db.retiredFiles) > 0)
+
+	for key, val := range values {
+		v, err := snap.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, val, v)
+	}
+	for i := 4000; i < 5000; i++ {
+		v, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[string(utils.GetTestKey(i))], v)
+	}
+
+	err = snap.Release()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.retiredFiles))
+}
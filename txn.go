@@ -0,0 +1,375 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// Txn 乐观读写事务
+// 读取数据时记录 key 当时的位置索引，提交时如果这些 key 已经被其他写入修改，则返回冲突错误
+// 迭代器同时记录遍历过的 key 的范围，提交时范围内新增或者删除了 key 也是冲突
+type Txn struct {
+	options       TxnOptions
+	mu            *sync.Mutex
+	db            *DB
+	pendingWrites map[string]*data.LogRecord    // 暂存事务中写入的数据
+	readPositions map[string]*data.LogRecordPos // 事务中读取过的 key 以及读取时的位置索引
+	readRanges    []*readRange                  // 事务中迭代器遍历过的范围
+	finished      bool
+}
+
+// 迭代器遍历过的 key 的范围，范围内数据库中的 key 都记录在 readPositions 中
+type readRange struct {
+	prefix []byte
+	lower  []byte // 范围的下界，包含在范围内，为空表示从前缀的起点开始
+	upper  []byte // 范围的上界，包含在范围内，为空表示到前缀的终点
+}
+
+// Begin 开启一个新的事务
+func (db *DB) Begin(opts TxnOptions) *Txn {
+	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot use transaction, seq no file not exists")
+	}
+	return &Txn{
+		options:       opts,
+		mu:            new(sync.Mutex),
+		db:            db,
+		pendingWrites: make(map[string]*data.LogRecord),
+		readPositions: make(map[string]*data.LogRecordPos),
+	}
+}
+
+// Get 读取数据，优先读取事务中尚未提交的数据
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+	logRecordPos := txn.db.index.Get(key)
+	txn.recordRead(key, logRecordPos)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValueByPosition(logRecordPos)
+}
+
+// Put 在事务中写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	// 提交之前 key 可能被其他写入重新创建，因此不管当前是否存在都需要写入墓碑值
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// Commit 提交事务
+// 如果事务读取过的 key 在读取之后被修改，或者迭代器遍历过的范围内出现了新的 key，则放弃提交并返回 ErrTxnConflict
+// 注意 merge 会改变数据的位置索引，也会导致冲突，重新执行事务即可
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+
+	// 只读事务不需要写入任何数据
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+	if uint(len(txn.pendingWrites)) > txn.options.MaxWriteNum {
+		return ErrExceedMaxBatchNum
+	}
+
+	// 加锁保证检查冲突和写入数据之间没有其他的写入
+	txn.db.mu.Lock()
+	defer txn.db.unlockAndNotify()
+
+	for key, pos := range txn.readPositions {
+		if !isSamePosition(txn.db.index.Get([]byte(key)), pos) {
+			return ErrTxnConflict
+		}
+	}
+	for _, rng := range txn.readRanges {
+		if txn.hasNewKey(rng) {
+			return ErrTxnConflict
+		}
+	}
+
+	return txn.db.commitRecords(txn.pendingWrites, txn.options.SyncWrites)
+}
+
+// Rollback 回滚事务，丢弃所有暂存的数据
+func (txn *Txn) Rollback() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+	txn.pendingWrites = nil
+	txn.readPositions = nil
+	txn.readRanges = nil
+	return nil
+}
+
+// 记录第一次读取 key 时的位置索引，已经过期的数据视为不存在。该方法必须持有事务的锁
+func (txn *Txn) recordRead(key []byte, pos *data.LogRecordPos) {
+	if _, ok := txn.readPositions[string(key)]; ok {
+		return
+	}
+	if pos != nil && pos.IsExpired() {
+		pos = nil
+	}
+	txn.readPositions[string(key)] = pos
+}
+
+// 遍历过的范围内是否有遍历时不存在的 key，已经过期的数据视为不存在。该方法必须持有数据库的锁
+// 遍历时存在、之后被删除或者修改的 key 已经由 readPositions 检查
+func (txn *Txn) hasNewKey(rng *readRange) bool {
+	iter := txn.db.index.PrefixIterator(rng.prefix, false)
+	defer iter.Close()
+	if rng.lower != nil {
+		iter.Seek(rng.lower)
+	} else {
+		iter.Rewind()
+	}
+	for ; iter.Valid(); iter.Next() {
+		if rng.upper != nil && bytes.Compare(iter.Key(), rng.upper) > 0 {
+			return false
+		}
+		if iter.Value().IsExpired() {
+			continue
+		}
+		if _, ok := txn.readPositions[string(iter.Key())]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断当前的位置索引和读取时的位置索引是否一致
+func isSamePosition(cur, read *data.LogRecordPos) bool {
+	if cur != nil && cur.IsExpired() {
+		cur = nil
+	}
+	if cur == nil || read == nil {
+		return cur == read
+	}
+	return cur.Fid == read.Fid && cur.Offset == read.Offset
+}
+
+// TxnIterator 事务迭代器，将事务中暂存的数据和数据库中的数据合并遍历
+// 暂存的数据以创建迭代器时为准。数据库中遍历到的 key 和遍历过的范围都会计入事务的读集合
+type TxnIterator struct {
+	txn         *Txn
+	dbIter      *Iterator         // 数据库迭代器
+	records     []*data.LogRecord // 按照 key 的遍历顺序排列的暂存数据
+	idx         int               // 当前遍历到的暂存数据的下标
+	prefix      []byte
+	reverse     bool
+	fromPending bool       // 当前位置的数据是否来自暂存数据
+	rng         *readRange // 从最近一次 Rewind 或者 Seek 开始遍历过的范围
+}
+
+// Iterator 初始化事务迭代器
+func (txn *Txn) Iterator(opts IteratorOptions) *TxnIterator {
+	txn.mu.Lock()
+	var records []*data.LogRecord
+	for _, record := range txn.pendingWrites {
+		if bytes.HasPrefix(record.Key, opts.Prefix) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(records[i].Key, records[j].Key) > 0
+		}
+		return bytes.Compare(records[i].Key, records[j].Key) < 0
+	})
+	txn.mu.Unlock()
+
+	it := &TxnIterator{
+		txn:     txn,
+		dbIter:  txn.db.NewIterator(opts),
+		records: records,
+		prefix:  opts.Prefix,
+		reverse: opts.Reverse,
+	}
+	it.Rewind()
+	return it
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (it *TxnIterator) Rewind() {
+	it.txn.mu.Lock()
+	it.startRange(nil)
+	it.txn.mu.Unlock()
+	it.dbIter.Rewind()
+	it.observeLocked()
+	it.idx = 0
+	it.skipToNext()
+}
+
+// Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据从这个 key 开始遍历
+func (it *TxnIterator) Seek(key []byte) {
+	it.txn.mu.Lock()
+	it.startRange(key)
+	it.txn.mu.Unlock()
+	it.dbIter.Seek(key)
+	it.observeLocked()
+	it.idx = sort.Search(len(it.records), func(i int) bool {
+		if it.reverse {
+			return bytes.Compare(it.records[i].Key, key) <= 0
+		}
+		return bytes.Compare(it.records[i].Key, key) >= 0
+	})
+	it.skipToNext()
+}
+
+// Next 跳转到下一个 key
+func (it *TxnIterator) Next() {
+	if it.fromPending {
+		it.idx++
+	} else {
+		it.dbIter.Next()
+		it.observeLocked()
+	}
+	it.skipToNext()
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (it *TxnIterator) Valid() bool {
+	return it.idx < len(it.records) || it.dbIter.Valid()
+}
+
+// Key 当前遍历位置的 Key 数据
+func (it *TxnIterator) Key() []byte {
+	if it.fromPending {
+		return it.records[it.idx].Key
+	}
+	return it.dbIter.Key()
+}
+
+// Value 当前遍历位置的 Value 数据，读取数据库中的数据会计入事务的读集合
+func (it *TxnIterator) Value() ([]byte, error) {
+	if it.fromPending {
+		return it.records[it.idx].Value, nil
+	}
+	it.txn.mu.Lock()
+	defer it.txn.mu.Unlock()
+	if it.txn.finished {
+		return nil, ErrTxnFinished
+	}
+	it.txn.recordRead(it.dbIter.Key(), it.dbIter.indexIter.Value())
+	return it.dbIter.Value()
+}
+
+// Close 关闭迭代器，释放相应资源
+func (it *TxnIterator) Close() {
+	it.dbIter.Close()
+}
+
+// 开始记录一个新的遍历范围，key 为 Seek 的目标，为空表示从起点开始。该方法必须持有事务的锁
+func (it *TxnIterator) startRange(key []byte) {
+	it.rng = &readRange{prefix: it.prefix}
+	if key != nil {
+		key = append([]byte(nil), key...)
+	}
+	if it.reverse {
+		it.rng.upper = key
+	} else {
+		it.rng.lower = key
+	}
+	if !it.txn.finished {
+		it.txn.readRanges = append(it.txn.readRanges, it.rng)
+	}
+}
+
+// 记录数据库迭代器当前所在的 key，并将遍历范围延伸到这个 key，遍历完毕时延伸到前缀的终点。该方法必须持有事务的锁
+// 被暂存数据覆盖的 key 也会记录，提交时判断范围内是否有新的 key 需要用到
+func (it *TxnIterator) observe() {
+	if it.txn.finished {
+		return
+	}
+	var key []byte
+	if it.dbIter.Valid() {
+		key = append([]byte(nil), it.dbIter.Key()...)
+		it.txn.recordRead(key, it.dbIter.indexIter.Value())
+	}
+	if it.reverse {
+		it.rng.lower = key
+	} else {
+		it.rng.upper = key
+	}
+}
+
+func (it *TxnIterator) observeLocked() {
+	it.txn.mu.Lock()
+	defer it.txn.mu.Unlock()
+	it.observe()
+}
+
+// 选出下一个需要遍历的 key，暂存数据覆盖数据库中相同的 key，并跳过暂存的删除操作
+func (it *TxnIterator) skipToNext() {
+	for it.idx < len(it.records) {
+		if it.dbIter.Valid() {
+			cmp := bytes.Compare(it.records[it.idx].Key, it.dbIter.Key())
+			if it.reverse {
+				cmp = -cmp
+			}
+			if cmp > 0 {
+				it.fromPending = false
+				return
+			}
+			if cmp == 0 {
+				it.dbIter.Next()
+				it.observeLocked()
+			}
+		}
+		if it.records[it.idx].Type != data.LogRecordDeleted {
+			it.fromPending = true
+			return
+		}
+		it.idx++
+	}
+	it.fromPending = false
+}
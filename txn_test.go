@@ -0,0 +1,243 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	txn := db.Begin(DefaultTxnOptions)
+	// 事务中可以读取到自己写入的数据
+	err = txn.Put(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	val, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 提交之前其他人看不到
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	err = txn.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Equal(t, ErrTxnFinished, err)
+
+	// 回滚之后数据不会写入
+	txn2 := db.Begin(DefaultTxnOptions)
+	err = txn2.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Nil(t, err)
+	err = txn2.Rollback()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后数据依然正确
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-conflict")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	err = db.Put([]byte("counter"), []byte("1"))
+	assert.Nil(t, err)
+
+	// 读取的 key 被其他写入修改，提交失败
+	txn1 := db.Begin(DefaultTxnOptions)
+	txn2 := db.Begin(DefaultTxnOptions)
+	_, err = txn1.Get([]byte("counter"))
+	assert.Nil(t, err)
+	_, err = txn2.Get([]byte("counter"))
+	assert.Nil(t, err)
+	err = txn1.Put([]byte("counter"), []byte("2"))
+	assert.Nil(t, err)
+	err = txn2.Put([]byte("counter"), []byte("3"))
+	assert.Nil(t, err)
+
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	// 读取时不存在的 key 被其他人创建，同样是冲突
+	txn3 := db.Begin(DefaultTxnOptions)
+	_, err = txn3.Get([]byte("lock"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn3.Put([]byte("lock"), []byte("txn3"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("lock"), []byte("other"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 只写不读的事务不会冲突
+	txn4 := db.Begin(DefaultTxnOptions)
+	err = txn4.Put([]byte("lock"), []byte("txn4"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("lock"), []byte("other2"))
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Nil(t, err)
+}
+
+func TestDB_Txn_Iterator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-iterator")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	_ = db.Put([]byte("a"), []byte("db-a"))
+	_ = db.Put([]byte("c"), []byte("db-c"))
+	_ = db.Put([]byte("e"), []byte("db-e"))
+
+	txn := db.Begin(DefaultTxnOptions)
+	_ = txn.Put([]byte("b"), []byte("txn-b"))
+	_ = txn.Put([]byte("c"), []byte("txn-c"))
+	_ = txn.Delete([]byte("e"))
+	_ = txn.Put([]byte("f"), []byte("txn-f"))
+
+	iter := txn.Iterator(DefaultIteratorOptions)
+	var keys, values []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		keys = append(keys, string(iter.Key()))
+		values = append(values, string(val))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"a", "b", "c", "f"}, keys)
+	assert.Equal(t, []string{"db-a", "txn-b", "txn-c", "txn-f"}, values)
+
+	// 反向遍历并 Seek
+	iterOpts := DefaultIteratorOptions
+	iterOpts.Reverse = true
+	iter2 := txn.Iterator(iterOpts)
+	keys = nil
+	for iter2.Seek([]byte("d")); iter2.Valid(); iter2.Next() {
+		keys = append(keys, string(iter2.Key()))
+	}
+	iter2.Close()
+	assert.Equal(t, []string{"c", "b", "a"}, keys)
+
+	// 迭代器读取的数据被修改，提交失败
+	err = db.Put([]byte("a"), []byte("db-a2"))
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+}
+
+// 迭代器遍历过的范围内新增或者删除 key，提交时冲突
+func TestDB_Txn_Iterator_Phantom(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-iterator-phantom")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	_ = db.Put([]byte("k1"), []byte("v1"))
+	_ = db.Put([]byte("k3"), []byte("v3"))
+	_ = db.Put([]byte("k5"), []byte("v5"))
+	iterOpts := DefaultIteratorOptions
+	iterOpts.Prefix = []byte("k")
+	countKeys := func(txn *Txn) int {
+		iter := txn.Iterator(iterOpts)
+		defer iter.Close()
+		var count int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			count++
+		}
+		return count
+	}
+
+	// 两个事务都遍历同一个范围并写入新的 key，后提交的事务冲突
+	txn1 := db.Begin(DefaultTxnOptions)
+	txn2 := db.Begin(DefaultTxnOptions)
+	assert.Equal(t, 3, countKeys(txn1))
+	assert.Equal(t, 3, countKeys(txn2))
+	assert.Nil(t, txn1.Put([]byte("k2"), []byte("txn1")))
+	assert.Nil(t, txn2.Put([]byte("k4"), []byte("txn2")))
+	assert.Nil(t, txn1.Commit())
+	assert.Equal(t, ErrTxnConflict, txn2.Commit())
+
+	// 遍历过的 key 被删除，没有读取 value 也会冲突
+	txn3 := db.Begin(DefaultTxnOptions)
+	assert.Equal(t, 4, countKeys(txn3))
+	assert.Nil(t, txn3.Put([]byte("count"), []byte("4")))
+	assert.Nil(t, db.Delete([]byte("k5")))
+	assert.Equal(t, ErrTxnConflict, txn3.Commit())
+
+	// 反向遍历的范围内新增 key
+	txn4 := db.Begin(DefaultTxnOptions)
+	reverseOpts := iterOpts
+	reverseOpts.Reverse = true
+	iter := txn4.Iterator(reverseOpts)
+	var keys []string
+	for iter.Seek([]byte("k3")); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"k3", "k2", "k1"}, keys)
+	assert.Nil(t, txn4.Put([]byte("count"), []byte("3")))
+	assert.Nil(t, db.Put([]byte("k0"), []byte("v0")))
+	assert.Equal(t, ErrTxnConflict, txn4.Commit())
+
+	// 范围之外新增 key 不会冲突
+	txn5 := db.Begin(DefaultTxnOptions)
+	iter = txn5.Iterator(iterOpts)
+	iter.Seek([]byte("k2"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte("k2"), iter.Key())
+	iter.Close()
+	assert.Nil(t, txn5.Put([]byte("k2"), []byte("txn5")))
+	assert.Nil(t, db.Put([]byte("k11"), []byte("v11")))
+	assert.Nil(t, db.Put([]byte("k6"), []byte("v6")))
+	assert.Nil(t, db.Put([]byte("z"), []byte("z")))
+	assert.Nil(t, txn5.Commit())
+	val, err := db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn5"), val)
+}
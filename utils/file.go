@@ -8,11 +8,14 @@ import (
 	"syscall"
 )
 
-// DirSize 获取一个目录的大小
+// DirSize 获取一个目录的大小，遍历的过程中被删除的文件不计算在内
 func DirSize(dirPath string) (int64, error) {
 	var size int64
 	err := filepath.Walk(dirPath, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
+			if os.IsNotExist(err) && path != dirPath {
+				return nil
+			}
 			return err
 		}
 		if !info.IsDir() {
@@ -23,14 +26,10 @@ func DirSize(dirPath string) (int64, error) {
 	return size, err
 }
 
-// AvailableDiskSize 获取磁盘剩余可用空间大小
-func AvailableDiskSize() (uint64, error) {
-	wd, err := syscall.Getwd()
-	if err != nil {
-		return 0, err
-	}
+// AvailableDiskSize 获取 dir 所在磁盘的剩余可用空间大小
+func AvailableDiskSize(dir string) (uint64, error) {
 	var stat syscall.Statfs_t
-	if err = syscall.Statfs(wd, &stat); err != nil {
+	if err := syscall.Statfs(dir, &stat); err != nil {
 		return 0, err
 	}
 	return stat.Bavail * uint64(stat.Bsize), nil
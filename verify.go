@@ -0,0 +1,534 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bytes"
+	"fmt"
+	"github.com/gofrs/flock"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VerifyProblemKind 检查数据目录时发现的问题的类型
+type VerifyProblemKind string
+
+const (
+	ProblemInvalidFileName      VerifyProblemKind = "invalid-file-name"      // 数据文件的文件名不是文件 id
+	ProblemInvalidHeader        VerifyProblemKind = "invalid-header"         // 文件头损坏或者版本不能识别
+	ProblemInvalidCRC           VerifyProblemKind = "invalid-crc"            // crc 校验失败，之后的数据无法读取
+	ProblemTornTail             VerifyProblemKind = "torn-tail"              // 活跃文件末尾没有完整写入的数据
+	ProblemTruncated            VerifyProblemKind = "truncated"              // 旧的数据文件末尾的数据不完整
+	ProblemCorruptedLength      VerifyProblemKind = "corrupted-length"       // 数据的长度损坏，之后完整的数据无法读取
+	ProblemUnreadableRecord     VerifyProblemKind = "unreadable-record"      // crc 校验通过，但是无法解密或者解压
+	ProblemIncompleteTxn        VerifyProblemKind = "incomplete-txn"         // 没有读取到完成标识的事务
+	ProblemHintMismatch         VerifyProblemKind = "hint-mismatch"          // hint 文件中的索引和数据文件不一致
+	ProblemBPlusTreeMismatch    VerifyProblemKind = "bptree-mismatch"        // B+ 树索引和数据文件不一致
+	ProblemInvalidMergeFinished VerifyProblemKind = "invalid-merge-finished" // 标识 merge 完成的文件无法读取
+	ProblemInvalidSeqNo         VerifyProblemKind = "invalid-seq-no"         // 事务序列号文件无法读取，或者比数据文件中的序列号小
+)
+
+// VerifyOptions 检查数据目录的配置项
+type VerifyOptions struct {
+	// 解密数据使用的密钥，数据目录加密时需要提供写入数据时使用过的所有密钥
+	KeyProvider KeyProvider
+}
+
+// VerifyReport 数据目录的检查结果
+type VerifyReport struct {
+	DirPath  string
+	Files    []*VerifyFileReport // 每个数据文件的检查结果，按照文件 id 排序
+	Problems []*VerifyProblem    // 发现的问题，按照文件 id 和 offset 排序
+}
+
+// VerifyFileReport 一个数据文件的检查结果
+type VerifyFileReport struct {
+	FileId  uint32
+	Version uint16 // 文件格式版本，data.FileVersionLegacy 表示没有文件头的旧版本数据文件
+	Size    int64
+	Records int // 可以正常读取的 LogRecord 数量
+}
+
+// VerifyProblem 检查发现的一个问题
+type VerifyProblem struct {
+	Kind        VerifyProblemKind
+	File        string // 出现问题的文件名
+	Fid         uint32 // 数据文件 id，索引中的问题为索引指向的数据文件
+	Offset      int64  // 问题数据在数据文件中的位置
+	Message     string
+	Recoverable bool // 打开数据库时会自动修复，例如截断活跃文件末尾的数据，丢弃没有提交完成的事务
+}
+
+func (p *VerifyProblem) String() string {
+	s := fmt.Sprintf("%s %s fid=%d offset=%d: %s", p.Kind, p.File, p.Fid, p.Offset, p.Message)
+	if p.Recoverable {
+		s += " (recoverable)"
+	}
+	return s
+}
+
+// OK 是否没有发现打开数据库时无法自动修复的问题
+func (r *VerifyReport) OK() bool {
+	for _, problem := range r.Problems {
+		if !problem.Recoverable {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify 离线检查数据目录，用于确认备份的数据是否完整
+// 读取所有的数据文件校验文件头和 crc，检查没有提交完成的事务，并将 hint 文件和 B+ 树索引和数据文件进行比对
+// 检查时不会修改数据目录中的文件，数据目录正在被使用时返回 ErrDatabaseIsUsing
+func Verify(dir string) (*VerifyReport, error) {
+	return VerifyWithOptions(dir, VerifyOptions{})
+}
+
+// VerifyWithOptions 使用指定的配置项检查数据目录，见 Verify
+func VerifyWithOptions(dir string, options VerifyOptions) (*VerifyReport, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	// 打开过的数据目录中一定有文件锁，不存在时不需要创建
+	lockFileName := filepath.Join(dir, fileLockName)
+	if _, err := os.Stat(lockFileName); err == nil {
+		fileLock := flock.New(lockFileName)
+		hold, err := fileLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
+		defer fileLock.Unlock()
+	}
+
+	v := &verifier{
+		report: &VerifyReport{DirPath: dir},
+		files:  make(map[uint32]*data.DataFile),
+		// 按照启动时的方式重建索引，用来检查事务以及和 B+ 树索引比对
+		db: &DB{
+			options:         Options{DirPath: dir},
+			index:           index.NewBTree(),
+			fileReclaimSize: make(map[uint32]int64),
+		},
+	}
+	if options.KeyProvider != nil {
+		cipher, err := data.NewCipher(options.KeyProvider)
+		if err != nil {
+			return nil, err
+		}
+		v.db.cipher = cipher
+	}
+	defer v.close()
+
+	if err := v.openDataFiles(); err != nil {
+		return nil, err
+	}
+	nonMergeFileId, hasMerge := v.checkMergeFinished()
+	if err := v.checkHintFile(hasMerge, nonMergeFileId); err != nil {
+		return nil, err
+	}
+	replayer, err := v.checkDataFiles(hasMerge, nonMergeFileId)
+	if err != nil {
+		return nil, err
+	}
+	v.checkPendingTxns(replayer)
+	v.checkSeqNo(replayer.seqNo)
+	v.checkBPlusTreeIndex()
+
+	sort.SliceStable(v.report.Problems, func(i, j int) bool {
+		pi, pj := v.report.Problems[i], v.report.Problems[j]
+		if pi.Fid != pj.Fid {
+			return pi.Fid < pj.Fid
+		}
+		return pi.Offset < pj.Offset
+	})
+	return v.report, nil
+}
+
+type verifier struct {
+	db      *DB
+	report  *VerifyReport
+	fileIds []uint32
+	files   map[uint32]*data.DataFile // 可以正常打开的数据文件
+}
+
+func (v *verifier) addProblem(problem *VerifyProblem) {
+	v.report.Problems = append(v.report.Problems, problem)
+}
+
+func (v *verifier) close() {
+	for _, dataFile := range v.files {
+		_ = dataFile.Close()
+	}
+}
+
+// 打开所有的数据文件并校验文件头
+func (v *verifier) openDataFiles() error {
+	dirEntries, err := os.ReadDir(v.db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix), 10, 32)
+		if err != nil {
+			v.addProblem(&VerifyProblem{
+				Kind:    ProblemInvalidFileName,
+				File:    entry.Name(),
+				Message: "the data file name is not a file id",
+			})
+			continue
+		}
+		v.fileIds = append(v.fileIds, uint32(fileId))
+	}
+	sort.Slice(v.fileIds, func(i, j int) bool {
+		return v.fileIds[i] < v.fileIds[j]
+	})
+
+	for i, fid := range v.fileIds {
+		fileName := data.GetDataFileName(v.db.options.DirPath, fid)
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			return err
+		}
+		fileReport := &VerifyFileReport{FileId: fid, Size: stat.Size()}
+		v.report.Files = append(v.report.Files, fileReport)
+
+		dataFile, err := data.OpenDataFile(v.db.options.DirPath, fid, fio.StandardFIO)
+		if err == data.ErrInvalidFileHeader || err == data.ErrUnsupportedFileVersion {
+			// 最后一个数据文件的文件头没有完整写入时，启动时会重新创建
+			v.addProblem(&VerifyProblem{
+				Kind:        ProblemInvalidHeader,
+				File:        filepath.Base(fileName),
+				Fid:         fid,
+				Message:     err.Error(),
+				Recoverable: err == data.ErrInvalidFileHeader && i == len(v.fileIds)-1 && stat.Size() < data.FileHeaderSize,
+			})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dataFile.Cipher = v.db.cipher
+		if dataFile.Header != nil {
+			fileReport.Version = dataFile.Header.Version
+		}
+		v.files[fid] = dataFile
+	}
+	return nil
+}
+
+// 读取标识 merge 完成的文件，返回最近没有参与 merge 的文件 id
+func (v *verifier) checkMergeFinished() (uint32, bool) {
+	if _, err := os.Stat(filepath.Join(v.db.options.DirPath, data.MergeFinishedFileName)); err != nil {
+		return 0, false
+	}
+	nonMergeFileId, err := v.db.getNonMergeFileId(v.db.options.DirPath)
+	if err != nil {
+		v.addProblem(&VerifyProblem{
+			Kind:    ProblemInvalidMergeFinished,
+			File:    data.MergeFinishedFileName,
+			Message: err.Error(),
+		})
+		return 0, false
+	}
+	return nonMergeFileId, true
+}
+
+// 检查 hint 文件中的每一条索引是否指向对应的数据，有效的索引加载到重建的索引中
+func (v *verifier) checkHintFile(hasMerge bool, nonMergeFileId uint32) error {
+	if _, err := os.Stat(filepath.Join(v.db.options.DirPath, data.HintFileName)); err != nil {
+		return nil
+	}
+	if !hasMerge {
+		v.addProblem(&VerifyProblem{
+			Kind:    ProblemHintMismatch,
+			File:    data.HintFileName,
+			Message: "the hint file exists but the merge-finished file is missing or invalid",
+		})
+		return nil
+	}
+
+	hintFile, err := data.OpenHintFile(v.db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	hintFile.Cipher = v.db.cipher
+	defer hintFile.Close()
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			v.addProblem(&VerifyProblem{
+				Kind:    ProblemHintMismatch,
+				File:    data.HintFileName,
+				Message: fmt.Sprintf("failed to read the hint record at offset %d: %v", offset, err),
+			})
+			if size == 0 || err == data.ErrInvalidCRC {
+				break
+			}
+			offset += size
+			continue
+		}
+		offset += size
+
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if pos.Fid >= nonMergeFileId {
+			v.addProblem(&VerifyProblem{
+				Kind:    ProblemHintMismatch,
+				File:    data.HintFileName,
+				Fid:     pos.Fid,
+				Offset:  pos.Offset,
+				Message: fmt.Sprintf("key %q points to a data file which is not merged", logRecord.Key),
+			})
+			continue
+		}
+		if msg := v.checkPosition(logRecord.Key, pos); msg != "" {
+			v.addProblem(&VerifyProblem{
+				Kind:    ProblemHintMismatch,
+				File:    data.HintFileName,
+				Fid:     pos.Fid,
+				Offset:  pos.Offset,
+				Message: fmt.Sprintf("key %q %s", logRecord.Key, msg),
+			})
+			continue
+		}
+		if !pos.IsExpired() {
+			v.db.index.Put(logRecord.Key, pos)
+		}
+	}
+	return nil
+}
+
+// 检查位置索引是否指向 key 对应的一条完整的数据，有问题时返回原因
+func (v *verifier) checkPosition(key []byte, pos *data.LogRecordPos) string {
+	dataFile := v.files[pos.Fid]
+	if dataFile == nil {
+		return "points to a missing or unreadable data file"
+	}
+	if pos.Offset < dataFile.HeaderSize() {
+		return "points into the data file header"
+	}
+	logRecord, size, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return fmt.Sprintf("points to an unreadable log record: %v", err)
+	}
+	if realKey, _ := parseLogRecordKey(logRecord.Key); !bytes.Equal(realKey, key) {
+		return fmt.Sprintf("points to the log record of key %q", realKey)
+	}
+	if logRecord.Type != data.LogRecordNormal {
+		return "points to a deleted log record"
+	}
+	// 旧版本的位置索引中没有数据的大小
+	if pos.Size != 0 && int64(pos.Size) != size {
+		return fmt.Sprintf("has size %d but the log record size is %d", pos.Size, size)
+	}
+	return ""
+}
+
+// 读取所有的数据文件并校验 crc，同时按照启动时的方式重放数据
+func (v *verifier) checkDataFiles(hasMerge bool, nonMergeFileId uint32) (*logReplayer, error) {
+	replayer := newLogReplayer(v.db)
+	for i, fileReport := range v.report.Files {
+		dataFile := v.files[fileReport.FileId]
+		if dataFile == nil {
+			continue
+		}
+		fileName := filepath.Base(data.GetDataFileName(v.db.options.DirPath, dataFile.FileId))
+		isActiveFile := i == len(v.report.Files)-1
+		// 比最近未参与 merge 的文件 id 更小的文件，索引从 hint 文件中加载
+		needReplay := !hasMerge || dataFile.FileId >= nonMergeFileId
+
+		offset := dataFile.HeaderSize()
+		for offset < fileReport.Size {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF || err == data.ErrInvalidCRC {
+				problem, err := v.badTailProblem(dataFile, fileName, offset, size, fileReport.Size, isActiveFile, err)
+				if err != nil {
+					return nil, err
+				}
+				v.addProblem(problem)
+				break
+			}
+			if err != nil {
+				if size == 0 {
+					return nil, err
+				}
+				v.addProblem(&VerifyProblem{
+					Kind:    ProblemUnreadableRecord,
+					File:    fileName,
+					Fid:     dataFile.FileId,
+					Offset:  offset,
+					Message: err.Error(),
+				})
+				offset += size
+				continue
+			}
+
+			fileReport.Records++
+			if needReplay {
+				pos := &data.LogRecordPos{
+					Fid:    dataFile.FileId,
+					Offset: offset,
+					Expire: logRecord.Expire,
+					Size:   uint32(size),
+				}
+				if err := replayer.replay(logRecord, pos); err != nil {
+					return nil, err
+				}
+			}
+			offset += size
+		}
+	}
+	return replayer, nil
+}
+
+// 数据文件末尾的数据不完整，或者 crc 校验失败，之后的数据都无法读取
+// 只有活跃文件的最后一条数据损坏时，启动时才会截断，数据的长度超出文件末尾但是之后还有完整的数据时，说明长度损坏
+func (v *verifier) badTailProblem(dataFile *data.DataFile, fileName string, offset int64, size int64, fileSize int64, isActiveFile bool, err error) (*VerifyProblem, error) {
+	problem := &VerifyProblem{File: fileName, Fid: dataFile.FileId, Offset: offset}
+	isTail := offset+size >= fileSize
+	if err == io.EOF {
+		followed, scanErr := logRecordsFollow(dataFile, offset, fileSize)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		isTail = !followed
+	}
+	switch {
+	case isTail && isActiveFile:
+		problem.Kind = ProblemTornTail
+		problem.Message = fmt.Sprintf("%d bytes of torn write at the end of the active file", fileSize-offset)
+		problem.Recoverable = true
+	case err == io.EOF && isTail:
+		problem.Kind = ProblemTruncated
+		problem.Message = fmt.Sprintf("%d bytes of incomplete log record at the end of the data file", fileSize-offset)
+	case err == io.EOF:
+		problem.Kind = ProblemCorruptedLength
+		problem.Message = fmt.Sprintf("the length of the log record is corrupted, %d bytes after the offset cannot be read", fileSize-offset)
+	default:
+		problem.Kind = ProblemInvalidCRC
+		problem.Message = fmt.Sprintf("invalid crc, %d bytes after the offset cannot be read", fileSize-offset)
+	}
+	return problem, nil
+}
+
+// 没有读取到完成标识的事务，启动时会被丢弃
+func (v *verifier) checkPendingTxns(replayer *logReplayer) {
+	for seqNo, txnRecords := range replayer.transactionRecords {
+		pos := txnRecords[0].Pos
+		v.addProblem(&VerifyProblem{
+			Kind:        ProblemIncompleteTxn,
+			File:        filepath.Base(data.GetDataFileName(v.db.options.DirPath, pos.Fid)),
+			Fid:         pos.Fid,
+			Offset:      pos.Offset,
+			Message:     fmt.Sprintf("transaction %d has %d log records but no finished record", seqNo, len(txnRecords)),
+			Recoverable: true,
+		})
+	}
+}
+
+// 事务序列号文件中的序列号不能比数据文件中已经使用过的序列号小
+// 只有 B+ 树索引在启动时从这个文件中读取事务序列号，其他索引类型不需要检查
+func (v *verifier) checkSeqNo(maxSeqNo uint64) {
+	if _, err := os.Stat(filepath.Join(v.db.options.DirPath, index.BPlusTreeIndexFileName)); err != nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(v.db.options.DirPath, data.SeqNoFileName)); err != nil {
+		return
+	}
+	problem := &VerifyProblem{Kind: ProblemInvalidSeqNo, File: data.SeqNoFileName}
+	seqNoFile, err := data.OpenSeqNoFile(v.db.options.DirPath)
+	if err != nil {
+		problem.Message = err.Error()
+		v.addProblem(problem)
+		return
+	}
+	seqNoFile.Cipher = v.db.cipher
+	record, _, err := seqNoFile.ReadLogRecord(0)
+	_ = seqNoFile.Close()
+	if err != nil {
+		problem.Message = err.Error()
+		v.addProblem(problem)
+		return
+	}
+	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
+	if err != nil {
+		problem.Message = err.Error()
+		v.addProblem(problem)
+		return
+	}
+	if seqNo < maxSeqNo {
+		problem.Message = fmt.Sprintf("seq no %d is smaller than the seq no %d used in data files", seqNo, maxSeqNo)
+		v.addProblem(problem)
+	}
+}
+
+// 将 B+ 树索引和重建的索引进行比对，已经过期的数据不比较
+func (v *verifier) checkBPlusTreeIndex() {
+	if _, err := os.Stat(filepath.Join(v.db.options.DirPath, index.BPlusTreeIndexFileName)); err != nil {
+		return
+	}
+	bptIndex, err := index.ReadBPlusTreeIndex(v.db.options.DirPath)
+	if err != nil {
+		v.addProblem(&VerifyProblem{
+			Kind:    ProblemBPlusTreeMismatch,
+			File:    index.BPlusTreeIndexFileName,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	iterator := bptIndex.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired() {
+			continue
+		}
+		expected := v.db.index.Get(iterator.Key())
+		if expected != nil && expected.Fid == pos.Fid && expected.Offset == pos.Offset {
+			continue
+		}
+		msg := fmt.Sprintf("key %q is deleted or not committed", iterator.Key())
+		if expected != nil {
+			msg = fmt.Sprintf("key %q points to a stale log record, the latest one is at fid %d offset %d", iterator.Key(), expected.Fid, expected.Offset)
+		}
+		v.addProblem(&VerifyProblem{
+			Kind:    ProblemBPlusTreeMismatch,
+			File:    index.BPlusTreeIndexFileName,
+			Fid:     pos.Fid,
+			Offset:  pos.Offset,
+			Message: msg,
+		})
+	}
+	iterator.Close()
+
+	iterator = v.db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired() || bptIndex.Get(iterator.Key()) != nil {
+			continue
+		}
+		v.addProblem(&VerifyProblem{
+			Kind:    ProblemBPlusTreeMismatch,
+			File:    index.BPlusTreeIndexFileName,
+			Fid:     pos.Fid,
+			Offset:  pos.Offset,
+			Message: fmt.Sprintf("key %q is missing from the index", iterator.Key()),
+		})
+	}
+	iterator.Close()
+}
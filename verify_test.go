@@ -0,0 +1,214 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 打开数据库写入数据，merge 之后再写入一些数据和事务
+func prepareVerifyDB(t *testing.T, opts Options) *DB {
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	// 重新打开之后 B+ 树索引才可以使用 WriteBatch
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 1500; i < 2500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, wb.Commit())
+	return db
+}
+
+func findProblems(report *VerifyReport, kind VerifyProblemKind) []*VerifyProblem {
+	var problems []*VerifyProblem
+	for _, problem := range report.Problems {
+		if problem.Kind == kind {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+func TestVerify(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db := prepareVerifyDB(t, opts)
+	defer destroyDB(db)
+
+	// 数据目录正在被使用
+	_, err := Verify(dir)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	activeFileId, seqNo := db.activeFile.FileId, db.seqNo
+	assert.Nil(t, db.Close())
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 0, len(report.Problems))
+	assert.True(t, len(report.Files) > 1)
+	for _, file := range report.Files {
+		assert.Equal(t, data.CurrentFileVersion, file.Version)
+	}
+	_, err = os.Stat(filepath.Join(dir, data.HintFileName))
+	assert.Nil(t, err)
+
+	// 事务没有写入完成标识，并且活跃文件末尾的数据只写入了一半
+	activeFileName := data.GetDataFileName(dir, activeFileId)
+	stat, err := os.Stat(activeFileName)
+	assert.Nil(t, err)
+	txnRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("txn-key"), seqNo),
+		Value: []byte("txn-value"),
+	})
+	appendFile(t, activeFileName, txnRecord)
+	appendFile(t, activeFileName, txnRecord[:len(txnRecord)/2])
+
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	incompleteTxns := findProblems(report, ProblemIncompleteTxn)
+	assert.Equal(t, 1, len(incompleteTxns))
+	assert.Equal(t, activeFileId, incompleteTxns[0].Fid)
+	assert.Equal(t, stat.Size(), incompleteTxns[0].Offset)
+	tornTails := findProblems(report, ProblemTornTail)
+	assert.Equal(t, 1, len(tornTails))
+	assert.Equal(t, stat.Size()+int64(len(txnRecord)), tornTails[0].Offset)
+
+	// 旧的数据文件中的数据损坏
+	olderFileName := data.GetDataFileName(dir, activeFileId-1)
+	buf, err := os.ReadFile(olderFileName)
+	assert.Nil(t, err)
+	buf[data.FileHeaderSize+10]++
+	assert.Nil(t, os.WriteFile(olderFileName, buf, 0644))
+
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	invalidCRCs := findProblems(report, ProblemInvalidCRC)
+	assert.Equal(t, 1, len(invalidCRCs))
+	assert.Equal(t, activeFileId-1, invalidCRCs[0].Fid)
+	assert.Equal(t, int64(data.FileHeaderSize), invalidCRCs[0].Offset)
+	assert.False(t, invalidCRCs[0].Recoverable)
+}
+
+func TestVerify_Hint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db := prepareVerifyDB(t, opts)
+	defer destroyDB(db)
+	pos := db.index.Get(utils.GetTestKey(1200))
+	assert.Nil(t, db.Close())
+
+	// hint 文件中的索引指向其他 key 的数据
+	hintFile, err := data.OpenHintFile(dir)
+	assert.Nil(t, err)
+	wrongPos := *pos
+	wrongPos.Offset = data.FileHeaderSize
+	assert.Nil(t, hintFile.WriteHintRecord([]byte("hint-key"), &wrongPos))
+	assert.Nil(t, hintFile.Close())
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	problems := findProblems(report, ProblemHintMismatch)
+	assert.Equal(t, 1, len(problems))
+	assert.Equal(t, pos.Fid, problems[0].Fid)
+	assert.Equal(t, int64(data.FileHeaderSize), problems[0].Offset)
+}
+
+func TestVerify_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-bptree")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = BPlusTree
+	opts.MMapAtStartup = false
+	db := prepareVerifyDB(t, opts)
+	defer destroyDB(db)
+	assert.Nil(t, db.Close())
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.Problems))
+
+	// B+ 树索引中缺少 key，或者指向旧的数据
+	bpt := index.NewBPlusTree(dir, true)
+	pos := bpt.Get(utils.GetTestKey(1500))
+	assert.NotNil(t, pos)
+	bpt.Delete(utils.GetTestKey(1500))
+	stalePos := bpt.Get(utils.GetTestKey(1600))
+	stalePos.Offset = data.FileHeaderSize
+	bpt.Put(utils.GetTestKey(1600), stalePos)
+	assert.Nil(t, bpt.Close())
+
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	problems := findProblems(report, ProblemBPlusTreeMismatch)
+	assert.Equal(t, 2, len(problems))
+	var offsets []int64
+	for _, problem := range problems {
+		offsets = append(offsets, problem.Offset)
+	}
+	assert.Contains(t, offsets, pos.Offset)
+	assert.Contains(t, offsets, int64(data.FileHeaderSize))
+}
+
+func TestVerify_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-encryption")
+	opts.DirPath = dir
+	opts.KeyProvider = &data.StaticKeyProvider{
+		Current: 1,
+		Keys:    map[uint32][]byte{1: bytes.Repeat([]byte("k"), 32)},
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 没有提供密钥时 crc 校验通过，但是无法解密
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 10, len(findProblems(report, ProblemUnreadableRecord)))
+
+	report, err = VerifyWithOptions(dir, VerifyOptions{KeyProvider: opts.KeyProvider})
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 0, len(report.Problems))
+	assert.Equal(t, 10, report.Files[0].Records)
+}
+
+func appendFile(t *testing.T, fileName string, buf []byte) {
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+}
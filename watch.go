@@ -0,0 +1,149 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// WatchEventType 数据变更事件的类型
+type WatchEventType = byte
+
+const (
+	WatchPut WatchEventType = iota + 1
+	WatchDelete
+)
+
+// WatchEvent 数据变更事件，在数据写入数据文件并更新内存索引之后产生
+// 过期的 key 不会产生删除事件，merge 也不会产生事件
+type WatchEvent struct {
+	Type   WatchEventType
+	Key    []byte
+	Value  []byte // 删除事件的 Value 为空
+	Expire int64  // 过期时间，为 0 表示不过期
+}
+
+// Watcher 订阅前缀为指定值的 key 的变更事件
+type Watcher struct {
+	db        *DB
+	prefix    []byte
+	options   WatchOptions
+	events    chan *WatchEvent
+	done      chan struct{} // 关闭之后不再投递事件，阻塞的投递立即返回
+	closeOnce sync.Once
+	dropped   uint64
+}
+
+// Watch 订阅前缀为 prefix 的 key 的变更事件，prefix 为空时订阅所有的 key
+// 同一个 WriteBatch 或者事务中的数据全部写入之后才会投递，事件的顺序和写入的顺序一致
+// 使用 WatchBlock 时，消费事件的协程不能写数据库，否则缓冲区已满时会和写入相互等待
+// 使用 WatchBlock 的订阅者消费得慢时会限制整个数据库的写入速度，见 WatchBlock 的说明
+func (db *DB) Watch(prefix []byte, opts WatchOptions) *Watcher {
+	w := &Watcher{
+		db:      db,
+		prefix:  prefix,
+		options: opts,
+		events:  make(chan *WatchEvent, opts.BufferSize),
+		done:    make(chan struct{}),
+	}
+	db.mu.Lock()
+	db.watchers[w] = struct{}{}
+	db.mu.Unlock()
+	return w
+}
+
+// Events 返回接收事件的 channel，Watcher 关闭之后 channel 也会关闭
+func (w *Watcher) Events() <-chan *WatchEvent {
+	return w.events
+}
+
+// Dropped 返回使用 WatchDrop 时因为缓冲区已满而丢弃的事件数量
+func (w *Watcher) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close 取消订阅，可以重复调用
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.db.mu.Lock()
+		delete(w.db.watchers, w)
+		w.db.mu.Unlock()
+
+		// 等待正在进行的投递结束之后再关闭 channel
+		w.db.watchMu.Lock()
+		close(w.events)
+		w.db.watchMu.Unlock()
+	})
+}
+
+func (w *Watcher) send(event *WatchEvent) {
+	if w.options.Policy == WatchBlock {
+		select {
+		case w.events <- event:
+		case <-w.done:
+		}
+		return
+	}
+	select {
+	case w.events <- event:
+	case <-w.done:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// 记录一个变更事件，在释放互斥锁时投递。该方法必须持有互斥锁
+func (db *DB) addWatchEvent(eventType WatchEventType, key []byte, value []byte, expire int64) {
+	if len(db.watchers) == 0 {
+		return
+	}
+	event := &WatchEvent{Type: eventType, Key: append([]byte(nil), key...), Expire: expire}
+	if eventType == WatchPut {
+		event.Value = append([]byte{}, value...)
+	}
+	db.watchEvents = append(db.watchEvents, event)
+}
+
+// 释放互斥锁，并投递持有锁期间产生的事件
+// 投递之前先获取 watchMu 再释放互斥锁，保证事件的顺序和写入的顺序一致
+// 投递在 watchMu 中串行执行，使用 WatchBlock 的订阅者阻塞时，下一个写入会持有互斥锁在这里等待 watchMu，
+// 此时其他的读写也会等待，因此整个数据库的写入以及所有订阅者收到事件的速度都受限于最慢的订阅者
+func (db *DB) unlockAndNotify() {
+	events := db.watchEvents
+	if len(events) == 0 {
+		db.mu.Unlock()
+		return
+	}
+	db.watchEvents = nil
+	watchers := make([]*Watcher, 0, len(db.watchers))
+	for w := range db.watchers {
+		watchers = append(watchers, w)
+	}
+
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	db.mu.Unlock()
+
+	for _, event := range events {
+		for _, w := range watchers {
+			if bytes.HasPrefix(event.Key, w.prefix) {
+				w.send(event)
+			}
+		}
+	}
+}
+
+// 关闭所有的订阅
+func (db *DB) closeWatchers() {
+	db.mu.RLock()
+	watchers := make([]*Watcher, 0, len(db.watchers))
+	for w := range db.watchers {
+		watchers = append(watchers, w)
+	}
+	db.mu.RUnlock()
+
+	for _, w := range watchers {
+		w.Close()
+	}
+}
@@ -0,0 +1,197 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	w := db.Watch([]byte("user:"), DefaultWatchOptions)
+	defer w.Close()
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("alice")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("ignored")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+	// 不存在的 key 不会产生事件
+	assert.Nil(t, db.Delete([]byte("user:2")))
+	_, err = db.IncrBy([]byte("user:count"), 2)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:3"), []byte("bob")))
+	assert.Nil(t, wb.Put([]byte("order:2"), []byte("ignored")))
+	assert.Nil(t, wb.Commit())
+
+	event := <-w.Events()
+	assert.Equal(t, WatchPut, event.Type)
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.Equal(t, []byte("alice"), event.Value)
+	event = <-w.Events()
+	assert.Equal(t, WatchDelete, event.Type)
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.Nil(t, event.Value)
+	event = <-w.Events()
+	assert.Equal(t, []byte("user:count"), event.Key)
+	assert.Equal(t, []byte("2"), event.Value)
+	event = <-w.Events()
+	assert.Equal(t, WatchPut, event.Type)
+	assert.Equal(t, []byte("user:3"), event.Key)
+	assert.Equal(t, []byte("bob"), event.Value)
+	assert.Equal(t, 0, len(w.Events()))
+
+	// 关闭之后 channel 也会关闭
+	w.Close()
+	assert.Nil(t, db.Put([]byte("user:4"), []byte("carol")))
+	_, ok := <-w.Events()
+	assert.False(t, ok)
+}
+
+func TestDB_Watch_Drop(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-drop")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	w := db.Watch(nil, WatchOptions{BufferSize: 2, Policy: WatchDrop})
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte("v")))
+	}
+	assert.Equal(t, uint64(3), w.Dropped())
+	assert.Equal(t, []byte{0}, (<-w.Events()).Key)
+	assert.Equal(t, []byte{1}, (<-w.Events()).Key)
+}
+
+func TestDB_Watch_Block(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-block")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	w := db.Watch(nil, WatchOptions{BufferSize: 1, Policy: WatchBlock})
+	defer w.Close()
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+
+	// 缓冲区已满，写入阻塞直到事件被取走
+	done := make(chan struct{})
+	go func() {
+		assert.Nil(t, db.Put([]byte("b"), []byte("2")))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("put should block until the event is consumed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 阻塞时不影响读取
+	val, err := db.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	assert.Equal(t, []byte("a"), (<-w.Events()).Key)
+	<-done
+	assert.Equal(t, []byte("b"), (<-w.Events()).Key)
+
+	// 关闭订阅之后阻塞的写入立即返回
+	assert.Nil(t, db.Put([]byte("c"), []byte("3")))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		w.Close()
+	}()
+	assert.Nil(t, db.Put([]byte("d"), []byte("4")))
+}
+
+func TestDB_Watch_Block_Contention(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-block-contention")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 一个消费得很慢的订阅者和一个消费得很快的订阅者
+	slow := db.Watch(nil, WatchOptions{BufferSize: 1, Policy: WatchBlock})
+	defer slow.Close()
+	fast := db.Watch(nil, WatchOptions{BufferSize: 1024, Policy: WatchBlock})
+	defer fast.Close()
+
+	const writers, writesPerWriter = 4, 50
+	total := writers * writesPerWriter
+	collect := func(w *Watcher, delay time.Duration) chan []string {
+		result := make(chan []string, 1)
+		go func() {
+			var keys []string
+			for len(keys) < total {
+				keys = append(keys, string((<-w.Events()).Key))
+				time.Sleep(delay)
+			}
+			result <- keys
+		}()
+		return result
+	}
+	slowKeys, fastKeys := collect(slow, time.Millisecond), collect(fast, 0)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writesPerWriter; j++ {
+				assert.Nil(t, db.Put([]byte(fmt.Sprintf("writer-%d-%03d", i, j)), []byte("v")))
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 所有的写入都要等待最慢的订阅者取走事件
+	assert.True(t, time.Since(start) >= time.Duration(total-2)*time.Millisecond)
+
+	// 两个订阅者收到的事件顺序相同，并且同一个协程写入的 key 的顺序和写入的顺序一致
+	keys1, keys2 := <-slowKeys, <-fastKeys
+	assert.Equal(t, keys1, keys2)
+	last := make(map[string]string)
+	for _, key := range keys1 {
+		writer := key[:len("writer-0")]
+		assert.True(t, last[writer] < key, key)
+		last[writer] = key
+	}
+	assert.Equal(t, writers, len(last))
+}
+
+func TestDB_Watch_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	w := db.Watch(nil, DefaultWatchOptions)
+	txn := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, txn.Put([]byte("k"), []byte("v")))
+	assert.Equal(t, 0, len(w.Events()))
+	assert.Nil(t, txn.Commit())
+	event := <-w.Events()
+	assert.Equal(t, []byte("k"), event.Key)
+
+	// 关闭数据库时关闭所有的订阅
+	assert.Nil(t, db.Close())
+	_, ok := <-w.Events()
+	assert.False(t, ok)
+}